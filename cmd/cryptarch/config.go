@@ -0,0 +1,326 @@
+//
+// Settings from configuration files and the environment.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spacez320/cryptarch"
+	"github.com/spacez320/cryptarch/internal/lib"
+	"github.com/spacez320/cryptarch/pkg/storage"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CONFIG_ENV_PATH   = "CRYPTARCH_CONFIG" // Environment variable for a configuration file path.
+	CONFIG_ENV_PREFIX = "CRYPTARCH_"       // Prefix for environment variable settings.
+)
+
+var (
+	// Flags that can't be provided by configuration files or the environment.
+	configExcludedFlags = []string{"config", "version"}
+	// Flags that accept comma delimited values, which may be provided as lists.
+	configListFlags = []string{"filters", "labels", "rows"}
+)
+
+// Retrieves the type of value a flag expects.
+func flagType(f *flag.Flag) string {
+	if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && boolFlag.IsBoolFlag() {
+		return "bool"
+	}
+	if name, _ := flag.UnquoteUsage(f); name != "" && name != "value" {
+		return name
+	}
+
+	return "string"
+}
+
+// Sets a flag from a configuration source, describing any bad values.
+func setFlag(flags *flag.FlagSet, f *flag.Flag, value string) error {
+	if err := flags.Set(f.Name, value); err != nil {
+		return fmt.Errorf("Bad value %q for %q, expected type %s", value, f.Name, flagType(f))
+	}
+
+	return nil
+}
+
+// Retrieves the name of the environment variable for a flag, e.g. 'CRYPTARCH_LOG_LEVEL' for
+// 'log-level'.
+func envName(name string) string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Retrieves the path to the configuration file, and whether or not it must exist. A path provided
+// by flag or environment variable must exist, but the default one in the user configuration
+// directory is optional.
+func configPath(flagPath string) (string, bool, error) {
+	if flagPath != "" {
+		return flagPath, true, nil
+	}
+	if envPath := os.Getenv(CONFIG_ENV_PATH); envPath != "" {
+		return envPath, true, nil
+	}
+
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		// There's nowhere to look for a default configuration file.
+		return "", false, nil
+	}
+
+	return filepath.Join(userConfigDir, CONFIG_FILE_DIR, CONFIG_FILE_NAME), false, nil
+}
+
+// Sets any flags not already set from environment variables. Set flags are recorded.
+func loadConfigEnv(flags *flag.FlagSet, set map[string]bool) (err error) {
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || slices.Contains(configExcludedFlags, f.Name) {
+			return
+		}
+
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if setErr := setFlag(flags, f, value); setErr != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), setErr)
+				return
+			}
+			set[f.Name] = true
+		}
+	})
+
+	return
+}
+
+// Parses a query definition from a YAML mapping of settings to values, where values may be a single
+// value or a list.
+func yamlQueryDef(path string, node *yaml.Node) (map[string][]string, error) {
+	queryDef := make(map[string][]string)
+
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: Expected a mapping of query settings", path, node.Line)
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		if err := checkQueryDefKey(key.Value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, key.Line, err)
+		}
+		switch value.Kind {
+		case yaml.ScalarNode:
+			queryDef[key.Value] = append(queryDef[key.Value], value.Value)
+		case yaml.SequenceNode:
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%s:%d: Expected a list of values for %q",
+						path, item.Line, key.Value)
+				}
+				queryDef[key.Value] = append(queryDef[key.Value], item.Value)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: Expected a value or list of values for %q",
+				path, value.Line, key.Value)
+		}
+	}
+
+	return queryDef, nil
+}
+
+// Sets any flags not already set from a YAML configuration file. Keys are flag names, and values
+// are either a single value, a list for flags accepting multiple or comma delimited values, a
+// mapping of queries to seconds for 'query-timeout', or a list of mappings for 'query-def'. Set
+// flags are recorded.
+func loadConfigFile(flags *flag.FlagSet, path string, set map[string]bool) error {
+	var (
+		document yaml.Node // Parsed configuration file.
+	)
+
+	configData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = yaml.Unmarshal(configData, &document); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(document.Content) == 0 {
+		// The file is empty.
+		return nil
+	}
+
+	settings := document.Content[0]
+	if settings.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: Expected a mapping of settings", path, settings.Line)
+	}
+
+	for i := 0; i < len(settings.Content); i += 2 {
+		var (
+			key   = settings.Content[i]   // Setting name.
+			node  = settings.Content[i+1] // Setting value.
+			value []string                // Values to set.
+
+			queryDefValues []map[string][]string // Query definitions to set.
+		)
+
+		f := flags.Lookup(key.Value)
+		if f == nil || slices.Contains(configExcludedFlags, key.Value) {
+			return fmt.Errorf("%s:%d: Unknown setting %q", path, key.Line, key.Value)
+		}
+
+		_, isMulti := f.Value.(*multiArg)
+		defs, isQueryDefs := f.Value.(*queryDefs)
+		switch {
+		case node.Kind == yaml.ScalarNode && node.Tag == "!!null":
+			// Nothing is set.
+		case node.Kind == yaml.SequenceNode && isQueryDefs:
+			for _, item := range node.Content {
+				queryDef, err := yamlQueryDef(path, item)
+				if err != nil {
+					return err
+				}
+				queryDefValues = append(queryDefValues, queryDef)
+			}
+		case node.Kind == yaml.ScalarNode:
+			value = []string{node.Value}
+		case node.Kind == yaml.SequenceNode && (isMulti || slices.Contains(configListFlags, f.Name)):
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("%s:%d: Expected a list of values for %q", path, item.Line, f.Name)
+				}
+				value = append(value, item.Value)
+			}
+			if !isMulti {
+				value = []string{strings.Join(value, ",")}
+			}
+		case node.Kind == yaml.MappingNode && f.Name == "query-timeout":
+			for j := 0; j < len(node.Content); j += 2 {
+				query, seconds := node.Content[j], node.Content[j+1]
+				value = append(value, fmt.Sprintf("%s=%s", query.Value, seconds.Value))
+			}
+		default:
+			return fmt.Errorf("%s:%d: Expected a single value for %q", path, node.Line, f.Name)
+		}
+
+		if set[f.Name] {
+			// Already set by something that takes precedence.
+			continue
+		}
+		if isQueryDefs {
+			*defs = append(*defs, queryDefValues...)
+		}
+		for _, v := range value {
+			if err := setFlag(flags, f, v); err != nil {
+				return fmt.Errorf("%s:%d: %v", path, node.Line, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Loads settings from the environment and a configuration file. Flags provided on the command line
+// take precedence over environment variables, which take precedence over the configuration file.
+func loadConfig(flags *flag.FlagSet, flagPath string) error {
+	var (
+		set = make(map[string]bool) // Flags that have been set.
+	)
+
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if err := loadConfigEnv(flags, set); err != nil {
+		return err
+	}
+
+	path, required, err := configPath(flagPath)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return nil
+	}
+	err = loadConfigFile(flags, path, set)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+
+	return err
+}
+
+// Validates settings, regardless of where they were provided.
+func validateConfig() error {
+	if _, ok := logLevelStrToSlogLevel[logLevel]; !ok {
+		return fmt.Errorf("Bad value %q for \"log-level\", expected one of debug, info, warn, error",
+			logLevel)
+	}
+	if mode < int(cryptarch.MODE_QUERY) || mode > int(cryptarch.MODE_HISTORY) {
+		return fmt.Errorf("Bad value %d for \"mode\", expected %d to %d",
+			mode, cryptarch.MODE_QUERY, cryptarch.MODE_HISTORY)
+	}
+	if displayMode < int(lib.DISPLAY_MODE_RAW) || displayMode > int(lib.DISPLAY_MODE_GRAPH) {
+		return fmt.Errorf("Bad value %d for \"display\", expected %d to %d",
+			displayMode, lib.DISPLAY_MODE_RAW, lib.DISPLAY_MODE_GRAPH)
+	}
+	retention := storage.Retention{
+		MaxAge:         retentionAge,
+		MaxResultBytes: retentionResultBytes,
+		MaxCount:       retentionCount,
+		RollupMaxAge:   retentionRollupAge,
+	}
+	if err := validateQuerySettings(count, delay, timeout, retention); err != nil {
+		return err
+	}
+	if !slices.Contains(storage.RollupAggregates, rollupAggregate) {
+		return fmt.Errorf("Bad value %q for \"rollup-aggregate\", expected one of: %s",
+			rollupAggregate, strings.Join(storage.RollupAggregates, ", "))
+	}
+	if err := storage.ValidateDatabaseName(storageName); err != nil {
+		return err
+	}
+	if storageBackend != "" && !slices.Contains(storage.Backends, storageBackend) {
+		return fmt.Errorf("Bad value %q for \"storage-backend\", expected one of: %s",
+			storageBackend, strings.Join(storage.Backends, ", "))
+	}
+	if importFormat != "" && !slices.Contains(lib.ImportFormats, importFormat) {
+		return fmt.Errorf("Bad value %q for \"import-format\", expected one of: %s",
+			importFormat, strings.Join(lib.ImportFormats, ", "))
+	}
+	if _, err := lib.NewParser(parser); err != nil {
+		return fmt.Errorf("Bad value %q for \"parser\": %v", parser, err)
+	}
+
+	return nil
+}
+
+// Validates settings that may be given for each query, either generally or in query definitions.
+func validateQuerySettings(count, delay, timeout int, retention storage.Retention) error {
+	if count == 0 || count < -1 {
+		return fmt.Errorf("Bad value %d for \"count\", expected a positive number or -1", count)
+	}
+	if delay < 0 {
+		return fmt.Errorf("Bad value %d for \"delay\", expected zero or more", delay)
+	}
+	if timeout < 0 {
+		return fmt.Errorf("Bad value %d for \"timeout\", expected zero or more", timeout)
+	}
+	if retention.MaxAge < 0 {
+		return fmt.Errorf("Bad value %s for \"retention-age\", expected zero or more", retention.MaxAge)
+	}
+	if retention.MaxResultBytes < 0 {
+		return fmt.Errorf(
+			"Bad value %d for \"retention-result-bytes\", expected zero or more",
+			retention.MaxResultBytes)
+	}
+	if retention.MaxCount < 0 {
+		return fmt.Errorf(
+			"Bad value %d for \"retention-count\", expected zero or more", retention.MaxCount)
+	}
+	if retention.RollupMaxAge < 0 {
+		return fmt.Errorf(
+			"Bad value %s for \"retention-rollup-age\", expected zero or more", retention.RollupMaxAge)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Test settings, set by flags.
+type testSettings struct {
+	count     int
+	history   bool
+	labels    string
+	logLevel  string
+	queries   multiArg
+	queryDefs queryDefs
+	timeouts  multiArg
+}
+
+// Builds a test flag set.
+func testFlags() (*flag.FlagSet, *testSettings) {
+	var (
+		flags    = flag.NewFlagSet("test", flag.ContinueOnError)
+		settings = testSettings{}
+	)
+
+	flags.BoolVar(&settings.history, "history", true, "")
+	flags.IntVar(&settings.count, "count", 1, "")
+	flags.StringVar(&settings.labels, "labels", "", "")
+	flags.StringVar(&settings.logLevel, "log-level", "error", "")
+	flags.Var(&settings.queries, "query", "")
+	flags.Var(&settings.queryDefs, "query-def", "")
+	flags.Var(&settings.timeouts, "query-timeout", "")
+	flags.String("version", "", "")
+
+	return flags, &settings
+}
+
+// Writes a test configuration file.
+func testConfigFile(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), CONFIG_FILE_NAME)
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	flags, settings := testFlags()
+	path := testConfigFile(t, `
+count: -1
+history: false
+labels: [foo, bar]
+query:
+  - uptime
+  - whoami
+query-def:
+  - name: disk
+    labels: [used, free]
+    query: df -h
+query-timeout:
+  uptime: 5
+`)
+
+	if err := loadConfigFile(flags, path, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := testSettings{
+		count:    -1,
+		history:  false,
+		labels:   "foo,bar",
+		logLevel: "error",
+		queries:  multiArg{"uptime", "whoami"},
+		queryDefs: queryDefs{
+			{"labels": {"used", "free"}, "name": {"disk"}, "query": {"df -h"}},
+		},
+		timeouts: multiArg{"uptime=5"},
+	}
+	if !reflect.DeepEqual(*settings, expected) {
+		t.Errorf("Got: %v Expected: %v\n", *settings, expected)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for config, expected := range map[string]string{
+		"count: 1\nfoo: bar\n":       ":2: Unknown setting \"foo\"",
+		"version: true\n":            ":1: Unknown setting \"version\"",
+		"count: foo\n":               ":1: Bad value \"foo\" for \"count\", expected type int",
+		"history: [true]\n":          ":1: Expected a single value for \"history\"",
+		"- count\n":                  ":1: Expected a mapping of settings",
+		"query:\n  - [uptime]\n":     ":2: Expected a list of values for \"query\"",
+		"query-def:\n  - foo: bar\n": ":2: Unknown query definition setting \"foo\"",
+	} {
+		flags, _ := testFlags()
+		err := loadConfigFile(flags, testConfigFile(t, config), map[string]bool{})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Got: %v Expected: %v\n", err, expected)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	flags, settings := testFlags()
+	path := testConfigFile(t, "count: 3\nlog-level: debug\nquery: [uptime]\n")
+	t.Setenv("CRYPTARCH_LOG_LEVEL", "info")
+	t.Setenv("CRYPTARCH_QUERY", "whoami")
+
+	// Flags take precedence over the environment, which takes precedence over the file.
+	if err := flags.Parse([]string{"-query", "date"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(flags, path); err != nil {
+		t.Fatal(err)
+	}
+	expected := testSettings{
+		count:    3,
+		history:  true,
+		logLevel: "info",
+		queries:  multiArg{"date"},
+	}
+	if !reflect.DeepEqual(*settings, expected) {
+		t.Errorf("Got: %v Expected: %v\n", *settings, expected)
+	}
+
+	// A provided configuration file must exist.
+	flags, _ = testFlags()
+	if err := loadConfig(flags, filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, os.ErrNotExist)
+	}
+
+	// Environment variables with bad values are reported.
+	flags, _ = testFlags()
+	t.Setenv("CRYPTARCH_COUNT", "foo")
+	err := loadConfig(flags, path)
+	if expected := "CRYPTARCH_COUNT: Bad value"; err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("Got: %v Expected: %v\n", err, expected)
+	}
+}
@@ -0,0 +1,109 @@
+//
+// Sub-command for managing daemons.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spacez320/cryptarch"
+	"github.com/spacez320/cryptarch/internal/lib"
+)
+
+const (
+	DAEMON_COMMAND = "daemon" // Sub-command for managing daemons.
+)
+
+// Prints usage for the daemon sub-command.
+func daemonUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: %[1]s %[2]s <command> [arguments]
+
+Manage Cryptarch daemons, started with '%[1]s -daemon'.
+
+Commands:
+  list                    List running daemons.
+  attach <name> [flags]   Attach to a daemon, viewing its results. Flags are the same as for
+                          read mode, e.g. '-display 3'.
+  stop <name> [name...]   Stop daemons.
+`, os.Args[0], DAEMON_COMMAND)
+}
+
+// Handles the daemon sub-command. Listing and stopping daemons exit when done. Attaching returns
+// arguments for executing in read mode against the daemon's socket.
+func daemonCommand(args []string) []string {
+	if len(args) < 3 {
+		daemonUsage()
+		os.Exit(1)
+	}
+
+	switch args[2] {
+	case "list":
+		daemons, err := lib.ListDaemons()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list daemons: %v\n", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tPID\tSTARTED\tQUERIES")
+		for _, daemon := range daemons {
+			started := "-"
+			if !daemon.Started.IsZero() {
+				started = daemon.Started.Format(time.DateTime)
+			}
+			fmt.Fprintf(
+				w, "%s\t%d\t%s\t%s\n",
+				daemon.Name, daemon.Pid, started, strings.Join(daemon.Queries, ", "))
+		}
+		w.Flush()
+		os.Exit(0)
+	case "attach":
+		if len(args) < 4 {
+			daemonUsage()
+			os.Exit(1)
+		}
+
+		socket, err := lib.DaemonSocket(args[3])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return append(
+			[]string{args[0], "-mode", strconv.Itoa(int(cryptarch.MODE_READ)), "-rpc-socket", socket},
+			args[4:]...,
+		)
+	case "stop":
+		if len(args) < 4 {
+			daemonUsage()
+			os.Exit(1)
+		}
+
+		failed := false
+		for _, name := range args[3:] {
+			if err := lib.StopDaemon(name); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Stopped daemon %s\n", name)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "-h", "-help", "--help", "help":
+		daemonUsage()
+		os.Exit(0)
+	default:
+		daemonUsage()
+		os.Exit(1)
+	}
+
+	return args
+}
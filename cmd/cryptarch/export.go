@@ -0,0 +1,105 @@
+//
+// Sub-command for exporting stored results.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spacez320/cryptarch/internal/lib"
+	"github.com/spacez320/cryptarch/pkg/storage"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	EXPORT_COMMAND = "export" // Sub-command for exporting stored results.
+)
+
+// Prints usage for the export sub-command.
+func exportUsage(flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, `Usage: %[1]s %[2]s [flags]
+
+Export results stored by previous executions, e.g. for spreadsheets or notebooks. Formats are:
+
+  csv      CSV with a header of labels, where queries share columns for labels.
+  ndjson   A JSON object per line for each result, with typed values.
+  columns  A JSON list of queries, each with a list of times and a list of values per label.
+
+Flags:
+`, os.Args[0], EXPORT_COMMAND)
+		flags.PrintDefaults()
+	}
+}
+
+// Handles the export sub-command. Exits on failure.
+func exportCommand(args []string) {
+	var (
+		exportFilters string   // Labels of values to export.
+		exportFrom    string   // Start of results to export.
+		exportQueries multiArg // Queries to export.
+		exportTo      string   // End of results to export.
+		format        string   // Format to export in.
+		output        string   // File to export to.
+
+		flags    = flag.NewFlagSet(EXPORT_COMMAND, flag.ExitOnError)
+		location storage.Location // Location of the database to export from.
+		now      = time.Now()
+		writer   = io.Writer(os.Stdout) // Where to export to.
+	)
+
+	flags.StringVar(&exportFilters, "filters", "", "Labels of values to export, separated by commas.")
+	flags.StringVar(&exportFrom, "from", "", "Start of results to export, either absolute (e.g. "+
+		"'2006-01-02 15:04:05') or relative to now (e.g. '-1h').")
+	flags.StringVar(&exportTo, "to", "", "End of results to export, like -from.")
+	flags.StringVar(&format, "format", storage.EXPORT_FORMAT_CSV,
+		fmt.Sprintf("Format to export in, one of: %s.", strings.Join(storage.ExportFormats, ", ")))
+	flags.StringVar(&output, "output", "", "File to export to. Defaults to standard output.")
+	flags.Var(&exportQueries, "query", "Query to export. Can be supplied multiple times. All "+
+		"queries are exported if none are provided.")
+	storageFlags(flags, &location.Name, &location.Path)
+	flags.Usage = exportUsage(flags)
+	flags.Parse(args[2:])
+
+	if !slices.Contains(storage.ExportFormats, format) {
+		fmt.Fprintf(os.Stderr, "Bad format %q, expected one of: %s\n",
+			format, strings.Join(storage.ExportFormats, ", "))
+		os.Exit(1)
+	}
+	startTime, err := parseHistoryTime(exportFrom, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	endTime, err := parseHistoryTime(exportTo, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if output != "" {
+		outputFile, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to export results: %v\n", err)
+			os.Exit(1)
+		}
+		defer outputFile.Close()
+		writer = outputFile
+	}
+
+	err = lib.Export(location, writer, format, storage.ExportSelection{
+		EndTime:   endTime,
+		Filters:   parseCommaDelimitedStrOrEmpty(exportFilters),
+		Queries:   exportQueries,
+		StartTime: startTime,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export results: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,105 @@
+//
+// Sub-command for presenting stored results.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spacez320/cryptarch"
+	"github.com/spacez320/cryptarch/internal/lib"
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
+
+const (
+	HISTORY_COMMAND = "history" // Sub-command for presenting stored results.
+)
+
+var (
+	// Layouts accepted for absolute times, in local time unless they include a zone.
+	historyTimeLayouts = []string{time.RFC3339, time.DateTime, "2006-01-02 15:04", time.DateOnly}
+)
+
+// Prints usage for the history sub-command.
+func historyUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: %[1]s %[2]s <command> [arguments]
+
+Present results stored by previous executions, without executing any queries.
+
+Commands:
+  list       List stored queries. Accepts -storage-path and -storage-name.
+  [flags]    Present stored results. Flags are the same as for history mode, e.g.
+             '-query uptime -from -1h -display 3'. All stored queries are presented if none are
+             provided.
+`, os.Args[0], HISTORY_COMMAND)
+}
+
+// Parses a time given as a flag, either absolute (e.g. '2024-01-01 12:00') or relative to now (e.g.
+// '-1h'). Empty times are zero.
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Bad relative time %q, expected e.g. '-1h30m'", value)
+		}
+		return now.Add(duration), nil
+	}
+	for _, layout := range historyTimeLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf(
+		"Bad time %q, expected e.g. '-1h', '2006-01-02 15:04:05', or '2006-01-02T15:04:05Z07:00'",
+		value)
+}
+
+// Handles the history sub-command. Listing exits when done. Presenting results returns arguments
+// for executing in history mode.
+func historyCommand(args []string) []string {
+	if len(args) > 2 {
+		switch args[2] {
+		case "list":
+			var (
+				flags    = flag.NewFlagSet(HISTORY_COMMAND+" list", flag.ExitOnError)
+				location storage.Location // Location of the database to list.
+			)
+			storageFlags(flags, &location.Name, &location.Path)
+			flags.Parse(args[3:])
+
+			series, err := lib.ListHistory(location)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to list history: %v\n", err)
+				os.Exit(1)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "QUERY\tRESULTS\tFIRST\tLAST\tLABELS")
+			for _, s := range series {
+				first, last := "-", "-"
+				if s.Count > 0 {
+					first, last = s.First.Local().Format(time.DateTime), s.Last.Local().Format(time.DateTime)
+				}
+				fmt.Fprintf(
+					w, "%s\t%d\t%s\t%s\t%s\n", s.Query, s.Count, first, last, strings.Join(s.Labels, ", "))
+			}
+			w.Flush()
+			os.Exit(0)
+		case "-h", "-help", "--help", "help":
+			historyUsage()
+			os.Exit(0)
+		}
+	}
+
+	return append([]string{args[0], "-mode", strconv.Itoa(int(cryptarch.MODE_HISTORY))}, args[2:]...)
+}
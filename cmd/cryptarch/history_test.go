@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.Local)
+
+	for value, expected := range map[string]time.Time{
+		"":                     {},
+		"-1h30m":               now.Add(-90 * time.Minute),
+		"2024-01-01":           time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+		"2024-01-01 10:30":     time.Date(2024, 1, 1, 10, 30, 0, 0, time.Local),
+		"2024-01-01 10:30:15":  time.Date(2024, 1, 1, 10, 30, 15, 0, time.Local),
+		"2024-01-01T10:30:00Z": time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC),
+	} {
+		got, err := parseHistoryTime(value, now)
+		if err != nil || !got.Equal(expected) {
+			t.Errorf("Got: %v Expected: %v (%s, %v)\n", got, expected, value, err)
+		}
+	}
+
+	// It rejects anything else.
+	for _, value := range []string{"yesterday", "-1x", "01/01/2024"} {
+		if _, err := parseHistoryTime(value, now); err == nil {
+			t.Errorf("Got: %v Expected: %v (%s)\n", err, "error", value)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+//
+// Sub-command for importing external data into storage.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spacez320/cryptarch/internal/lib"
+)
+
+const (
+	IMPORT_COMMAND = "import" // Sub-command for importing external data.
+)
+
+// Prints usage for the import sub-command.
+func importUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: %[1]s %[2]s <file> -query <name> [flags]
+
+Import external data as stored results for a query, as if the query had produced them. Imported
+results may be presented with history mode and are sent to Prometheus or Elasticsearch if
+configured, e.g. for backfilling. Use '-' to import from standard input. Formats are:
+
+  csv      CSV with a header, with a column of times and columns of values.
+  ndjson   A JSON object per line, with a key for times and keys for values. Exports are accepted.
+  log      Command output per line, each starting with a time.
+
+Flags are the same as for querying, along with:
+
+  -import-columns     Columns or keys to import as values, separated by commas. Defaults to all.
+  -import-format      Format of the file. Defaults to one from the file extension.
+  -import-time        Column or key holding times. Defaults to 'time'.
+  -import-time-layout Layout of times, as for Go's time.Parse, or 'unix' for seconds since the
+                      epoch. Defaults to RFC 3339.
+
+Labels default to the names of imported columns or keys, unless -labels is provided.
+`, os.Args[0], IMPORT_COMMAND)
+}
+
+// Handles the import sub-command, returning arguments for executing an import.
+func importCommand(args []string) []string {
+	if len(args) < 3 || strings.HasPrefix(args[2], "-") && args[2] != "-" {
+		importUsage()
+		if len(args) > 2 && (args[2] == "-h" || args[2] == "-help" || args[2] == "--help") {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	return append([]string{args[0], "-import", args[2]}, args[3:]...)
+}
+
+// Imports external data for a query. Exits when done.
+func importData(path string, config *lib.Config) {
+	var (
+		file = os.Stdin // File to import from.
+	)
+
+	if len(config.Queries) != 1 {
+		fmt.Fprintln(os.Stderr, "Importing requires exactly one -query to import as")
+		os.Exit(1)
+	}
+	importConfig := lib.ImportConfig{
+		Columns:    parseCommaDelimitedStrOrEmpty(importColumns),
+		Format:     importFormat,
+		TimeField:  importTime,
+		TimeLayout: importTimeLayout,
+	}
+	if importConfig.Format == "" {
+		if importConfig.Format = lib.ImportFormat(path); importConfig.Format == "" {
+			fmt.Fprintf(os.Stderr, "Unknown format for %s, expected -import-format to be one of: %s\n",
+				path, strings.Join(lib.ImportFormats, ", "))
+			os.Exit(1)
+		}
+	}
+
+	if path != "-" {
+		var err error
+		if file, err = os.Open(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to import: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+	}
+
+	imported, err := lib.Import(file, config.Queries[0], &importConfig, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to import after %d results: %v\n", imported, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d results for %s\n", imported, config.Queries[0])
+}
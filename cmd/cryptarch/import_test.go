@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImportCommand(t *testing.T) {
+	for _, c := range []struct {
+		args     []string
+		expected []string
+	}{
+		{
+			args:     []string{"cryptarch", "import", "data.csv", "-query", "load"},
+			expected: []string{"cryptarch", "-import", "data.csv", "-query", "load"},
+		},
+		{
+			args:     []string{"cryptarch", "import", "-", "-query", "load", "-import-format", "log"},
+			expected: []string{"cryptarch", "-import", "-", "-query", "load", "-import-format", "log"},
+		},
+	} {
+		if got := importCommand(c.args); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Got: %v Expected: %v\n", got, c.expected)
+		}
+	}
+}
@@ -10,11 +10,15 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spacez320/cryptarch"
 	"github.com/spacez320/cryptarch/internal/lib"
+	"github.com/spacez320/cryptarch/pkg/storage"
 )
 
 // Used to supply build information.
@@ -53,33 +57,61 @@ const (
 )
 
 var (
-	count                 int      // Number of attempts to execute the query.
-	delay                 int      // Delay between queries.
-	displayMode           int      // Result mode to display.
-	elasticsearchAddr     string   // Address for Elasticsearch.
-	elasticsearchIndex    string   // Index to use for Elasticsearch documents.
-	elasticsearchPassword string   // Password for Elasticsearch basic auth.
-	elasticsearchUser     string   // User for Elasticsearch basic auth.
-	expressions           multiArg // Expression to apply to output.
-	filters               string   // Result filters.
-	history               bool     // Whether or not to preserve or use historical results.
-	labels                string   // Result value labels.
-	logFile               string   // Log filte to write to.
-	logLevel              string   // Log level.
-	mode                  int      // Mode to execute in.
-	outerPaddingBottom    int      // Bottom padding settings.
-	outerPaddingLeft      int      // Left padding settings.
-	outerPaddingRight     int      // Right padding settings.
-	outerPaddingTop       int      // Top padding settings.
-	port                  string   // Port for RPC.
-	promExporterAddr      string   // Address for Prometheus metrics page.
-	promPushgatewayAddr   string   // Address for Prometheus Pushgateway.
-	queries               multiArg // Queries to execute.
-	showHelp              bool     // Whether or not to show helpt
-	showLogs              bool     // Whether or not to show logs.
-	showStatus            bool     // Whether or not to show statuses.
-	showVersion           bool     // Whether or not to display a version.
-	silent                bool     // Whether or not to be quiet.
+	configFile            string        // Configuration file to load.
+	count                 int           // Number of attempts to execute the query.
+	daemon                bool          // Whether or not to run as a daemon.
+	daemonName            string        // Name of the daemon.
+	delay                 int           // Delay between queries.
+	displayMode           int           // Result mode to display.
+	elasticsearchAddr     string        // Address for Elasticsearch.
+	elasticsearchIndex    string        // Index to use for Elasticsearch documents.
+	elasticsearchPassword string        // Password for Elasticsearch basic auth.
+	elasticsearchUser     string        // User for Elasticsearch basic auth.
+	expressions           multiArg      // Expression to apply to output.
+	filters               string        // Result filters.
+	from                  string        // Start of results to present, in history mode.
+	history               bool          // Whether or not to preserve or use historical results.
+	importColumns         string        // Columns or keys to import as values.
+	importFile            string        // File to import external data from.
+	importFormat          string        // Format of imported data.
+	importTime            string        // Column or key holding times of imported data.
+	importTimeLayout      string        // Layout of times of imported data.
+	labels                string        // Result value labels.
+	logFile               string        // Log filte to write to.
+	logLevel              string        // Log level.
+	mode                  int           // Mode to execute in.
+	outerPaddingBottom    int           // Bottom padding settings.
+	outerPaddingLeft      int           // Left padding settings.
+	outerPaddingRight     int           // Right padding settings.
+	outerPaddingTop       int           // Top padding settings.
+	parser                string        // Parser of query output.
+	port                  string        // Port for RPC.
+	promExporterAddr      string        // Address for Prometheus metrics page.
+	promPushgatewayAddr   string        // Address for Prometheus Pushgateway.
+	queries               multiArg      // Queries to execute.
+	queryDefinitions      queryDefs     // Queries to execute, with their own settings.
+	queryTimeouts         multiArg      // Query specific timeouts.
+	recordSeparator       string        // Separator for records in streaming queries.
+	retentionAge          time.Duration // Maximum age of stored results.
+	retentionResultBytes  int64         // Maximum size of stored results per query.
+	retentionCount        int           // Maximum number of stored results per query.
+	retentionRollupAge    time.Duration // Maximum age of stored rollups.
+	rollupAggregate       string        // Aggregate of rollups to present, in history mode.
+	rows                  string        // Rows of tables to present.
+	rpcHost               string        // Host for RPC, when reading.
+	rpcSocket             string        // Socket for RPC, when reading.
+	showHelp              bool          // Whether or not to show helpt
+	showLogs              bool          // Whether or not to show logs.
+	showMetadata          bool          // Whether or not to show result metadata.
+	showStatus            bool          // Whether or not to show statuses.
+	showVersion           bool          // Whether or not to display a version.
+	silent                bool          // Whether or not to be quiet.
+	storageBackend        string        // Backend of new storage databases.
+	storageName           string        // Name of the storage database.
+	storagePath           string        // Directory of persisted storage.
+	timeout               int           // Timeout for query execution.
+	to                    string        // End of results to present, in history mode.
+	units                 bool          // Whether or not to parse values with units.
 
 	// Supplied by the linker at build time.
 	version string
@@ -105,14 +137,61 @@ func parseCommaDelimitedStrOrEmpty(s string) []string {
 	}
 }
 
+// Parses query timeouts of the form '<query>=<seconds>' into a mapping of queries to timeouts. The
+// last '=' is used as the delimiter, so queries may themselves contain '='.
+func parseQueryTimeouts(queryTimeouts []string) (map[string]int, error) {
+	parsed := make(map[string]int, len(queryTimeouts))
+
+	for _, queryTimeout := range queryTimeouts {
+		i := strings.LastIndex(queryTimeout, "=")
+		if i < 0 {
+			return parsed, fmt.Errorf("Bad query timeout, expected '<query>=<seconds>': %s", queryTimeout)
+		}
+		seconds, err := strconv.Atoi(queryTimeout[i+1:])
+		if err != nil {
+			return parsed, fmt.Errorf("Bad query timeout, expected '<query>=<seconds>': %s", queryTimeout)
+		}
+		parsed[queryTimeout[:i]] = seconds
+	}
+
+	return parsed, nil
+}
+
 func main() {
+	// Handle sub-commands.
+	if len(os.Args) > 1 && os.Args[1] == DAEMON_COMMAND {
+		os.Args = daemonCommand(os.Args)
+	}
+	if len(os.Args) > 1 && os.Args[1] == DATABASES_COMMAND {
+		databasesCommand(os.Args)
+	}
+	if len(os.Args) > 1 && os.Args[1] == EXPORT_COMMAND {
+		exportCommand(os.Args)
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == HISTORY_COMMAND {
+		os.Args = historyCommand(os.Args)
+	}
+	if len(os.Args) > 1 && os.Args[1] == IMPORT_COMMAND {
+		os.Args = importCommand(os.Args)
+	}
+	if len(os.Args) > 1 && os.Args[1] == PRUNE_COMMAND {
+		pruneCommand(os.Args)
+	}
+
 	// Define arguments.
+	flag.BoolVar(&daemon, "daemon", false, fmt.Sprintf("Run in the background as a daemon. "+
+		"See '%s %s' for managing daemons.", os.Args[0], DAEMON_COMMAND))
 	flag.BoolVar(&history, "history", true, "Whether or not to use or preserve history.")
 	flag.BoolVar(&showHelp, "show-help", true, "Whether or not to show help displays.")
 	flag.BoolVar(&showLogs, "show-logs", false, "Whether or not to show log displays.")
+	flag.BoolVar(&showMetadata, "show-metadata", true,
+		"Whether or not to show result metadata (exit codes, durations, etc.).")
 	flag.BoolVar(&showStatus, "show-status", true, "Whether or not to show status displays.")
 	flag.BoolVar(&showVersion, "version", false, "Show version.")
 	flag.BoolVar(&silent, "silent", false, "Don't output anything to a console.")
+	flag.BoolVar(&units, "units", false, "Whether or not to parse values with human units, such as "+
+		"'512Mi', '1.5ms', '3d4h', or '87%', into numbers of bytes, seconds, or ratios.")
 	flag.IntVar(&count, "count", 1, "Number of query executions. -1 for continuous.")
 	flag.IntVar(&delay, "delay", 3, "Delay between queries (seconds).")
 	flag.IntVar(&displayMode, "display", int(lib.DISPLAY_MODE_RAW), "Result mode to display.")
@@ -121,6 +200,25 @@ func main() {
 	flag.IntVar(&outerPaddingLeft, "outer-padding-left", -1, "Left display padding.")
 	flag.IntVar(&outerPaddingRight, "outer-padding-right", -1, "Right display padding.")
 	flag.IntVar(&outerPaddingTop, "outer-padding-top", -1, "Top display padding.")
+	flag.Int64Var(&retentionResultBytes, "retention-result-bytes", 0,
+		"Maximum size of stored results for each query (bytes, approximated from their JSON), "+
+			"removing the oldest. 0 for none.")
+	flag.IntVar(&retentionCount, "retention-count", 0,
+		"Maximum number of stored results for each query, removing the oldest. 0 for none.")
+	flag.IntVar(&timeout, "timeout", 0, "Timeout for each query execution (seconds). 0 for none.")
+	flag.DurationVar(&retentionAge, "retention-age", 0,
+		"Maximum age of stored results, e.g. '24h', removing older ones. 0 for none.")
+	flag.DurationVar(&retentionRollupAge, "retention-rollup-age", 0,
+		"Maximum age of stored rollups (summaries of results over each minute and hour), e.g. "+
+			"'2160h', removing older ones. Minute rollups are also never kept for more than a week. 0 "+
+			"for none.")
+	flag.StringVar(&configFile, "config", "", fmt.Sprintf("Configuration file to load. Defaults to "+
+		"'%s' in the user configuration directory. Settings are flag names, e.g. 'log-level: debug', "+
+		"and may also be set by environment variables, e.g. 'CRYPTARCH_LOG_LEVEL=debug'. Flags take "+
+		"precedence over environment variables, which take precedence over the configuration file.",
+		filepath.Join(CONFIG_FILE_DIR, CONFIG_FILE_NAME)))
+	flag.StringVar(&daemonName, "daemon-name", "",
+		"Name of the daemon, when running as a daemon. Defaults to the process id.")
 	flag.StringVar(&elasticsearchAddr, "elasticsearch-addr", "",
 		"Address to present Elasticsearch document updates.")
 	flag.StringVar(&elasticsearchIndex, "elasticsearch-index", "",
@@ -131,18 +229,63 @@ func main() {
 	flag.StringVar(&elasticsearchUser, "elasticsearch-user", "",
 		"User to use for Elasticsearch basic auth.")
 	flag.StringVar(&filters, "filters", "", "Results filters.")
+	flag.StringVar(&from, "from", "", "Start of stored results to present in history mode, either "+
+		"absolute (e.g. '2006-01-02 15:04:05') or relative to now (e.g. '-1h'). Defaults to the first "+
+		"stored result.")
+	flag.StringVar(&importColumns, "import-columns", "",
+		"Columns or keys of imported data to import as values, separated by commas. Defaults to all.")
+	flag.StringVar(&importFile, "import", "", fmt.Sprintf("File to import as results for the query, "+
+		"instead of executing it. '-' for standard input. See '%s %s -h' for details.", os.Args[0],
+		IMPORT_COMMAND))
+	flag.StringVar(&importFormat, "import-format", "", fmt.Sprintf("Format of imported data, one "+
+		"of: %s. Defaults to one from the file extension.", strings.Join(lib.ImportFormats, ", ")))
+	flag.StringVar(&importTime, "import-time", lib.IMPORT_TIME_FIELD,
+		"Column or key holding times of imported data.")
+	flag.StringVar(&importTimeLayout, "import-time-layout", time.RFC3339Nano, fmt.Sprintf("Layout "+
+		"of times of imported data, as for Go's time.Parse, or '%s' for seconds since the epoch.",
+		lib.IMPORT_TIME_UNIX))
 	flag.StringVar(&labels, "labels", "", "Labels to apply to query values, separated by commas.")
 	flag.StringVar(&logFile, "log-file", "", "Log file to write to.")
 	flag.StringVar(&logLevel, "log-level", "error", "Log level.")
+	flag.StringVar(&rollupAggregate, "rollup-aggregate", storage.ROLLUP_AVG, fmt.Sprintf(
+		"How to summarize numeric values when presenting rollups in history mode, one of: %s.",
+		strings.Join(storage.RollupAggregates, ", ")))
+	flag.StringVar(&rows, "rows", "", "Keys of rows to present for queries producing tables, "+
+		"separated by commas. Defaults to all rows, or the first row in graph displays.")
+	flag.StringVar(&rpcHost, "rpc-host", "localhost",
+		"Host of another Cryptarch to read from when in read mode.")
+	flag.StringVar(&to, "to", "", "End of stored results to present in history mode, like -from. "+
+		"Defaults to now.")
+	flag.StringVar(&parser, "parser", lib.Parsers[0], fmt.Sprintf("Parser of query output into "+
+		"values, given as '<parser>[:<argument>]' where parsers are one of: %s. Parsers that find "+
+		"names in output, such as JSON keys, label values with them unless -labels is provided.",
+		strings.Join(lib.Parsers, ", ")))
 	flag.StringVar(&port, "rpc-port", "12345", "Port for RPC.")
+	flag.StringVar(&rpcSocket, "rpc-socket", "",
+		"Socket of another Cryptarch to read from when in read mode, such as a daemon's. Overrides "+
+			"-rpc-host and -rpc-port.")
 	flag.StringVar(&promExporterAddr, "prometheus-exporter", "",
 		"Address to present Prometheus metrics.")
 	flag.StringVar(&promPushgatewayAddr, "prometheus-pushgateway", "",
 		"Address for Prometheus Pushgateway.")
+	flag.StringVar(&recordSeparator, "record-separator", "\\n",
+		"Separator between records produced by queries in stream mode. Accepts Go escape sequences.")
+	storageFlags(flag.CommandLine, &storageName, &storagePath)
+	flag.StringVar(&storageBackend, "storage-backend", "", fmt.Sprintf("Backend to persist storage "+
+		"with, one of: %s. Databases keep using the backend they were created with. Defaults to '%s'.",
+		strings.Join(storage.Backends, ", "), storage.Backends[0]))
 	flag.Var(&expressions, "expr", "Expression to apply to output. Can be supplied multiple times.")
 	flag.Var(&queries, "query", "Query to execute. Can be supplied multiple times. When in query "+
-		"mode, this is expected to be some command. When in profile mode it is expected to be PID. "+
-		"At least one query must be provided.")
+		"mode, this is expected to be some command. When in profile mode it is expected to be PID, "+
+		"or 'system' to profile the system. At least one query must be provided, except in read mode "+
+		"where all queries are read if none are provided.")
+	flag.Var(&queryDefinitions, "query-def", "Query to execute with its own settings, given as "+
+		"'<setting>=<value>;...;query=<query>' where settings may be any of count, delay, expr, "+
+		"filters, labels, mode (command, profile, or stream), name, parser, retention-age, "+
+		"retention-result-bytes, retention-count, retention-rollup-age, rows, timeout, and units. "+
+		"The query must be last. Can be supplied multiple times.")
+	flag.Var(&queryTimeouts, "query-timeout", "Timeout for a specific query, given as "+
+		"'<query>=<seconds>', overriding -timeout. Can be supplied multiple times.")
 	flag.Parse()
 
 	// Display a version.
@@ -156,13 +299,75 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Load settings from the environment and configuration file.
+	if err := loadConfig(flag.CommandLine, configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Bad configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateConfig(); err != nil {
+		flag.Usage()
+		fmt.Fprintf(os.Stderr, "Bad configuration: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Check for required flags.
-	if len(queries) == 0 {
+	if len(queries) == 0 && len(queryDefinitions) == 0 &&
+		mode != int(cryptarch.MODE_READ) && mode != int(cryptarch.MODE_HISTORY) {
 		flag.Usage()
-		fmt.Fprintf(os.Stderr, "Missing required argument -query\n")
+		fmt.Fprintf(os.Stderr, "Missing required argument -query or -query-def\n")
+		os.Exit(1)
+	}
+
+	// Parse query specific timeouts.
+	parsedQueryTimeouts, err := parseQueryTimeouts(queryTimeouts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Parse times for history mode.
+	now := time.Now()
+	parsedFrom, err := parseHistoryTime(from, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	parsedTo, err := parseHistoryTime(to, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Interpret escape sequences in the record separator.
+	parsedRecordSeparator, err := strconv.Unquote(`"` + recordSeparator + `"`)
+	if err != nil || parsedRecordSeparator == "" {
+		fmt.Fprintf(os.Stderr, "Bad record separator: %s\n", recordSeparator)
 		os.Exit(1)
 	}
 
+	// Start a daemon. The daemon is this same execution, started over in the background.
+	if daemon {
+		if !lib.IsDaemon() {
+			name, err := lib.Detach(daemonName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to start daemon: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Started daemon %s\n", name)
+			os.Exit(0)
+		}
+
+		// Daemons have no console, so always log to a file.
+		daemonName = lib.DaemonName(daemonName)
+		if logFile == "" {
+			if logFile, err = lib.DaemonLogPath(daemonName); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to find daemon log file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		silent = false
+	}
+
 	// Set-up logging.
 	if silent {
 		// Silence all output.
@@ -188,8 +393,21 @@ func main() {
 	}
 
 	// Build general configuration.
+	retention := storage.Retention{
+		MaxAge:         retentionAge,
+		MaxResultBytes: retentionResultBytes,
+		MaxCount:       retentionCount,
+		RollupMaxAge:   retentionRollupAge,
+	}
+	storageLocation := storage.Location{
+		Backend: storageBackend,
+		Name:    storageName,
+		Path:    storagePath,
+	}
 	config := lib.Config{
 		Count:                  count,
+		Daemon:                 daemon,
+		DaemonName:             daemonName,
 		Delay:                  delay,
 		DisplayMode:            displayMode,
 		ElasticsearchAddr:      elasticsearchAddr,
@@ -198,21 +416,51 @@ func main() {
 		ElasticsearchUser:      elasticsearchUser,
 		Expressions:            expressions,
 		Filters:                parseCommaDelimitedStrOrEmpty(filters),
+		From:                   parsedFrom,
 		History:                history,
 		Labels:                 parseCommaDelimitedStrOrEmpty(labels),
 		LogLevel:               logLevel,
 		LogMulti:               logFile != "",
+		Silent:                 silent,
+		Units:                  units,
 		Mode:                   mode,
+		Parser:                 parser,
 		Port:                   port,
 		PrometheusExporterAddr: promExporterAddr,
 		PushgatewayAddr:        promPushgatewayAddr,
 		Queries:                queries,
+		QueryTimeouts:          parsedQueryTimeouts,
+		RecordSeparator:        parsedRecordSeparator,
+		RollupAggregate:        rollupAggregate,
+		Rows:                   parseCommaDelimitedStrOrEmpty(rows),
+		Retention:              retention,
+		RPCHost:                rpcHost,
+		RPCSocket:              rpcSocket,
+		StorageLocation:        storageLocation,
+		Timeout:                timeout,
+		To:                     parsedTo,
+	}
+
+	// Build settings for queries with their own settings.
+	defQueries, queryConfigs, err := queryDefinitions.QueryConfigs(&config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	config.Queries = append(config.Queries, defQueries...)
+	config.QueryConfigs = queryConfigs
+
+	// Import data instead of executing queries.
+	if importFile != "" {
+		importData(importFile, &config)
+		os.Exit(0)
 	}
 
 	// Build display configuration.
 	displayConfig := lib.NewDisplayConfig()
 	displayConfig.ShowHelp = showHelp
 	displayConfig.ShowLogs = showLogs
+	displayConfig.ShowMetadata = showMetadata
 	displayConfig.ShowStatus = showStatus
 	if outerPaddingBottom >= 0 {
 		displayConfig.OuterPaddingBottom = outerPaddingBottom
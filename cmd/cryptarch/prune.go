@@ -0,0 +1,68 @@
+//
+// Sub-command for pruning stored results.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/spacez320/cryptarch/internal/lib"
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
+
+const (
+	PRUNE_COMMAND = "prune" // Sub-command for pruning stored results.
+)
+
+// Prints usage for the prune sub-command.
+func pruneUsage(flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, `Usage: %[1]s %[2]s [flags] <query>
+
+Remove stored results for a query, oldest first, keeping the most recent result. At least one limit
+must be provided. Cryptarch should not be running against the same storage.
+
+Flags:
+`, os.Args[0], PRUNE_COMMAND)
+		flags.PrintDefaults()
+	}
+}
+
+// Handles the prune sub-command, exiting when done.
+func pruneCommand(args []string) {
+	var (
+		flags     = flag.NewFlagSet(PRUNE_COMMAND, flag.ExitOnError)
+		location  storage.Location  // Location of the database to prune.
+		retention storage.Retention // Retention to prune with.
+	)
+
+	flags.DurationVar(&retention.MaxAge, "max-age", 0, "Remove results older than this, e.g. '24h'.")
+	flags.Int64Var(&retention.MaxResultBytes, "max-result-bytes", 0,
+		"Remove results until they take up at most this many bytes, approximated from their JSON.")
+	flags.IntVar(&retention.MaxCount, "max-count", 0, "Remove results until at most this many remain.")
+	flags.DurationVar(&retention.RollupMaxAge, "max-rollup-age", 0,
+		"Remove rollups of results older than this, e.g. '2160h'.")
+	storageFlags(flags, &location.Name, &location.Path)
+	flags.Usage = pruneUsage(flags)
+	flags.Parse(args[2:])
+
+	if flags.NArg() != 1 || retention.IsZero() {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if retention.MaxAge < 0 || retention.MaxResultBytes < 0 || retention.MaxCount < 0 ||
+		retention.RollupMaxAge < 0 {
+		fmt.Fprintln(os.Stderr, "Bad limit, expected zero or more")
+		os.Exit(1)
+	}
+
+	pruned, err := lib.Prune(location, flags.Arg(0), retention)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to prune results: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Removed %d results for %s\n", pruned, flags.Arg(0))
+	os.Exit(0)
+}
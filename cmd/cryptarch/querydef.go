@@ -0,0 +1,223 @@
+//
+// Query definitions, for queries with their own settings.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spacez320/cryptarch"
+	"github.com/spacez320/cryptarch/internal/lib"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	QUERY_DEF_QUERY = "query" // Setting for the query itself, which must be last in flags.
+	QUERY_DEF_SEP   = ";"     // Separator between settings in flags.
+)
+
+var (
+	// Settings allowed in query definitions.
+	queryDefKeys = []string{
+		"count", "delay", "expr", "filters", "labels", "mode", "name", "parser", QUERY_DEF_QUERY,
+		"retention-age", "retention-result-bytes", "retention-count", "retention-rollup-age", "rows",
+		"timeout", "units",
+	}
+	// Query modes allowed in query definitions.
+	queryDefModes = map[string]int{
+		"command": lib.QUERY_MODE_COMMAND,
+		"profile": lib.QUERY_MODE_PROFILE,
+		"stream":  lib.QUERY_MODE_STREAM,
+	}
+)
+
+// Queries with their own settings, provided as flags or configuration. Each definition maps
+// settings to their values.
+type queryDefs []map[string][]string
+
+func (q *queryDefs) String() string {
+	// XXX This is necessary to resolve the interface contract, but doesn't seem important.
+	return ""
+}
+
+// Parses a query definition of the form '<setting>=<value>;...;query=<query>'. Because queries may
+// contain anything, the query is always last and everything after 'query=' is the query.
+func (q *queryDefs) Set(def string) error {
+	queryDef := make(map[string][]string)
+
+	for rest := def; rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return fmt.Errorf("Bad query definition, expected '<setting>=<value>': %s", rest)
+		}
+		key = strings.TrimSpace(key)
+
+		if key == QUERY_DEF_QUERY {
+			rest = ""
+		} else {
+			value, rest, _ = strings.Cut(value, QUERY_DEF_SEP)
+		}
+		if err := checkQueryDefKey(key); err != nil {
+			return err
+		}
+		queryDef[key] = append(queryDef[key], value)
+	}
+
+	*q = append(*q, queryDef)
+	return nil
+}
+
+// Checks that a setting is allowed in query definitions.
+func checkQueryDefKey(key string) error {
+	if !slices.Contains(queryDefKeys, key) {
+		return fmt.Errorf("Unknown query definition setting %q, expected one of: %s",
+			key, strings.Join(queryDefKeys, ", "))
+	}
+
+	return nil
+}
+
+// Retrieves the last value of a setting in a query definition.
+func queryDefValue(queryDef map[string][]string, key string) (string, bool) {
+	if values := queryDef[key]; len(values) > 0 {
+		return values[len(values)-1], true
+	}
+
+	return "", false
+}
+
+// Retrieves a setting in a query definition as a list. Values may be comma delimited.
+func queryDefList(queryDef map[string][]string, key string) (list []string, ok bool) {
+	values, ok := queryDef[key]
+	for _, value := range values {
+		list = append(list, parseCommaDelimitedStrOrEmpty(value)...)
+	}
+
+	return
+}
+
+// Builds settings for queries from query definitions. Settings not in a definition are taken from
+// general settings, and queries without a mode use the mode Cryptarch is executing in.
+func (q *queryDefs) QueryConfigs(
+	generalConfig *lib.Config,
+) (queries []string, queryConfigs map[string]lib.QueryConfig, err error) {
+	queryConfigs = make(map[string]lib.QueryConfig, len(*q))
+
+	for _, queryDef := range *q {
+		query, ok := queryDefValue(queryDef, QUERY_DEF_QUERY)
+		if !ok || query == "" {
+			return nil, nil, fmt.Errorf("Bad query definition, missing a query: %v", queryDef)
+		}
+		if _, ok := queryConfigs[query]; ok || slices.Contains((*generalConfig).Queries, query) {
+			return nil, nil, fmt.Errorf("Bad query definition, query is defined twice: %s", query)
+		}
+
+		// Start with general settings.
+		queryConfig := lib.QueryConfig{
+			Count:       (*generalConfig).Count,
+			Delay:       (*generalConfig).Delay,
+			Expressions: (*generalConfig).Expressions,
+			Filters:     (*generalConfig).Filters,
+			Parser:      (*generalConfig).Parser,
+			Query:       query,
+			Retention:   (*generalConfig).Retention,
+			Rows:        (*generalConfig).Rows,
+			Timeout:     (*generalConfig).Timeout,
+			Units:       (*generalConfig).Units,
+		}
+		if timeout, ok := (*generalConfig).QueryTimeouts[query]; ok {
+			queryConfig.Timeout = timeout
+		}
+		switch (*generalConfig).Mode {
+		case int(cryptarch.MODE_PROFILE):
+			queryConfig.Mode = lib.QUERY_MODE_PROFILE
+		case int(cryptarch.MODE_STREAM):
+			queryConfig.Mode = lib.QUERY_MODE_STREAM
+		default:
+			queryConfig.Mode = lib.QUERY_MODE_COMMAND
+		}
+
+		// Apply settings from the definition.
+		for key, ints := range map[string]*int{
+			"count":           &queryConfig.Count,
+			"delay":           &queryConfig.Delay,
+			"retention-count": &queryConfig.Retention.MaxCount,
+			"timeout":         &queryConfig.Timeout,
+		} {
+			if value, ok := queryDefValue(queryDef, key); ok {
+				if *ints, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+					return nil, nil, fmt.Errorf("Bad query definition %q, bad %s: %s", query, key, value)
+				}
+			}
+		}
+		for key, durations := range map[string]*time.Duration{
+			"retention-age":        &queryConfig.Retention.MaxAge,
+			"retention-rollup-age": &queryConfig.Retention.RollupMaxAge,
+		} {
+			if value, ok := queryDefValue(queryDef, key); ok {
+				if *durations, err = time.ParseDuration(strings.TrimSpace(value)); err != nil {
+					return nil, nil, fmt.Errorf("Bad query definition %q, bad %s: %s", query, key, value)
+				}
+			}
+		}
+		if value, ok := queryDefValue(queryDef, "retention-result-bytes"); ok {
+			queryConfig.Retention.MaxResultBytes, err = strconv.ParseInt(
+				strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf(
+					"Bad query definition %q, bad retention-result-bytes: %s", query, value)
+			}
+		}
+		if value, ok := queryDefValue(queryDef, "mode"); ok {
+			if queryConfig.Mode, ok = queryDefModes[strings.TrimSpace(value)]; !ok {
+				return nil, nil, fmt.Errorf(
+					"Bad query definition %q, bad mode %q, expected command, profile, or stream",
+					query, value)
+			}
+		}
+		if values, ok := queryDef["expr"]; ok {
+			queryConfig.Expressions = values
+		}
+		if filters, ok := queryDefList(queryDef, "filters"); ok {
+			queryConfig.Filters = filters
+		}
+		if rows, ok := queryDefList(queryDef, "rows"); ok {
+			queryConfig.Rows = rows
+		}
+		if labels, ok := queryDefList(queryDef, "labels"); ok {
+			queryConfig.Labels = labels
+		} else if queryConfig.Mode == lib.QUERY_MODE_PROFILE {
+			// Profiles of processes have specific labels, and system profiles label their own results.
+			if query != lib.PROFILE_SYSTEM {
+				queryConfig.Labels = lib.ProfileLabels
+			}
+		} else {
+			queryConfig.Labels = (*generalConfig).Labels
+		}
+		if value, ok := queryDefValue(queryDef, "parser"); ok {
+			if _, err = lib.NewParser(value); err != nil {
+				return nil, nil, fmt.Errorf("Bad query definition %q, bad parser: %v", query, err)
+			}
+			queryConfig.Parser = value
+		}
+		if value, ok := queryDefValue(queryDef, "units"); ok {
+			if queryConfig.Units, err = strconv.ParseBool(strings.TrimSpace(value)); err != nil {
+				return nil, nil, fmt.Errorf("Bad query definition %q, bad units: %s", query, value)
+			}
+		}
+		queryConfig.Name, _ = queryDefValue(queryDef, "name")
+		err = validateQuerySettings(
+			queryConfig.Count, queryConfig.Delay, queryConfig.Timeout, queryConfig.Retention)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Bad query definition %q: %v", query, err)
+		}
+
+		queries = append(queries, query)
+		queryConfigs[query] = queryConfig
+	}
+
+	return
+}
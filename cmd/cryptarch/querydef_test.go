@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spacez320/cryptarch"
+	"github.com/spacez320/cryptarch/internal/lib"
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
+
+func TestQueryDefsSet(t *testing.T) {
+	defs := queryDefs{}
+
+	// It parses settings, with everything after the query setting being the query.
+	err := defs.Set("name=disk;labels=used,free;expr=a;expr=b;query=df -h | awk '{print $3;$4}'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := queryDefs{{
+		"expr":   {"a", "b"},
+		"labels": {"used,free"},
+		"name":   {"disk"},
+		"query":  {"df -h | awk '{print $3;$4}'"},
+	}}
+	if !reflect.DeepEqual(defs, expected) {
+		t.Errorf("Got: %v Expected: %v\n", defs, expected)
+	}
+
+	// It rejects unknown settings and malformed definitions.
+	for _, def := range []string{"foo=bar;query=uptime", "name;query=uptime"} {
+		if err := defs.Set(def); err == nil {
+			t.Errorf("Got: %v Expected: %v (%s)\n", err, "error", def)
+		}
+	}
+}
+
+func TestQueryDefsQueryConfigs(t *testing.T) {
+	generalConfig := lib.Config{
+		Count:         1,
+		Delay:         3,
+		Filters:       []string{"foo"},
+		Labels:        []string{"foo", "bar"},
+		Mode:          int(cryptarch.MODE_QUERY),
+		Queries:       []string{"whoami"},
+		QueryTimeouts: map[string]int{"uptime": 5},
+		Retention:     storage.Retention{MaxAge: time.Hour},
+	}
+	defs := queryDefs{}
+	defs.Set("delay=10;labels=load;parser=json:.load;retention-count=5;" +
+		"retention-result-bytes=1024;retention-rollup-age=720h;units=true;query=uptime")
+	defs.Set("name=self;mode=profile;count=-1;query=1")
+	defs.Set("mode=profile;query=system")
+
+	// It combines definitions with general settings.
+	queries, queryConfigs, err := defs.QueryConfigs(&generalConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"uptime", "1", "system"}; !reflect.DeepEqual(queries, expected) {
+		t.Errorf("Got: %v Expected: %v\n", queries, expected)
+	}
+	expected := map[string]lib.QueryConfig{
+		"uptime": {
+			Count:   1,
+			Delay:   10,
+			Filters: []string{"foo"},
+			Labels:  []string{"load"},
+			Mode:    lib.QUERY_MODE_COMMAND,
+			Parser:  "json:.load",
+			Query:   "uptime",
+			Retention: storage.Retention{
+				MaxAge: time.Hour, MaxResultBytes: 1024, MaxCount: 5, RollupMaxAge: 720 * time.Hour,
+			},
+			Timeout: 5,
+			Units:   true,
+		},
+		"1": {
+			Count:     -1,
+			Delay:     3,
+			Filters:   []string{"foo"},
+			Labels:    lib.ProfileLabels,
+			Mode:      lib.QUERY_MODE_PROFILE,
+			Name:      "self",
+			Query:     "1",
+			Retention: storage.Retention{MaxAge: time.Hour},
+		},
+		"system": {
+			Count:     1,
+			Delay:     3,
+			Filters:   []string{"foo"},
+			Mode:      lib.QUERY_MODE_PROFILE,
+			Query:     "system",
+			Retention: storage.Retention{MaxAge: time.Hour},
+		},
+	}
+	if !reflect.DeepEqual(queryConfigs, expected) {
+		t.Errorf("Got: %v Expected: %v\n", queryConfigs, expected)
+	}
+
+	// It rejects bad definitions.
+	for _, def := range []string{
+		"query=whoami", "name=foo", "delay=foo;query=uptime", "mode=foo;query=uptime",
+		"parser=foo;query=uptime", "retention-age=foo;query=uptime", "retention-rollup-age=foo;query=uptime",
+		"units=foo;query=uptime", "count=0;query=uptime", "delay=-1;query=uptime",
+		"timeout=-1;query=uptime", "retention-age=-1h;query=uptime",
+		"retention-result-bytes=-1;query=uptime", "retention-count=-1;query=uptime",
+		"retention-rollup-age=-1h;query=uptime",
+	} {
+		defs := queryDefs{}
+		defs.Set(def)
+		if _, _, err := defs.QueryConfigs(&generalConfig); err == nil {
+			t.Errorf("Got: %v Expected: %v (%s)\n", err, "error", def)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+//
+// Storage locations and the sub-command for listing storage databases.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
+
+const (
+	DATABASES_COMMAND = "databases" // Sub-command for listing storage databases.
+)
+
+// Defines the flag for the storage directory. Defaults may be provided by environment variables,
+// including for sub-commands.
+func storagePathFlag(flags *flag.FlagSet, path *string) {
+	flags.StringVar(path, "storage-path", os.Getenv(envName("storage-path")),
+		"Directory of persisted storage. Defaults to 'cryptarch' in the user cache directory.")
+}
+
+// Defines flags for the location of a storage database. Defaults may be provided by environment
+// variables, including for sub-commands.
+func storageFlags(flags *flag.FlagSet, name, path *string) {
+	storagePathFlag(flags, path)
+	flags.StringVar(name, "storage-name", os.Getenv(envName("storage-name")),
+		fmt.Sprintf("Name of the storage database to use, keeping its results apart from others. "+
+			"Defaults to the '%s' database. See '%s %s' for existing databases.",
+			storage.STORAGE_DB_DEFAULT, os.Args[0], DATABASES_COMMAND))
+}
+
+// Prints usage for the databases sub-command.
+func databasesUsage(flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, `Usage: %[1]s %[2]s [flags]
+
+List storage databases, with their backend, size, number of results series, and the span of time
+of their results.
+
+Flags:
+`, os.Args[0], DATABASES_COMMAND)
+		flags.PrintDefaults()
+	}
+}
+
+// Handles the databases sub-command, exiting when done.
+func databasesCommand(args []string) {
+	var (
+		flags = flag.NewFlagSet(DATABASES_COMMAND, flag.ExitOnError)
+		path  string // Directory of storage.
+	)
+
+	storagePathFlag(flags, &path)
+	flags.Usage = databasesUsage(flags)
+	flags.Parse(args[2:])
+
+	databases, err := storage.ListDatabases(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list databases: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tBACKEND\tBYTES\tSERIES\tRESULTS\tFIRST\tLAST\tPATH")
+	for _, database := range databases {
+		first, last := "-", "-"
+		if database.Results > 0 {
+			first = database.First.Local().Format(time.DateTime)
+			last = database.Last.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n", database.Name, database.Backend,
+			database.Bytes, database.Series, database.Results, first, last, database.Path)
+	}
+	w.Flush()
+	os.Exit(0)
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spacez320/cryptarch/internal/lib"
 )
@@ -20,6 +22,8 @@ const (
 	MODE_QUERY   queryMode = iota + 1 // For running in 'query' mode.
 	MODE_PROFILE                      // For running in 'profile' mode.
 	MODE_READ                         // For running in 'read' mode.
+	MODE_STREAM                       // For running in 'stream' mode.
+	MODE_HISTORY                      // For running in 'history' mode.
 )
 
 var (
@@ -37,33 +41,84 @@ func Run(config lib.Config, displayConfig lib.DisplayConfig) {
 	slog.Debug("Running with config", "config", config)
 	slog.Debug("Running with display config", "displayConfig", displayConfig)
 
+	// Stop any running queries when interrupted. Queries run in their own process groups, so they
+	// won't otherwise receive signals sent to Cryptarch.
+	go func() {
+		signalCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		<-signalCtx.Done()
+		slog.Debug("Received interrupt, stopping queries")
+		lib.Shutdown()
+		os.Exit(1)
+	}()
+
 	// Execute the specified mode.
 	switch {
 	case config.Mode == int(MODE_PROFILE):
 		slog.Debug("Executing in profile mode")
 
 		doneQueriesChan, pauseQueryChans = lib.Query(
+			ctx,
 			lib.QUERY_MODE_PROFILE,
 			config.Count,
 			config.Delay,
+			config.Timeout,
 			config.Queries,
+			[]string{},
+			config.QueryTimeouts,
+			config.QueryConfigs,
+			config.Parser,
+			config.RecordSeparator,
 			config.Port,
 			config.History,
+			config.Units,
 			resultsReadyChan,
 		)
 
-		// Process mode has specific labels--ignore user provided ones.
-		ctx = context.WithValue(ctx, "labels", lib.ProfileLabels)
+		// Profiles label their own results--ignore user provided labels.
+		ctx = context.WithValue(ctx, "labels", []string{})
 	case config.Mode == int(MODE_QUERY):
 		slog.Debug("Executing in query mode")
 
 		doneQueriesChan, pauseQueryChans = lib.Query(
+			ctx,
 			lib.QUERY_MODE_COMMAND,
 			config.Count,
 			config.Delay,
+			config.Timeout,
 			config.Queries,
+			config.Labels,
+			config.QueryTimeouts,
+			config.QueryConfigs,
+			config.Parser,
+			config.RecordSeparator,
 			config.Port,
 			config.History,
+			config.Units,
+			resultsReadyChan,
+		)
+
+		// Rely on user-defined labels.
+		ctx = context.WithValue(ctx, "labels", config.Labels)
+	case config.Mode == int(MODE_STREAM):
+		slog.Debug("Executing in stream mode")
+
+		doneQueriesChan, pauseQueryChans = lib.Query(
+			ctx,
+			lib.QUERY_MODE_STREAM,
+			config.Count,
+			config.Delay,
+			config.Timeout,
+			config.Queries,
+			config.Labels,
+			config.QueryTimeouts,
+			config.QueryConfigs,
+			config.Parser,
+			config.RecordSeparator,
+			config.Port,
+			config.History,
+			config.Units,
 			resultsReadyChan,
 		)
 
@@ -72,8 +127,55 @@ func Run(config lib.Config, displayConfig lib.DisplayConfig) {
 	case config.Mode == int(MODE_READ):
 		slog.Debug("Executing in read mode")
 
-	// FIXME Temporarily disabling read mode.
-	// 	done = lib.Read(port)
+		var err error // General error holder.
+
+		// Read from a socket, such as a daemon's, if one is provided.
+		network, address := "tcp", fmt.Sprintf("%s:%s", config.RPCHost, config.Port)
+		if config.RPCSocket != "" {
+			network, address = "unix", config.RPCSocket
+		}
+
+		doneQueriesChan, pauseQueryChans, config.Queries, err = lib.Read(
+			ctx,
+			config.Queries,
+			network,
+			address,
+			&config,
+			resultsReadyChan,
+		)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to read from another Cryptarch: %v\n", err))
+			os.Exit(1)
+		}
+
+		// Results are owned by the other Cryptarch, so never keep history.
+		config.History = false
+
+		// Rely on user-defined labels, otherwise those from the other Cryptarch are used.
+		ctx = context.WithValue(ctx, "labels", config.Labels)
+	case config.Mode == int(MODE_HISTORY):
+		slog.Debug("Executing in history mode")
+
+		var err error // General error holder.
+
+		doneQueriesChan, pauseQueryChans, config.Queries, err = lib.History(
+			ctx,
+			config.Queries,
+			config.From,
+			config.To,
+			&config,
+			resultsReadyChan,
+		)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to read history: %v\n", err))
+			os.Exit(1)
+		}
+
+		// Results are already stored, so never keep them again.
+		config.History = false
+
+		// Rely on user-defined labels, otherwise stored ones are used.
+		ctx = context.WithValue(ctx, "labels", config.Labels)
 	default:
 		slog.Error(fmt.Sprintf("Invalid mode: %d\n", config.Mode))
 		os.Exit(1)
@@ -83,9 +185,33 @@ func Run(config lib.Config, displayConfig lib.DisplayConfig) {
 	ctx = context.WithValue(ctx, "expressions", config.Expressions)
 	ctx = context.WithValue(ctx, "filters", config.Filters)
 	ctx = context.WithValue(ctx, "queries", config.Queries)
+	ctx = context.WithValue(ctx, "rows", config.Rows)
 
 	// Execute result viewing.
-	if !config.Silent {
+	switch {
+	case config.Daemon:
+		// Run in the background until stopped.
+		err := lib.Daemon(
+			ctx,
+			config.DaemonName,
+			config.History,
+			&config,
+			doneQueriesChan,
+			resultsReadyChan,
+		)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to run daemon: %v\n", err))
+			lib.Shutdown()
+			os.Exit(1)
+		}
+		return
+	case config.Silent:
+		// Keep storing results, but don't display them.
+		if err := lib.Headless(config.History, &config, resultsReadyChan); err != nil {
+			slog.Error(fmt.Sprintf("Failed to initialize storage: %v\n", err))
+			os.Exit(1)
+		}
+	default:
 		lib.Results(
 			ctx,
 			lib.DisplayMode(config.DisplayMode),
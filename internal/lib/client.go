@@ -1,32 +1,61 @@
 //
 // Client for RPC.
-//
-// TODO RPC is currently disabled.
 
 package lib
 
 import (
-	// "fmt"
+	"errors"
+	"io"
+	"log/slog"
 	"net/rpc"
+	"sync"
 )
 
 var (
-	client *rpc.Client
+	client        *rpc.Client // Client for retrieving results.
+	clientAddress string      // Address of the RPC server.
+	clientMutex   sync.Mutex  // Mutex for managing client re-connection.
+	clientNetwork string      // Network of the RPC server, e.g. 'tcp' or 'unix'.
 )
 
 // Establish the RPC client to query results.
-func initClient(port string) {
-	// var (
-	// 	err   error
-	// 	reply storage.ResultsRPC
-	// )
-	//
-	// client, err = rpc.DialHTTP("tcp", fmt.Sprintf("localhost:%v", port))
-	// e(err)
-	//
-	// err = client.Call("Results.GetAllRPC", storage.ArgsRPC{}, &reply)
-	// e(err)
-	//
-	// // TODO For now, just print results until we define actions that may be done upon them.
-	// fmt.Printf("Got: %v\n", reply.Results)
+func initClient(network, address string) (err error) {
+	clientMutex.Lock()
+	defer clientMutex.Unlock()
+
+	clientAddress, clientNetwork = address, network
+	client, err = rpc.DialHTTP(network, address)
+
+	return
+}
+
+// Makes an RPC call, re-connecting first if the connection to the server has been lost.
+func callClient(method string, args interface{}, reply interface{}) (err error) {
+	clientMutex.Lock()
+	currentClient := client
+	clientMutex.Unlock()
+
+	err = currentClient.Call(method, args, reply)
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// The server went away--try to re-connect, unless another call already has.
+		slog.Warn("Lost connection to RPC server, re-connecting", "address", clientAddress)
+
+		clientMutex.Lock()
+		if client == currentClient {
+			var newClient *rpc.Client
+
+			newClient, err = rpc.DialHTTP(clientNetwork, clientAddress)
+			if err != nil {
+				clientMutex.Unlock()
+				return
+			}
+			client = newClient
+		}
+		currentClient = client
+		clientMutex.Unlock()
+
+		err = currentClient.Call(method, args, reply)
+	}
+
+	return
 }
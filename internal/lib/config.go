@@ -3,7 +3,12 @@
 
 package lib
 
-import "log/slog"
+import (
+	"log/slog"
+	"time"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
 
 var (
 	logLevelStrtoSlogLevel = map[string]slog.Level{
@@ -16,14 +21,30 @@ var (
 
 // Shareable configuration. See CLI flags for further details.
 type Config struct {
-	Count, Delay, DisplayMode, Mode                                                 int
+	Count, Delay, DisplayMode, Mode, Timeout                                        int
 	ElasticsearchAddr, ElasticsearchIndex, ElasticsearchPassword, ElasticsearchUser string
-	Expressions, Filters, Labels, Queries                                           []string
-	History, LogMulti, Silent                                                       bool
-	LogLevel                                                                        string
+	Expressions, Filters, Labels, Queries, Rows                                     []string
+	Daemon, History, LogMulti, Silent, Units                                        bool
+	DaemonName, LogLevel, Parser                                                    string
 	Port                                                                            string
+	RecordSeparator, RollupAggregate, RPCHost, RPCSocket                            string
 	PrometheusExporterAddr                                                          string
 	PushgatewayAddr                                                                 string
+	From, To                                                                        time.Time
+	QueryConfigs                                                                    map[string]QueryConfig
+	QueryTimeouts                                                                   map[string]int
+	Retention                                                                       storage.Retention
+	StorageLocation                                                                 storage.Location
+}
+
+// Settings specific to a query, replacing general settings for that query. Modes are query modes,
+// e.g. QUERY_MODE_PROFILE.
+type QueryConfig struct {
+	Count, Delay, Mode, Timeout        int
+	Expressions, Filters, Labels, Rows []string
+	Name, Parser, Query                string
+	Retention                          storage.Retention
+	Units                              bool
 }
 
 // Retrieves an Slog level from a human-readable level string.
@@ -0,0 +1,436 @@
+//
+// Logic for running as a daemon, and for managing running daemons.
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	DAEMON_CALL_WAIT  = time.Second            // How long to wait for daemons to respond to control.
+	DAEMON_DIR        = "cryptarch"            // Directory for daemon files.
+	DAEMON_DIR_MODE   = os.FileMode(0700)      // Mode for the daemon directory.
+	DAEMON_ENV        = "CRYPTARCH_DETACHED"   // Environment variable set for detached daemons.
+	DAEMON_EXT_LOG    = ".log"                 // Extension for daemon log files.
+	DAEMON_EXT_PID    = ".pid"                 // Extension for daemon pidfiles.
+	DAEMON_EXT_SOCKET = ".sock"                // Extension for daemon control sockets.
+	DAEMON_FILE_MODE  = os.FileMode(0600)      // Mode for daemon files.
+	DAEMON_RPC_NAME   = "Daemon"               // Name daemon control is registered as for RPC.
+	DAEMON_START_WAIT = 5 * time.Second        // How long to wait for a daemon to start.
+	DAEMON_STOP_WAIT  = 10 * time.Second       // How long to wait for a daemon to stop.
+	DAEMON_WAIT_POLL  = 100 * time.Millisecond // How often to check on daemons when waiting.
+)
+
+// Information about a running daemon.
+type DaemonInfo struct {
+	Name    string    // Name of the daemon.
+	Pid     int       // Process id of the daemon.
+	Queries []string  // Queries the daemon is executing.
+	Socket  string    // Path to the daemon's control socket.
+	Started time.Time // When the daemon started.
+}
+
+// Arguments for daemon control RPC calls.
+type DaemonArgsRPC struct {
+	Name string // Name of the daemon being controlled, guarding against re-used sockets.
+}
+
+// Daemon control exposed for RPC.
+type DaemonRPC struct {
+	info     DaemonInfo // Information about this daemon.
+	stopChan chan bool  // Channel for signaling the daemon to stop.
+	stopOnce sync.Once  // Ensures stop is only signaled once.
+}
+
+var (
+	daemonFiles      []string   // Files created by this daemon, to remove on shutdown.
+	daemonFilesMutex sync.Mutex // Mutex for managing daemon files.
+)
+
+// Checks that control calls are meant for this daemon.
+func (d *DaemonRPC) checkName(args *DaemonArgsRPC) error {
+	if args.Name != (*d).info.Name {
+		return fmt.Errorf("Not daemon %s", args.Name)
+	}
+
+	return nil
+}
+
+// Retrieves information about the daemon.
+func (d *DaemonRPC) Info(args *DaemonArgsRPC, reply *DaemonInfo) error {
+	if err := d.checkName(args); err != nil {
+		return err
+	}
+	*reply = (*d).info
+
+	return nil
+}
+
+// Stops the daemon. The daemon stops after replying.
+func (d *DaemonRPC) Stop(args *DaemonArgsRPC, reply *DaemonInfo) error {
+	if err := d.checkName(args); err != nil {
+		return err
+	}
+	*reply = (*d).info
+	(*d).stopOnce.Do(func() { close((*d).stopChan) })
+
+	return nil
+}
+
+// Retrieves the directory for daemon files, creating it if it doesn't exist. The user runtime
+// directory is preferred, falling back to the user cache directory.
+func daemonDir() (dir string, err error) {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		dir = filepath.Join(runtimeDir, DAEMON_DIR)
+	} else {
+		var cacheDir string
+		if cacheDir, err = os.UserCacheDir(); err != nil {
+			return
+		}
+		dir = filepath.Join(cacheDir, DAEMON_DIR, "daemons")
+	}
+	err = os.MkdirAll(dir, DAEMON_DIR_MODE)
+
+	return
+}
+
+// Retrieves the path to a daemon file.
+func daemonPath(name, ext string) (string, error) {
+	dir, err := daemonDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, name+ext), nil
+}
+
+// Reads the process id of a daemon from its pidfile, and whether or not that process is alive.
+func daemonPid(name string) (pid int, alive bool, err error) {
+	pidPath, err := daemonPath(name, DAEMON_EXT_PID)
+	if err != nil {
+		return
+	}
+	pidData, err := os.ReadFile(pidPath)
+	if err != nil {
+		return
+	}
+	if pid, err = strconv.Atoi(strings.TrimSpace(string(pidData))); err != nil {
+		return
+	}
+	alive = syscall.Kill(pid, syscall.Signal(0)) == nil
+
+	return
+}
+
+// Makes a daemon control call over a daemon's socket.
+func callDaemon(name, method string, reply *DaemonInfo) error {
+	socketPath, err := daemonPath(name, DAEMON_EXT_SOCKET)
+	if err != nil {
+		return err
+	}
+	daemonClient, err := rpc.DialHTTP("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer daemonClient.Close()
+
+	call := daemonClient.Go(DAEMON_RPC_NAME+"."+method, &DaemonArgsRPC{Name: name}, reply, nil)
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(DAEMON_CALL_WAIT):
+		return fmt.Errorf("Timed out contacting daemon %s", name)
+	}
+}
+
+// Removes any files created by this daemon.
+func removeDaemonFiles() {
+	daemonFilesMutex.Lock()
+	defer daemonFilesMutex.Unlock()
+
+	for _, daemonFile := range daemonFiles {
+		if err := os.Remove(daemonFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			e(err)
+		}
+	}
+	daemonFiles = nil
+}
+
+// Removes files left behind by a daemon that is no longer running.
+func removeStaleDaemonFiles(name string) {
+	for _, ext := range []string{DAEMON_EXT_PID, DAEMON_EXT_SOCKET} {
+		if path, err := daemonPath(name, ext); err == nil {
+			os.Remove(path)
+		}
+	}
+}
+
+// Checks that a daemon name is usable.
+func validateDaemonName(name string) error {
+	if name == "" || strings.ContainsRune(name, filepath.Separator) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("Bad daemon name: %q", name)
+	}
+
+	return nil
+}
+
+// Retrieves the path to a daemon's log file, used when no other log file is provided.
+func DaemonLogPath(name string) (string, error) {
+	return daemonPath(name, DAEMON_EXT_LOG)
+}
+
+// Retrieves the name a daemon should use. Without a provided name, the process id is used.
+func DaemonName(name string) string {
+	if name == "" {
+		return strconv.Itoa(os.Getpid())
+	}
+
+	return name
+}
+
+// Retrieves the path to a running daemon's control socket.
+func DaemonSocket(name string) (string, error) {
+	if _, alive, err := daemonPid(name); err != nil {
+		return "", fmt.Errorf("No daemon %s: %v", name, err)
+	} else if !alive {
+		removeStaleDaemonFiles(name)
+		return "", fmt.Errorf("Daemon %s is not running", name)
+	}
+
+	return daemonPath(name, DAEMON_EXT_SOCKET)
+}
+
+// Whether or not this process is a detached daemon.
+func IsDaemon() bool {
+	return os.Getenv(DAEMON_ENV) != ""
+}
+
+// Starts this process over again as a detached daemon with the same arguments, waiting for it to
+// be ready. Returns the name of the started daemon.
+func Detach(name string) (string, error) {
+	var (
+		exitedChan = make(chan error, 1) // Signals that the daemon exited early.
+	)
+
+	if name != "" {
+		if err := validateDaemonName(name); err != nil {
+			return "", err
+		}
+		if _, alive, _ := daemonPid(name); alive {
+			return "", fmt.Errorf("Daemon %s is already running", name)
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=1", DAEMON_ENV))
+	// Start a new session so that the daemon is not attached to this terminal.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		return "", err
+	}
+	go func() { exitedChan <- cmd.Wait() }()
+
+	if name == "" {
+		name = strconv.Itoa(cmd.Process.Pid)
+	}
+
+	// Wait for the daemon to be ready to be controlled.
+	deadline := time.Now().Add(DAEMON_START_WAIT)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exitedChan:
+			logPath, _ := DaemonLogPath(name)
+			return "", fmt.Errorf("Daemon %s exited (%v), see %s", name, err, logPath)
+		default:
+		}
+
+		info := DaemonInfo{}
+		if callDaemon(name, "Info", &info) == nil {
+			return name, nil
+		}
+		time.Sleep(DAEMON_WAIT_POLL)
+	}
+
+	return "", fmt.Errorf("Timed out waiting for daemon %s to start", name)
+}
+
+// Lists running daemons. Files left behind by daemons that are no longer running are removed.
+func ListDaemons() (daemons []DaemonInfo, err error) {
+	dir, err := daemonDir()
+	if err != nil {
+		return
+	}
+	pidPaths, err := filepath.Glob(filepath.Join(dir, "*"+DAEMON_EXT_PID))
+	if err != nil {
+		return
+	}
+
+	for _, pidPath := range pidPaths {
+		name := strings.TrimSuffix(filepath.Base(pidPath), DAEMON_EXT_PID)
+
+		pid, alive, err := daemonPid(name)
+		if err != nil || !alive {
+			slog.Debug("Removing stale daemon files", "name", name)
+			removeStaleDaemonFiles(name)
+			continue
+		}
+
+		info := DaemonInfo{}
+		if err := callDaemon(name, "Info", &info); err != nil {
+			// The daemon is running, but can't be controlled.
+			slog.Warn("Failed to contact daemon", "name", name, "err", err)
+			info = DaemonInfo{Name: name, Pid: pid}
+		}
+		daemons = append(daemons, info)
+	}
+	slices.SortFunc(daemons, func(a, b DaemonInfo) int { return strings.Compare(a.Name, b.Name) })
+
+	return
+}
+
+// Stops a running daemon, waiting for it to exit. If the daemon can't be contacted, it is sent a
+// SIGTERM instead.
+func StopDaemon(name string) error {
+	pid, alive, err := daemonPid(name)
+	if err != nil {
+		return fmt.Errorf("No daemon %s: %v", name, err)
+	} else if !alive {
+		removeStaleDaemonFiles(name)
+		return fmt.Errorf("Daemon %s is not running", name)
+	}
+
+	info := DaemonInfo{}
+	if err = callDaemon(name, "Stop", &info); err != nil {
+		slog.Warn("Failed to contact daemon, terminating it", "name", name, "err", err)
+		if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			return err
+		}
+	}
+
+	// Wait for the daemon to exit.
+	deadline := time.Now().Add(DAEMON_STOP_WAIT)
+	for time.Now().Before(deadline) {
+		if syscall.Kill(pid, syscall.Signal(0)) != nil {
+			removeStaleDaemonFiles(name)
+			return nil
+		}
+		time.Sleep(DAEMON_WAIT_POLL)
+	}
+
+	return fmt.Errorf("Timed out waiting for daemon %s to stop", name)
+}
+
+// Stops queries and removes any daemon files. Used when Cryptarch is interrupted.
+func Shutdown() {
+	StopQueries()
+	removeDaemonFiles()
+}
+
+// Runs without displaying results. Storage, persistence, and external storage remain active.
+func Headless(history bool, inputConfig *Config, resultsReadyChan chan bool) error {
+	if err := initStorage(history, inputConfig); err != nil {
+		return err
+	}
+
+	// Signals that results are ready to be received.
+	slog.Debug("Results are ready")
+	resultsReadyChan <- true
+
+	return nil
+}
+
+// Entrypoint for running as a daemon. Runs headless, writing a pidfile and serving a control socket
+// until either stopped or all queries complete.
+func Daemon(
+	ctx context.Context,
+	name string,
+	history bool,
+	inputConfig *Config,
+	doneQueriesChan chan bool,
+	resultsReadyChan chan bool,
+) error {
+	if err := validateDaemonName(name); err != nil {
+		return err
+	}
+	if pid, alive, _ := daemonPid(name); alive && pid != os.Getpid() {
+		return fmt.Errorf("Daemon %s is already running", name)
+	}
+	pidPath, err := daemonPath(name, DAEMON_EXT_PID)
+	if err != nil {
+		return err
+	}
+	socketPath, err := daemonPath(name, DAEMON_EXT_SOCKET)
+	if err != nil {
+		return err
+	}
+
+	if err = Headless(history, inputConfig, resultsReadyChan); err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// Write the pidfile.
+	err = os.WriteFile(pidPath, []byte(fmt.Sprintf("%d\n", os.Getpid())), DAEMON_FILE_MODE)
+	if err != nil {
+		return err
+	}
+	daemonFilesMutex.Lock()
+	daemonFiles = append(daemonFiles, pidPath)
+	daemonFilesMutex.Unlock()
+	defer removeDaemonFiles()
+
+	// Serve the control socket, replacing any left behind.
+	daemonRPC := &DaemonRPC{
+		info: DaemonInfo{
+			Name:    name,
+			Pid:     os.Getpid(),
+			Queries: inputConfig.Queries,
+			Socket:  socketPath,
+			Started: time.Now(),
+		},
+		stopChan: make(chan bool),
+	}
+	os.Remove(socketPath)
+	listener, err := initSocketServer(socketPath, map[string]interface{}{DAEMON_RPC_NAME: daemonRPC})
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	daemonFilesMutex.Lock()
+	daemonFiles = append(daemonFiles, socketPath)
+	daemonFilesMutex.Unlock()
+
+	slog.Info("Daemon started", "name", name, "pid", os.Getpid(), "socket", socketPath)
+
+	select {
+	case <-ctx.Done():
+		slog.Info("Daemon cancelled", "name", name)
+	case <-daemonRPC.stopChan:
+		slog.Info("Daemon stopped", "name", name)
+	case <-doneQueriesChan:
+		slog.Info("Daemon queries completed", "name", name)
+	}
+	StopQueries()
+
+	return nil
+}
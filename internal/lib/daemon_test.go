@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Serves control for a test daemon running as this process.
+func testDaemon(t *testing.T, name string) *DaemonRPC {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	pidPath, _ := daemonPath(name, DAEMON_EXT_PID)
+	socketPath, _ := daemonPath(name, DAEMON_EXT_SOCKET)
+	daemonRPC := &DaemonRPC{
+		info: DaemonInfo{
+			Name:    name,
+			Pid:     os.Getpid(),
+			Queries: []string{"foo"},
+			Socket:  socketPath,
+			Started: time.Now().Round(0),
+		},
+		stopChan: make(chan bool),
+	}
+
+	err := os.WriteFile(pidPath, []byte(fmt.Sprintf("%d\n", os.Getpid())), DAEMON_FILE_MODE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := initSocketServer(socketPath, map[string]interface{}{DAEMON_RPC_NAME: daemonRPC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	return daemonRPC
+}
+
+func TestListDaemons(t *testing.T) {
+	daemonRPC := testDaemon(t, "foo")
+
+	// Write files for a daemon that is no longer running.
+	stalePidPath, _ := daemonPath("bar", DAEMON_EXT_PID)
+	os.WriteFile(stalePidPath, []byte("999999999\n"), DAEMON_FILE_MODE)
+
+	// It lists running daemons.
+	got, err := ListDaemons()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DaemonInfo{daemonRPC.info}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It removes files for daemons that are no longer running.
+	if _, err := os.Stat(stalePidPath); !os.IsNotExist(err) {
+		t.Errorf("Got: %v Expected: %v\n", err, os.ErrNotExist)
+	}
+}
+
+func TestDaemonStop(t *testing.T) {
+	daemonRPC := testDaemon(t, "foo")
+
+	// It refuses control meant for other daemons.
+	if err := daemonRPC.Stop(&DaemonArgsRPC{Name: "bar"}, &DaemonInfo{}); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+
+	// It stops the daemon.
+	if err := callDaemon("foo", "Stop", &DaemonInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-daemonRPC.stopChan:
+	case <-time.After(time.Second):
+		t.Errorf("Got: %v Expected: %v\n", "running", "stopped")
+	}
+
+	// It allows being stopped more than once.
+	if err := callDaemon("foo", "Stop", &DaemonInfo{}); err != nil {
+		t.Errorf("Got: %v Expected: %v\n", err, nil)
+	}
+}
+
+func TestValidateDaemonName(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"":        false,
+		"../foo":  false,
+		".foo":    false,
+		"foo":     true,
+		"foo-bar": true,
+	} {
+		if got := validateDaemonName(name) == nil; got != expected {
+			t.Errorf("Got: %v Expected: %v (%s)\n", got, expected, name)
+		}
+	}
+}
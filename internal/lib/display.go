@@ -8,9 +8,13 @@ import (
 	"log/slog"
 	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
 
 	"github.com/mum4k/termdash/cell"
 	"github.com/mum4k/termdash/widgets/sparkline"
+	"github.com/mum4k/termdash/widgets/text"
 	"github.com/rivo/tview"
 
 	"github.com/spacez320/cryptarch/pkg/storage"
@@ -20,7 +24,7 @@ import (
 type DisplayConfig struct {
 	HelpSize, LogsSize, ResultsSize                                          int  // Proportional size of widgets.
 	OuterPaddingBottom, OuterPaddingLeft, OuterPaddingRight, OuterPaddingTop int  // Padding for the full display.
-	ShowHelp, ShowLogs, ShowStatus                                           bool // Whether or not to show widgets.
+	ShowHelp, ShowLogs, ShowMetadata, ShowStatus                             bool // Whether or not to show widgets.
 	TablePadding                                                             int  // Padding for table cells in table displays.
 }
 
@@ -59,7 +63,11 @@ const (
 
 // Misc. constants.
 const (
-	HELP_TEXT = "(ESC) Quit | (Space) Pause | (Tab) Next Display | (n) Next Query"
+	HELP_TEXT       = "(ESC) Quit | (Space) Pause | (Tab) Next Display | (n) Next Query"
+	STATUS_FAILED   = "failed"    // Status shown for results of queries that exited unsuccessfully.
+	STATUS_OK       = "ok"        // Status shown for results that were successfully produced.
+	STATUS_TIMEOUT  = "timed out" // Status shown for results of queries that timed out.
+	TABLE_ROW_LABEL = "Row"       // Label of the column of row keys in table displays.
 )
 
 var (
@@ -69,7 +77,12 @@ var (
 		DISPLAY_MODE_TABLE,
 		DISPLAY_MODE_GRAPH,
 	} // Display modes considered for use in the current session.
-	interruptChan = make(chan bool) // Channel for interrupting displays.
+	interruptChan  = make(chan bool) // Channel for interrupting displays.
+	metadataLabels = []string{
+		"Exit Code",
+		"Duration",
+		"Stderr",
+	} // Labels for result metadata, corresponding to `resultMetadataCells`.
 )
 
 // Starts the display. Applies contextual logic depending on the provided display driver. Expects a
@@ -94,6 +107,66 @@ func displayQuit() {
 	close(interruptChan)
 }
 
+// Determines whether a result should be displayed, given the keys of rows to display. Results that
+// aren't of a row are always displayed, as are all rows if no keys are given.
+func showRow(result storage.Result, rows []string) bool {
+	return result.Key == "" || len(rows) == 0 || slices.Contains(rows, result.Key)
+}
+
+// Describes the status of a result for displaying.
+func resultStatus(result storage.Result) string {
+	switch {
+	case result.TimedOut:
+		return STATUS_TIMEOUT
+	case result.ExitCode != 0:
+		return STATUS_FAILED
+	}
+
+	return STATUS_OK
+}
+
+// Describes the metadata of a result for displaying. Only the first line of any error output is
+// included.
+func resultMetadata(result storage.Result) (metadata string) {
+	metadata = fmt.Sprintf(
+		"exit %d, %v",
+		result.ExitCode,
+		result.Duration.Round(time.Millisecond),
+	)
+	if result.Stderr != "" {
+		metadata += ", stderr: " + strings.SplitN(result.Stderr, "\n", 2)[0]
+	}
+
+	return
+}
+
+// Provides the metadata of a result as separate values for displaying, corresponding to
+// `metadataLabels`.
+func resultMetadataCells(result storage.Result) []string {
+	return []string{
+		strconv.Itoa(result.ExitCode),
+		result.Duration.Round(time.Millisecond).String(),
+		strings.SplitN(result.Stderr, "\n", 2)[0],
+	}
+}
+
+// Describes a result as a line of text for stream displays.
+func resultStreamLine(result storage.Result, displayConfig *DisplayConfig) (line string) {
+	if result.TimedOut {
+		line = resultStatus(result)
+	} else {
+		line = fmt.Sprintf("%v", result.Values)
+	}
+	if result.Key != "" {
+		line = fmt.Sprintf("%s: %s", result.Key, line)
+	}
+	if displayConfig.ShowMetadata {
+		line += fmt.Sprintf(" (%s)", resultMetadata(result))
+	}
+
+	return
+}
+
 // Creates a default display config.
 func NewDisplayConfig() *DisplayConfig {
 	return &DisplayConfig{
@@ -106,17 +179,19 @@ func NewDisplayConfig() *DisplayConfig {
 		ResultsSize:        DEFAULT_RESULTS_SIZE,
 		ShowHelp:           true,
 		ShowLogs:           false,
+		ShowMetadata:       true,
 		ShowStatus:         true,
 		TablePadding:       DEFAULT_TABLE_PADDING,
 	}
 }
 
-// Presents raw output.
-func RawDisplay(query string, filters, expressions []string) {
+// Presents raw output. Only results of the given rows are presented, if any are given.
+func RawDisplay(query string, filters, expressions, rows []string) {
 	var (
-		nextResult, prevResult storage.Result // Results tracking.
+		nextResult storage.Result // Results tracking.
 
-		reader = readerIndexes[query] // Reader index for the query.
+		prevResults = make(map[string]storage.Result) // Previous results, for each row.
+		reader      = subscriptions[query].Index()    // Reader index for the query.
 	)
 
 	// Wait for the first result to appear to synchronize storage.
@@ -125,37 +200,49 @@ func RawDisplay(query string, filters, expressions []string) {
 
 	// Load existing results.
 	for _, result := range store.GetToIndex(query, filters, reader) {
+		if !showRow(result, rows) {
+			continue
+		}
+
 		// Execute any expressions.
 		if len(expressions) > 0 {
-			result = ExprResult(query, expressions, result, prevResult)
+			result = ExprResult(query, expressions, result, prevResults[result.Key])
 		}
 
 		fmt.Println(result)
 
-		prevResult = result
+		prevResults[result.Key] = result
 	}
 
 	// Load new results.
 	for {
 		// Get a result and execute expressions.
 		nextResult = GetResult(query, filters)
+		if !showRow(nextResult, rows) {
+			continue
+		}
 
 		if len(expressions) > 0 {
-			nextResult = ExprResult(query, expressions, nextResult, prevResult)
+			nextResult = ExprResult(query, expressions, nextResult, prevResults[nextResult.Key])
 		}
 
 		fmt.Println(nextResult)
 
-		prevResult = nextResult
+		prevResults[nextResult.Key] = nextResult
 	}
 }
 
-// Update the results pane with new results as they are generated.
-func StreamDisplay(query string, filters, expressions []string, displayConfig *DisplayConfig) {
+// Update the results pane with new results as they are generated. Only results of the given rows are
+// presented, if any are given.
+func StreamDisplay(
+	query string,
+	filters, expressions, rows []string,
+	displayConfig *DisplayConfig,
+) {
 	var (
 		widgets tviewWidgets // Widgets produced by tview.
 
-		reader = readerIndexes[query] // Reader index for the query.
+		reader = subscriptions[query].Index() // Reader index for the query.
 	)
 
 	// Wait for the first result to appear to synchronize storage.
@@ -170,20 +257,30 @@ func StreamDisplay(query string, filters, expressions []string, displayConfig *D
 		DISPLAY_TVIEW,
 		func() {
 			var (
-				nextResult, prevResult storage.Result // Results tracking.
+				nextResult storage.Result // Results tracking.
+
+				prevResults = make(map[string]storage.Result) // Previous results, for each row.
 			)
 
 			// Load existing results.
 			for _, result := range store.GetToIndex(query, filters, reader) {
+				if !showRow(result, rows) {
+					continue
+				}
+
 				// Execute any expressions.
 				if len(expressions) > 0 {
-					result = ExprResult(query, expressions, result, prevResult)
+					result = ExprResult(query, expressions, result, prevResults[result.Key])
 				}
 
 				// Display the next result.
-				fmt.Fprintln(widgets.resultsWidget.(*tview.TextView), result.Values)
+				fmt.Fprintln(
+					widgets.resultsWidget.(*tview.TextView),
+					resultStreamLine(result, displayConfig),
+				)
+				setStatusTview(widgets, result)
 
-				prevResult = result
+				prevResults[result.Key] = result
 			}
 
 			// Load new results.
@@ -199,38 +296,43 @@ func StreamDisplay(query string, filters, expressions []string, displayConfig *D
 				default:
 					// Get a result and execute expressions.
 					nextResult = GetResult(query, filters)
+					if !showRow(nextResult, rows) {
+						continue
+					}
 					if len(expressions) > 0 {
-						nextResult = ExprResult(query, expressions, nextResult, prevResult)
+						nextResult = ExprResult(query, expressions, nextResult, prevResults[nextResult.Key])
 					}
 
 					// We can display the next result.
-					fmt.Fprintln(widgets.resultsWidget.(*tview.TextView), nextResult.Values)
+					fmt.Fprintln(
+						widgets.resultsWidget.(*tview.TextView),
+						resultStreamLine(nextResult, displayConfig),
+					)
+					setStatusTview(widgets, nextResult)
 
-					prevResult = nextResult
+					prevResults[nextResult.Key] = nextResult
 				}
 			}
 		},
 	)
 }
 
-// Creates a table of results for the results pane.
-func TableDisplay(query string, filters, expressions []string, displayConfig *DisplayConfig) {
+// Creates a table of results for the results pane. Results of rows are presented with their row
+// keys, and only results of the given rows are presented, if any are given.
+func TableDisplay(
+	query string,
+	filters, expressions, rows []string,
+	displayConfig *DisplayConfig,
+) {
 	var (
-		labels  []string     // Labels to use for displaying.
-		widgets tviewWidgets // Widgets produced by tview.
+		labels     []string     // Labels to use for displaying.
+		rowColumns int          // Number of columns for row keys, before values.
+		widgets    tviewWidgets // Widgets produced by tview.
 
 		cellContentParser = func(value interface{}) (cellContent string) {
-			switch value.(type) {
-			case int64:
-				cellContent = strconv.FormatInt(value.(int64), 10)
-			case float64:
-				cellContent = strconv.FormatFloat(value.(float64), 'f', -1, 64)
-			default:
-				cellContent = value.(string)
-			}
-			return
+			return storage.FormatValue(value)
 		} // Parses results for displaying in table cells.
-		reader           = readerIndexes[query]                            // Reader index for the query.
+		reader           = subscriptions[query].Index()                    // Reader index for the query.
 		tableCellPadding = strings.Repeat(" ", displayConfig.TablePadding) // Padding to add to table cell content.
 	)
 
@@ -246,6 +348,15 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 		// Get labels according to filters.
 		labels = store.GetLabels(query, filters)
 	}
+	if len(store.GetRows(query)) > 0 {
+		// Results are rows of a table--show which row each is.
+		labels = append([]string{TABLE_ROW_LABEL}, labels...)
+		rowColumns = 1
+	}
+	valueLabels := labels
+	if displayConfig.ShowMetadata {
+		labels = append(slices.Clip(labels), metadataLabels...)
+	}
 
 	// Initialize the display.
 	widgets = initDisplayTviewTable(query, filters, store.GetLabels(query, []string{}), displayConfig)
@@ -255,11 +366,29 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 		DISPLAY_TVIEW,
 		func() {
 			var (
-				nextResult, prevResult storage.Result // Results tracking.
+				nextResult storage.Result // Results tracking.
 
-				i = 0 // Used to determine the next row index.
+				i           = 0                               // Used to determine the next row index.
+				prevResults = make(map[string]storage.Result) // Previous results, for each row.
 			)
 
+			// Adds result metadata to a row.
+			setMetadataCells := func(row *tview.Table, result storage.Result) {
+				if !displayConfig.ShowMetadata {
+					return
+				}
+				for j, cellContent := range resultMetadataCells(result) {
+					row.SetCellSimple(i, len(valueLabels)+j, tableCellPadding+cellContent+tableCellPadding)
+				}
+			}
+
+			// Adds the row key of a result to a row.
+			setRowCell := func(row *tview.Table, result storage.Result) {
+				if rowColumns > 0 {
+					row.SetCellSimple(i, 0, tableCellPadding+result.Key+tableCellPadding)
+				}
+			}
+
 			// Load table header.
 			appTview.QueueUpdateDraw(func() {
 				// Row to contain the labels.
@@ -272,8 +401,23 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 
 			// Load existing results.
 			for _, result := range GetPrevResults(query, filters) {
+				if !showRow(result, rows) {
+					continue
+				}
+
 				// We can display the next result.
 				appTview.QueueUpdateDraw(func() {
+					setStatusTview(widgets, result)
+
+					if result.TimedOut {
+						// Show that the query timed out in place of values.
+						row := widgets.resultsWidget.(*tview.Table).InsertRow(i)
+						row.SetCellSimple(
+							i, rowColumns, tableCellPadding+resultStatus(result)+tableCellPadding)
+						setMetadataCells(row, result)
+						i += 1
+						return
+					}
 					if result.IsEmptyValues() {
 						// Ignore empty results.
 						slog.Warn("Cannot display an empty result", "query", query)
@@ -284,15 +428,18 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 
 					// Execute any expressions.
 					if len(expressions) > 0 {
-						result = ExprResult(query, expressions, result, prevResult)
+						result = ExprResult(query, expressions, result, prevResults[result.Key])
 					}
 
 					// Load results into the next row.
+					setRowCell(row, result)
 					for j, value := range result.Values {
-						row.SetCellSimple(i, j, tableCellPadding+cellContentParser(value)+tableCellPadding)
+						row.SetCellSimple(
+							i, rowColumns+j, tableCellPadding+cellContentParser(value)+tableCellPadding)
 					}
+					setMetadataCells(row, result)
 
-					prevResult = result
+					prevResults[result.Key] = result
 					i += 1
 				})
 			}
@@ -312,6 +459,20 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 					appTview.QueueUpdateDraw(func() {
 						// Get a result and execute expressions.
 						nextResult = GetResult(query, filters)
+						if !showRow(nextResult, rows) {
+							return
+						}
+						setStatusTview(widgets, nextResult)
+
+						if nextResult.TimedOut {
+							// Show that the query timed out in place of values.
+							row := widgets.resultsWidget.(*tview.Table).InsertRow(i)
+							row.SetCellSimple(
+								i, rowColumns, tableCellPadding+resultStatus(nextResult)+tableCellPadding)
+							setMetadataCells(row, nextResult)
+							i += 1
+							return
+						}
 						if nextResult.IsEmptyValues() {
 							// Ignore empty results.
 							slog.Warn("Cannot display an empty result", "query", query)
@@ -321,15 +482,19 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 						row := widgets.resultsWidget.(*tview.Table).InsertRow(i) // Row to contain the result.
 
 						if len(expressions) > 0 {
-							nextResult = ExprResult(query, expressions, nextResult, prevResult)
+							nextResult = ExprResult(
+								query, expressions, nextResult, prevResults[nextResult.Key])
 						}
 
 						// Display something if we have something.
+						setRowCell(row, nextResult)
 						for j, value := range nextResult.Values {
-							row.SetCellSimple(i, j, tableCellPadding+cellContentParser(value)+tableCellPadding)
+							row.SetCellSimple(
+								i, rowColumns+j, tableCellPadding+cellContentParser(value)+tableCellPadding)
 						}
+						setMetadataCells(row, nextResult)
 
-						prevResult = nextResult
+						prevResults[nextResult.Key] = nextResult
 						i += 1
 					})
 				}
@@ -338,23 +503,27 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 	)
 }
 
-// Creates a graph of results for the results pane.
-func GraphDisplay(query string, filter string, expressions []string, displayConfig *DisplayConfig) {
+// Creates a graph of results for the results pane. Results of rows are graphed for a single row, the
+// first of the given rows or the first row if none are given.
+func GraphDisplay(
+	query string,
+	filter string,
+	expressions, rows []string,
+	displayConfig *DisplayConfig,
+) {
 	var (
-		err error // General error holder.
+		err      error    // General error holder.
+		graphRow []string // Row to graph, for results of rows.
 
 		sparkParser = func(value interface{}) (spark []int) {
-			switch value.(type) {
-			case int64:
-				spark = []int{int(value.(int64))}
-			case float64:
-				spark = []int{int(value.(float64))}
+			if value, ok := storage.ValueFloat(value); ok {
+				spark = []int{int(value)}
 			}
 			return
 		} // Parses results for displaying in table cells.
-		reader     = readerIndexes[query] // Reader index for the query.
-		valueIndex = 0                    // Index of the result value to graph.
-		widgets    = termdashWidgets{}    // Widgets for displaying.
+		reader     = subscriptions[query].Index() // Reader index for the query.
+		valueIndex = 0                            // Index of the result value to graph.
+		widgets    = termdashWidgets{}            // Widgets for displaying.
 	)
 
 	// Wait for the first result to appear to synchronize storage.
@@ -366,16 +535,29 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 	if filter != "" {
 		valueIndex = store.GetValueIndex(query, filter)
 	}
+	graphLabel := store.GetLabels(query, []string{})[valueIndex]
+
+	// Determine the row to graph, if results are rows.
+	if len(rows) > 0 {
+		graphRow = rows[:1]
+	} else if keys := store.GetRows(query); len(keys) > 0 {
+		graphRow = keys[:1]
+	}
+	if len(graphRow) > 0 {
+		graphLabel = fmt.Sprintf("%s (%s)", graphLabel, graphRow[0])
+	}
 
 	// Initialize the results view.
 	//
 	// XXX This should probably moved into `display_termdash.go` once termdash is managing more types
 	// of result displays.
 	widgets.resultsWidget, err = sparkline.New(
-		sparkline.Label(store.GetLabels(query, []string{})[valueIndex]),
+		sparkline.Label(graphLabel),
 		sparkline.Color(cell.ColorGreen),
 	)
 	e(err)
+	widgets.statusWidget, err = text.New()
+	e(err)
 
 	// Start the display.
 	display(
@@ -383,11 +565,19 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 		func() {
 			var (
 				nextResult, prevResult storage.Result // Results tracking.
-				value                  float64        // Value to ultimately display.
 			)
 
 			// Load existing results.
 			for _, result := range store.GetToIndex(query, []string{filter}, reader) {
+				if !showRow(result, graphRow) {
+					continue
+				}
+				setStatusTermdash(widgets, result)
+
+				if result.TimedOut {
+					// Timed out results have nothing to graph.
+					continue
+				}
 				if result.IsEmptyValues() {
 					// Ignore empty results.
 					slog.Warn("Cannot display an empty result", "query", query)
@@ -397,14 +587,8 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 				// Execute any expressions.
 				if len(expressions) > 0 {
 					result = ExprResult(query, expressions, result, prevResult)
-					// Expressions always return a string, so always try to convert it into a float.
-					value, _ = strconv.ParseFloat(result.Values[0].(string), 10)
-					widgets.resultsWidget.(*sparkline.SparkLine).Add(sparkParser(value))
-				} else {
-					// Use typless assignment to account for various numeric values.
-					value := result.Values[0]
-					widgets.resultsWidget.(*sparkline.SparkLine).Add(sparkParser(value))
 				}
+				widgets.resultsWidget.(*sparkline.SparkLine).Add(sparkParser(result.Values[0]))
 
 				prevResult = result
 			}
@@ -422,7 +606,15 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 				default:
 					// Get a result and execute expressions.
 					nextResult = GetResult(query, []string{filter})
+					if !showRow(nextResult, graphRow) {
+						continue
+					}
+					setStatusTermdash(widgets, nextResult)
 
+					if nextResult.TimedOut {
+						// Timed out results have nothing to graph.
+						continue
+					}
 					if nextResult.IsEmptyValues() {
 						// Ignore empty results.
 						slog.Warn("Cannot display an empty result", "query", query)
@@ -431,14 +623,8 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 
 					if len(expressions) > 0 {
 						nextResult = ExprResult(query, expressions, nextResult, prevResult)
-						// Expressions always return a string, so always try to convert it into a float.
-						value, _ = strconv.ParseFloat(nextResult.Values[0].(string), 10)
-						widgets.resultsWidget.(*sparkline.SparkLine).Add(sparkParser(value))
-					} else {
-						// Use typless assignment to account for various numeric values.
-						value := nextResult.Values[0]
-						widgets.resultsWidget.(*sparkline.SparkLine).Add(sparkParser(value))
 					}
+					widgets.resultsWidget.(*sparkline.SparkLine).Add(sparkParser(nextResult.Values[0]))
 				}
 
 				prevResult = nextResult
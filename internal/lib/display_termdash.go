@@ -17,6 +17,8 @@ import (
 	"github.com/mum4k/termdash/widgetapi"
 	"github.com/mum4k/termdash/widgets/text"
 	slogmulti "github.com/samber/slog-multi"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
 )
 
 // Used to provide an io.Writer implementation of termdash text widgets.
@@ -32,8 +34,8 @@ func (t *termdashTextWriter) Write(p []byte) (n int, err error) {
 
 // Used to supply optional widgets to Termdash initialization.
 type termdashWidgets struct {
-	filterWidget, helpWidget, labelWidget, logsWidget, queryWidget *text.Text
-	resultsWidget                                                  widgetapi.Widget
+	filterWidget, helpWidget, labelWidget, logsWidget, queryWidget, statusWidget *text.Text
+	resultsWidget                                                                widgetapi.Widget
 }
 
 var (
@@ -76,6 +78,12 @@ func keyboardTermdashHandler(key *terminalapi.Keyboard) {
 	}
 }
 
+// Updates the status widget with the status of the latest result.
+func setStatusTermdash(widgets termdashWidgets, result storage.Result) {
+	widgets.statusWidget.Reset()
+	widgets.statusWidget.Write(fmt.Sprintf("%s (%s)", resultStatus(result), resultMetadata(result)))
+}
+
 // Error management for termdash.
 func errorTermdashHandler(e error) {
 	// If we hit an error from termdash, just log it and try to continue. Cases of errors seen so far
@@ -107,6 +115,10 @@ func initDisplayTermdash(
 	e(err)
 	widgets.queryWidget, err = text.New()
 	e(err)
+	if widgets.statusWidget == nil {
+		widgets.statusWidget, err = text.New()
+		e(err)
+	}
 
 	// Instantiate optional displays.
 	if displayConfig.ShowHelp {
@@ -147,14 +159,25 @@ func initDisplayTermdash(
 									container.PlaceWidget(widgets.labelWidget),
 								),
 								container.Right(
-									container.Border(linestyle.Light),
-									container.BorderTitle("Filters"),
-									container.BorderTitleAlignCenter(),
-									container.PlaceWidget(widgets.labelWidget),
+									container.SplitVertical(
+										container.Left(
+											container.Border(linestyle.Light),
+											container.BorderTitle("Filters"),
+											container.BorderTitleAlignCenter(),
+											container.PlaceWidget(widgets.filterWidget),
+										),
+										container.Right(
+											container.Border(linestyle.Light),
+											container.BorderTitle("Status"),
+											container.BorderTitleAlignCenter(),
+											container.PlaceWidget(widgets.statusWidget),
+										),
+									),
 								),
+								container.SplitPercent(33),
 							),
 						),
-						container.SplitPercent(33),
+						container.SplitPercent(25),
 					),
 				),
 				container.Bottom(
@@ -277,7 +300,7 @@ func initDisplayTermdash(
 	}
 
 	// Initialize the top-line status widgets.
-	widgets.queryWidget.Write(query)
+	widgets.queryWidget.Write(queryTitle(query))
 	widgets.filterWidget.Write(fmt.Sprintf("%v", filters))
 	widgets.labelWidget.Write(fmt.Sprintf("%v", labels))
 
@@ -11,13 +11,15 @@ import (
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	slogmulti "github.com/samber/slog-multi"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
 )
 
 // Widgets for tview displays.
 type tviewWidgets struct {
-	flexBox                                                        *tview.Flex
-	filterWidget, helpWidget, labelWidget, logsWidget, queryWidget *tview.TextView
-	resultsWidget                                                  tview.Primitive
+	flexBox                                                                      *tview.Flex
+	filterWidget, helpWidget, labelWidget, logsWidget, queryWidget, statusWidget *tview.TextView
+	resultsWidget                                                                tview.Primitive
 }
 
 var (
@@ -71,6 +73,12 @@ func keyboardTviewHandler(event *tcell.EventKey) *tcell.EventKey {
 	return event
 }
 
+// Updates the status widget with the status of the latest result.
+func setStatusTview(widgets tviewWidgets, result storage.Result) {
+	widgets.statusWidget.Clear()
+	fmt.Fprintf(widgets.statusWidget, "%s (%s)", resultStatus(result), resultMetadata(result))
+}
+
 // Display init function specific to table results.
 func initDisplayTviewTable(
 	query string,
@@ -127,11 +135,13 @@ func initDisplayTview(
 	widgets.labelWidget = tview.NewTextView()
 	widgets.logsWidget = tview.NewTextView()
 	widgets.queryWidget = tview.NewTextView()
+	widgets.statusWidget = tview.NewTextView()
 
 	statusWidgets = tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(widgets.queryWidget, 0, 1, false).
 		AddItem(widgets.labelWidget, 0, 1, false).
-		AddItem(widgets.filterWidget, 0, 1, false)
+		AddItem(widgets.filterWidget, 0, 1, false).
+		AddItem(widgets.statusWidget, 0, 1, false)
 
 	// Set-up the layout and apply views.
 	widgets.flexBox = widgets.flexBox.
@@ -159,7 +169,9 @@ func initDisplayTview(
 	widgets.labelWidget.SetBorder(true).SetTitle("Labels")
 	fmt.Fprintf(widgets.labelWidget, "%v", labels)
 	widgets.queryWidget.SetBorder(true).SetTitle("Query")
-	fmt.Fprintf(widgets.queryWidget, query)
+	fmt.Fprint(widgets.queryWidget, queryTitle(query))
+	widgets.statusWidget.SetChangedFunc(func() { appTview.Draw() })
+	widgets.statusWidget.SetBorder(true).SetTitle("Status")
 
 	// Initialize the logs view.
 	widgets.logsWidget.SetScrollable(false).SetChangedFunc(func() { appTview.Draw() })
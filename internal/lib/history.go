@@ -0,0 +1,166 @@
+//
+// Logic for 'history' mode.
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
+
+const (
+	// Most results to present for each query in history mode, before presenting rollups instead.
+	HISTORY_MAX_RESULTS = 2000
+)
+
+// Summary of a stored results series.
+type HistorySeries struct {
+	Count       int       // Number of stored results.
+	First, Last time.Time // Times of the first and last stored results.
+	Labels      []string  // Labels of the series.
+	Query       string    // Query the series is for.
+}
+
+// Lists results series in a persisted storage database. Series are read from the database's backend
+// directly, without restoring storage.
+func ListHistory(location storage.Location) ([]HistorySeries, error) {
+	backend, err := storage.ReadBackend(location)
+	if err != nil {
+		return nil, err
+	}
+	defer backend.Close()
+
+	queries, err := backend.Series()
+	if err != nil {
+		return nil, err
+	}
+	series := make([]HistorySeries, len(queries))
+	for i, query := range queries {
+		labels, err := backend.Labels(query)
+		if err != nil {
+			return nil, err
+		}
+		count, first, last, err := backend.Bounds(query)
+		if err != nil {
+			return nil, err
+		}
+		series[i] = HistorySeries{
+			Count:  count,
+			First:  first,
+			Labels: labels,
+			Last:   last,
+			Query:  query,
+		}
+	}
+
+	return series, nil
+}
+
+// Exports selected results from a persisted storage database in a format, e.g.
+// storage.EXPORT_FORMAT_CSV.
+func Export(
+	location storage.Location,
+	w io.Writer,
+	format string,
+	selection storage.ExportSelection,
+) error {
+	historyStore, err := storage.ReadStorage(location)
+	if err != nil {
+		return err
+	}
+
+	return historyStore.Export(w, format, selection)
+}
+
+// Entrypoint for 'history' mode. Loads results from persisted storage between two times, without
+// executing any queries, so they may be presented like any other results. If no queries are
+// provided, all queries with results in the time range are presented. A zero end time is the current
+// time. Long time ranges are presented with rollups of results, at the finest resolution that fits
+// within HISTORY_MAX_RESULTS. Returns the queries being presented.
+func History(
+	ctx context.Context,
+	queries []string,
+	startTime, endTime time.Time,
+	inputConfig *Config,
+	resultsReadyChan chan bool,
+) (chan bool, map[string]chan bool, []string, error) {
+	var (
+		lastResults  = make(map[string]storage.Result, len(queries)) // Last result of each query.
+		rangeQueries []string                                        // Queries with results in range.
+
+		aggregate       = inputConfig.RollupAggregate // How to present rollups.
+		doneQueriesChan = make(chan bool)             // Signals overall completion, which never happens.
+	)
+
+	historyStore, err := storage.ReadStorage(inputConfig.StorageLocation)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if endTime.IsZero() {
+		endTime = time.Now()
+	}
+	if aggregate == "" {
+		aggregate = storage.ROLLUP_AVG
+	}
+	if len(queries) == 0 {
+		queries = historyStore.Queries()
+	}
+
+	// Results presented from history are never persisted again.
+	if err = initStorage(false, inputConfig); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Load results before any displays start. The last result of each query is held back and stored
+	// once displays are ready, which is what signals them to present results.
+	for _, query := range queries {
+		if _, ok := historyStore.Results[query]; !ok {
+			return nil, nil, nil, &storage.QueryNotFoundError{Query: query}
+		}
+
+		results, resolution := historyStore.GetResolved(
+			query, startTime, endTime, HISTORY_MAX_RESULTS, aggregate)
+		if len(results) == 0 {
+			slog.Warn("No stored results in range", "query", query)
+			continue
+		}
+		slog.Debug("Loaded results", "query", query, "count", len(results), "resolution", resolution)
+
+		store.Load(query, historyStore.GetLabels(query, []string{}), results[:len(results)-1])
+		lastResults[query] = results[len(results)-1]
+		rangeQueries = append(rangeQueries, query)
+	}
+	if len(rangeQueries) == 0 {
+		return nil, nil, nil, fmt.Errorf(
+			"No stored results between %s and %s", startTime.Format(time.DateTime),
+			endTime.Format(time.DateTime))
+	}
+
+	// Nothing is executed, so pausing does nothing.
+	pauseQueryChans := make(map[string]chan bool, len(rangeQueries))
+	for _, query := range rangeQueries {
+		pauseQueryChans[query] = make(chan bool)
+		go func(pauseQueryChan chan bool) {
+			for range pauseQueryChan {
+			}
+		}(pauseQueryChans[query])
+	}
+
+	go func() {
+		// Wait for result consumption to become ready.
+		slog.Debug("Waiting for results readiness")
+		<-resultsReadyChan
+
+		for _, query := range rangeQueries {
+			_, err := store.PutResult(query, lastResults[query], false)
+			e(err)
+		}
+	}()
+
+	return doneQueriesChan, pauseQueryChans, rangeQueries, nil
+}
@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
+
+func TestHistory(t *testing.T) {
+	var (
+		now              = time.Now()
+		resultsReadyChan = make(chan bool)
+	)
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	persisted, err := storage.NewStorage(true, storage.Location{}, storage.Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ago := range []time.Duration{2 * time.Hour, 30 * time.Minute, 10 * time.Minute} {
+		persisted.PutResult("foo", storage.Result{Time: now.Add(-ago), Value: "a"}, true)
+	}
+	persisted.PutResult("bar", storage.Result{Time: now.Add(-2 * time.Hour), Value: "b"}, true)
+	persisted.Close()
+	store, _ = storage.NewStorage(false, storage.Location{}, storage.Retention{}, nil)
+
+	// It presents queries with results in range.
+	_, _, queries, err := History(
+		context.Background(), []string{}, now.Add(-time.Hour), time.Time{}, &Config{}, resultsReadyChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(queries, []string{"foo"}) {
+		t.Errorf("Got: %v Expected: %v\n", queries, []string{"foo"})
+	}
+
+	// It holds back the last result until results are ready.
+	if got := store.GetAll("foo"); len(got) != 1 || !got[0].Time.Equal(now.Add(-30*time.Minute)) {
+		t.Errorf("Got: %v Expected: %v\n", got, now.Add(-30*time.Minute))
+	}
+
+	// It refuses queries that aren't stored.
+	_, _, _, err = History(
+		context.Background(), []string{"fizz"}, time.Time{}, time.Time{}, &Config{}, resultsReadyChan)
+	if err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+}
@@ -0,0 +1,329 @@
+//
+// Importing of external data into storage.
+//
+// Data is imported as a series of results for a query, as if the query had produced them. Supported
+// formats are:
+//
+// - CSV, with a header naming columns. One column holds times and the others hold values.
+// - NDJSON, with an object per line. One key holds times and the others hold values. Objects like
+//   those exported by Cryptarch, with values under a 'values' key, are also understood.
+// - Logs of command output, with each line starting with a time and the rest being the output.
+
+package lib
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	IMPORT_FORMAT_CSV    = "csv"    // CSV import.
+	IMPORT_FORMAT_LOG    = "log"    // Command output log import.
+	IMPORT_FORMAT_NDJSON = "ndjson" // NDJSON import.
+	IMPORT_TIME_FIELD    = "time"   // Default column or key for times.
+	IMPORT_TIME_UNIX     = "unix"   // Time layout for seconds since the Unix epoch.
+)
+
+var (
+	// Supported import formats.
+	ImportFormats = []string{IMPORT_FORMAT_CSV, IMPORT_FORMAT_LOG, IMPORT_FORMAT_NDJSON}
+	// Columns or keys that are never imported as values, because Cryptarch exports them alongside
+	// values.
+	importMetaFields = []string{"query", "timed_out", "value"}
+)
+
+// Settings for importing data.
+type ImportConfig struct {
+	Columns    []string // Columns or keys to import as values, in order. Defaults to all of them.
+	Format     string   // Format of the data, e.g. IMPORT_FORMAT_CSV.
+	TimeField  string   // Column or key holding times, for CSV and NDJSON.
+	TimeLayout string   // Layout of times, as for time.Parse, or IMPORT_TIME_UNIX.
+}
+
+// Retrieves the import format for a file from its extension, or an empty string if it's unknown.
+func ImportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return IMPORT_FORMAT_CSV
+	case ".json", ".jsonl", ".ndjson":
+		return IMPORT_FORMAT_NDJSON
+	case ".log", ".txt":
+		return IMPORT_FORMAT_LOG
+	}
+
+	return ""
+}
+
+// Parses an imported time.
+func parseImportTime(value interface{}, layout string) (time.Time, error) {
+	switch value := value.(type) {
+	case float64:
+		seconds, fraction := math.Modf(value)
+		return time.Unix(int64(seconds), int64(fraction*float64(time.Second))), nil
+	case json.Number:
+		return parseImportTime(string(value), IMPORT_TIME_UNIX)
+	case string:
+		if layout == IMPORT_TIME_UNIX {
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("Bad time %q, expected seconds since the epoch", value)
+			}
+			return parseImportTime(seconds, layout)
+		}
+		return time.Parse(layout, value)
+	}
+
+	return time.Time{}, fmt.Errorf("Bad time %v", value)
+}
+
+// Parses an imported value the same way query output is tokenized, e.g. numbers become numbers.
+func parseImportValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		return parseImportValue(value.String())
+	case string:
+		if tokens := TokenizeResult(value); len(tokens) == 1 {
+			return tokens[0]
+		}
+	}
+
+	return value
+}
+
+// Chooses the columns or keys to import as values, given all that are available.
+func importColumns(available []string, importConfig *ImportConfig) ([]string, error) {
+	if len(importConfig.Columns) == 0 {
+		return slices.DeleteFunc(slices.Clone(available), func(column string) bool {
+			return column == importConfig.TimeField || slices.Contains(importMetaFields, column)
+		}), nil
+	}
+	for _, column := range importConfig.Columns {
+		if !slices.Contains(available, column) {
+			return nil, fmt.Errorf("Column %q not found, expected one of: %s",
+				column, strings.Join(available, ", "))
+		}
+	}
+
+	return importConfig.Columns, nil
+}
+
+// Reads CSV results, sending them to a callback along with the labels of their values.
+func importCSV(
+	r io.Reader,
+	importConfig *ImportConfig,
+	put func(labels []string, result storage.Result) error,
+) error {
+	csvReader := csv.NewReader(r)
+	csvReader.ReuseRecord = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return fmt.Errorf("Failed to read CSV header: %v", err)
+	}
+	header = slices.Clone(header)
+	timeIndex := slices.Index(header, importConfig.TimeField)
+	if timeIndex < 0 {
+		return fmt.Errorf("Time column %q not found", importConfig.TimeField)
+	}
+	columns, err := importColumns(header, importConfig)
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		result := storage.Result{}
+		if result.Time, err = parseImportTime(record[timeIndex], importConfig.TimeLayout); err != nil {
+			line, _ := csvReader.FieldPos(timeIndex)
+			return fmt.Errorf("Line %d: %v", line, err)
+		}
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = record[slices.Index(header, column)]
+			result.Values = append(result.Values, parseImportValue(values[i]))
+		}
+		result.Value = strings.Join(values, " ")
+
+		if err = put(columns, result); err != nil {
+			return err
+		}
+	}
+}
+
+// Reads NDJSON results, sending them to a callback along with the labels of their values. Keys
+// default to those of the first object, in order.
+func importNDJSON(
+	r io.Reader,
+	importConfig *ImportConfig,
+	put func(labels []string, result storage.Result) error,
+) error {
+	var (
+		columns []string // Keys imported as values.
+		err     error    // General error holder.
+	)
+
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	for line := 1; ; line++ {
+		var (
+			fields map[string]interface{} // Fields holding values.
+			object map[string]interface{} // Decoded object.
+		)
+
+		if err = decoder.Decode(&object); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Object %d: %v", line, err)
+		}
+
+		result := storage.Result{}
+		result.Time, err = parseImportTime(object[importConfig.TimeField], importConfig.TimeLayout)
+		if err != nil {
+			return fmt.Errorf("Object %d: %v", line, err)
+		}
+		fields = object
+		if values, ok := object["values"].(map[string]interface{}); ok {
+			// This is an object exported by Cryptarch.
+			fields = values
+		}
+		result.Value, _ = object["value"].(string)
+		result.TimedOut, _ = object["timed_out"].(bool)
+
+		if columns == nil {
+			keys := make([]string, 0, len(fields))
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if columns, err = importColumns(keys, importConfig); err != nil {
+				return err
+			}
+		}
+
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			value := parseImportValue(fields[column])
+			result.Values = append(result.Values, value)
+			if value != nil {
+				values[i] = fmt.Sprint(value)
+			}
+		}
+		if result.Value == "" {
+			result.Value = strings.Join(values, " ")
+		}
+
+		if err = put(columns, result); err != nil {
+			return err
+		}
+	}
+}
+
+// Reads logged command output, sending it to a callback. Each line starts with a time, which takes
+// as many whitespace delimited fields as its layout does, followed by output. Output has no labels.
+func importLog(
+	r io.Reader,
+	importConfig *ImportConfig,
+	put func(labels []string, result storage.Result) error,
+) error {
+	timeFields := len(strings.Fields(importConfig.TimeLayout))
+	if importConfig.TimeLayout == IMPORT_TIME_UNIX {
+		timeFields = 1
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < timeFields {
+			return fmt.Errorf("Line %d: Expected a time", line)
+		}
+
+		result := storage.Result{}
+		resultTime, err := parseImportTime(strings.Join(fields[:timeFields], " "), importConfig.TimeLayout)
+		if err != nil {
+			return fmt.Errorf("Line %d: %v", line, err)
+		}
+		result.Time = resultTime
+		result.Value = strings.Join(fields[timeFields:], " ")
+		result.Values = TokenizeResult(result.Value)
+
+		if err = put(nil, result); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Imports data as results for a query, returning how many results were imported. Results are
+// persisted and sent to external storages like any other results, so importing may be used to
+// backfill them. Labels default to the names of imported columns or keys.
+func Import(r io.Reader, query string, importConfig *ImportConfig, inputConfig *Config) (int, error) {
+	var (
+		err      error // General error holder.
+		imported int   // Number of imported results.
+	)
+
+	if importConfig.TimeField == "" {
+		importConfig.TimeField = IMPORT_TIME_FIELD
+	}
+	if importConfig.TimeLayout == "" {
+		importConfig.TimeLayout = time.RFC3339Nano
+	}
+	if err = initStorage(inputConfig.History, inputConfig); err != nil {
+		return 0, err
+	}
+	defer store.Close()
+	if len(inputConfig.Labels) > 0 {
+		if err = store.PutLabels(query, inputConfig.Labels); err != nil {
+			return 0, err
+		}
+	}
+
+	// Stores an imported result, labelling results by their columns if no labels were provided.
+	put := func(labels []string, result storage.Result) error {
+		if imported == 0 && len(inputConfig.Labels) == 0 && len(labels) > 0 {
+			if err := store.PutLabels(query, labels); err != nil {
+				return err
+			}
+		}
+		imported++
+		_, err := store.PutResult(query, result, inputConfig.History)
+		return err
+	}
+
+	switch importConfig.Format {
+	case IMPORT_FORMAT_CSV:
+		err = importCSV(r, importConfig, put)
+	case IMPORT_FORMAT_LOG:
+		err = importLog(r, importConfig, put)
+	case IMPORT_FORMAT_NDJSON:
+		err = importNDJSON(r, importConfig, put)
+	default:
+		err = fmt.Errorf("Unknown import format %q, expected one of: %s",
+			importConfig.Format, strings.Join(ImportFormats, ", "))
+	}
+
+	return imported, err
+}
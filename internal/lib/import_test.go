@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
+
+func TestImport(t *testing.T) {
+	var (
+		first = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	cases := []struct {
+		name         string
+		data         string
+		importConfig ImportConfig
+		labels       []string
+		values       []interface{}
+		value        string
+	}{
+		{
+			name:         "csv",
+			data:         "time,load,host\n2024-01-01T00:00:00Z,0.5,a\n2024-01-01T00:01:00Z,1,b\n",
+			importConfig: ImportConfig{Format: IMPORT_FORMAT_CSV},
+			labels:       []string{"load", "host"},
+			values:       []interface{}{0.5, "a"},
+			value:        "0.5 a",
+		},
+		{
+			name: "csv columns",
+			data: "ts,load,host\n1704067200,0.5,a\n1704067260,1,b\n",
+			importConfig: ImportConfig{
+				Columns:    []string{"host"},
+				Format:     IMPORT_FORMAT_CSV,
+				TimeField:  "ts",
+				TimeLayout: IMPORT_TIME_UNIX,
+			},
+			labels: []string{"host"},
+			values: []interface{}{"a"},
+			value:  "a",
+		},
+		{
+			name: "ndjson",
+			data: `{"time":"2024-01-01T00:00:00Z","query":"foo","value":"1 x","values":{"a":1,"b":"x"}}` +
+				"\n" + `{"time":"2024-01-01T00:01:00Z","query":"foo","value":"2 y","values":{"a":2,"b":"y"}}`,
+			importConfig: ImportConfig{Format: IMPORT_FORMAT_NDJSON},
+			labels:       []string{"a", "b"},
+			values:       []interface{}{int64(1), "x"},
+			value:        "1 x",
+		},
+		{
+			name:         "log",
+			data:         "2024-01-01 00:00:00 up 3 days\n\n2024-01-01 00:01:00 up 4 days\n",
+			importConfig: ImportConfig{Format: IMPORT_FORMAT_LOG, TimeLayout: time.DateTime},
+			labels:       []string{"0", "1", "2"},
+			values:       []interface{}{"up", int64(3), "days"},
+			value:        "up 3 days",
+		},
+	}
+
+	for _, c := range cases {
+		store, _ = storage.NewStorage(false, storage.Location{}, storage.Retention{}, nil)
+		storeInitialized = true
+
+		imported, err := Import(strings.NewReader(c.data), "foo", &c.importConfig, &Config{})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if imported != 2 {
+			t.Errorf("%s: Got: %v Expected: %v\n", c.name, imported, 2)
+		}
+
+		results := store.GetAll("foo")
+		if !results[0].Time.Equal(first) || !results[1].Time.Equal(first.Add(time.Minute)) {
+			t.Errorf("%s: Got: %v Expected: %v\n", c.name, results, first)
+		}
+		if !reflect.DeepEqual(results[0].Values, storage.Values(c.values)) {
+			t.Errorf("%s: Got: %#v Expected: %#v\n", c.name, results[0].Values, c.values)
+		}
+		if results[0].Value != c.value {
+			t.Errorf("%s: Got: %v Expected: %v\n", c.name, results[0].Value, c.value)
+		}
+		if labels := store.GetLabels("foo", []string{}); !reflect.DeepEqual(labels, c.labels) {
+			t.Errorf("%s: Got: %v Expected: %v\n", c.name, labels, c.labels)
+		}
+	}
+
+	// It refuses data without times.
+	store, _ = storage.NewStorage(false, storage.Location{}, storage.Retention{}, nil)
+	_, err := Import(
+		strings.NewReader("load\n0.5\n"), "foo", &ImportConfig{Format: IMPORT_FORMAT_CSV}, &Config{})
+	if err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+	storeInitialized = false
+}
@@ -0,0 +1,406 @@
+//
+// Parsing of query output into result values.
+//
+// Parsers are given as '<parser>[:<argument>]', e.g. 'json:.data.items[0]'. Supported parsers are:
+//
+// - Whitespace, splitting output on whitespace. This is the default.
+// - Delimiter, splitting output on a delimiter given as the argument, e.g. 'delimiter:,'. Delimiters
+//   accept Go escape sequences, e.g. 'delimiter:\t'.
+// - JSON, optionally selecting part of the output by a path of keys and indexes given as the
+//   argument, e.g. 'json:.data.items[0]'. Objects become a value for each key, arrays a value for
+//   each element, and anything else a single value.
+// - Logfmt, reading 'key=value' pairs, where values may be quoted. Keys without values are true.
+// - CSV, with a header naming columns, optionally with a delimiter other than a comma given as the
+//   argument. Output may include the header along with a record, or the header may be its own
+//   output, e.g. as the first record of a streaming query, with later output being records.
+// - Regex, matching a regular expression given as the argument, where each capture group is a
+//   value, e.g. 'regex:(?P<user>\w+) logged in from (?P<host>\S+)'.
+// - Table, with a header naming columns split on whitespace, where each row is its own result keyed
+//   by a column given as the argument, e.g. 'table:PID'. Rows are keyed by their first column by
+//   default, and the key isn't a value. Rows with more columns than the header have the extra
+//   columns joined into the last, e.g. commands in 'ps aux', and headers with more columns than rows
+//   have extra columns joined into the last label, e.g. 'Mounted on' in 'df -h'. Like CSV, the
+//   header may be its own output.
+//
+// Where output names its values, such as with JSON keys, logfmt keys, CSV and table headers, and
+// regex group names, those names are the labels of the values.
+
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	PARSER_CSV        = "csv"        // Parser for CSV output with a header.
+	PARSER_DELIMITER  = "delimiter"  // Parser for output split on a delimiter.
+	PARSER_JSON       = "json"       // Parser for JSON output.
+	PARSER_LOGFMT     = "logfmt"     // Parser for logfmt output.
+	PARSER_REGEX      = "regex"      // Parser for output matched by a regular expression.
+	PARSER_SEP        = ":"          // Separator between a parser and its argument.
+	PARSER_TABLE      = "table"      // Parser for tabular output, where each row is a result.
+	PARSER_WHITESPACE = "whitespace" // Parser for output split on whitespace.
+)
+
+var (
+	// Supported parsers, with the default first.
+	Parsers = []string{
+		PARSER_WHITESPACE, PARSER_DELIMITER, PARSER_JSON, PARSER_LOGFMT, PARSER_CSV, PARSER_REGEX,
+		PARSER_TABLE,
+	}
+
+	// Output that only had a header, and so has no values.
+	errParseHeader = errors.New("Only a header was parsed")
+)
+
+// Parses query output into values. Parsers may keep state between outputs, e.g. a CSV header, so
+// each query needs its own parser.
+type Parser struct {
+	argument  string         // Argument of the parser, e.g. a JSON path.
+	delimiter rune           // Delimiter for CSV.
+	header    []string       // Latest CSV or table header.
+	name      string         // Name of the parser, e.g. PARSER_JSON.
+	path      []string       // Keys and indexes of a JSON path.
+	pattern   *regexp.Regexp // Regular expression of the regex parser.
+}
+
+// Row of tabular output, keyed by one of its columns.
+type ParsedRow struct {
+	Key    string        // Key of the row.
+	Line   string        // Raw line of the row.
+	Values []interface{} // Values of the row, without its key.
+}
+
+// Creates a parser from a string of the form '<parser>[:<argument>]'. An empty string is the
+// default parser.
+func NewParser(spec string) (*Parser, error) {
+	var (
+		err error // General error holder.
+	)
+
+	name, argument, _ := strings.Cut(spec, PARSER_SEP)
+	parser := &Parser{argument: argument, name: name}
+	if name == "" {
+		parser.name = Parsers[0]
+	}
+
+	switch parser.name {
+	case PARSER_CSV:
+		parser.delimiter = ','
+		if argument != "" {
+			if argument, err = strconv.Unquote(`"` + argument + `"`); err != nil ||
+				utf8.RuneCountInString(argument) != 1 {
+				return nil, fmt.Errorf("Bad CSV delimiter %q, expected a single character", parser.argument)
+			}
+			parser.delimiter, _ = utf8.DecodeRuneInString(argument)
+		}
+	case PARSER_DELIMITER:
+		if parser.argument, err = strconv.Unquote(`"` + argument + `"`); err != nil || argument == "" {
+			return nil, fmt.Errorf("Bad delimiter %q, expected '%s%s<delimiter>'",
+				argument, PARSER_DELIMITER, PARSER_SEP)
+		}
+	case PARSER_JSON:
+		path := strings.NewReplacer("[", ".", "]", "").Replace(argument)
+		for _, key := range strings.Split(path, ".") {
+			if key != "" {
+				parser.path = append(parser.path, key)
+			}
+		}
+	case PARSER_REGEX:
+		if parser.pattern, err = regexp.Compile(argument); err != nil {
+			return nil, fmt.Errorf("Bad regular expression %q: %v", argument, err)
+		}
+		if parser.pattern.NumSubexp() == 0 {
+			return nil, fmt.Errorf("Bad regular expression %q, expected capture groups", argument)
+		}
+	case PARSER_LOGFMT, PARSER_TABLE, PARSER_WHITESPACE:
+	default:
+		return nil, fmt.Errorf("Unknown parser %q, expected one of: %s",
+			parser.name, strings.Join(Parsers, ", "))
+	}
+
+	return parser, nil
+}
+
+// Parses output with a header, using the latest header if there isn't one.
+func (p *Parser) parseCSV(output string) (values []interface{}, labels []string, err error) {
+	csvReader := csv.NewReader(strings.NewReader(output))
+	csvReader.Comma = (*p).delimiter
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case len(records) == 0:
+		return nil, nil, fmt.Errorf("Expected a CSV record")
+	case len(records) > 1:
+		(*p).header = records[0]
+		records = records[1:]
+	case (*p).header == nil || slices.Equal(records[0], (*p).header):
+		// This is a header on its own, which may be repeated.
+		(*p).header = records[0]
+		return nil, nil, errParseHeader
+	}
+	if len(records[0]) != len((*p).header) {
+		return nil, nil, fmt.Errorf("Expected %d CSV fields, got %d", len((*p).header), len(records[0]))
+	}
+
+	for _, field := range records[0] {
+		values = append(values, tokenizeValue(field))
+	}
+
+	return values, slices.Clone((*p).header), nil
+}
+
+// Parses output split on a delimiter.
+func (p *Parser) parseDelimiter(output string) (values []interface{}) {
+	for _, field := range strings.Split(output, (*p).argument) {
+		values = append(values, tokenizeValue(strings.TrimSpace(field)))
+	}
+
+	return
+}
+
+// Parses JSON output, selecting part of it by a path.
+func (p *Parser) parseJSON(output string) (values []interface{}, labels []string, err error) {
+	var (
+		selected interface{} // Selected part of the output.
+	)
+
+	decoder := json.NewDecoder(strings.NewReader(output))
+	decoder.UseNumber()
+	if err = decoder.Decode(&selected); err != nil {
+		return nil, nil, err
+	}
+
+	for _, key := range (*p).path {
+		switch current := selected.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if selected, ok = current[key]; !ok {
+				return nil, nil, fmt.Errorf("Key %q not found in JSON", key)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(current) {
+				return nil, nil, fmt.Errorf("Index %q not found in JSON", key)
+			}
+			selected = current[i]
+		default:
+			return nil, nil, fmt.Errorf("Key %q not found in JSON", key)
+		}
+	}
+
+	switch selected := selected.(type) {
+	case map[string]interface{}:
+		for key := range selected {
+			labels = append(labels, key)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			values = append(values, parseJSONValue(selected[label]))
+		}
+	case []interface{}:
+		for _, value := range selected {
+			values = append(values, parseJSONValue(value))
+		}
+	default:
+		values = []interface{}{parseJSONValue(selected)}
+		if len((*p).path) > 0 {
+			labels = []string{(*p).path[len((*p).path)-1]}
+		}
+	}
+
+	return
+}
+
+// Parses logfmt output.
+func (p *Parser) parseLogfmt(output string) (values []interface{}, labels []string, err error) {
+	for rest := strings.TrimSpace(output); rest != ""; rest = strings.TrimLeftFunc(rest, unicode.IsSpace) {
+		var value interface{} // Value of the pair.
+
+		// Read the key.
+		end := strings.IndexFunc(rest, func(r rune) bool { return r == '=' || unicode.IsSpace(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		key := rest[:end]
+		if key == "" {
+			return nil, nil, fmt.Errorf("Expected a logfmt key: %s", rest)
+		}
+		rest = rest[end:]
+
+		// Read the value, if there is one.
+		switch {
+		case !strings.HasPrefix(rest, "="):
+			value = true
+		case strings.HasPrefix(rest, `="`):
+			quoted, err := strconv.QuotedPrefix(rest[1:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("Bad logfmt value for %q: %v", key, err)
+			}
+			unquoted, _ := strconv.Unquote(quoted)
+			value = tokenizeValue(unquoted)
+			rest = rest[1+len(quoted):]
+		default:
+			end = strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			value = tokenizeValue(rest[1:end])
+			rest = rest[end:]
+		}
+
+		labels = append(labels, key)
+		values = append(values, value)
+	}
+
+	return
+}
+
+// Parses output matched by a regular expression.
+func (p *Parser) parseRegex(output string) (values []interface{}, labels []string, err error) {
+	matches := (*p).pattern.FindStringSubmatch(output)
+	if matches == nil {
+		return nil, nil, fmt.Errorf("Output doesn't match %q", (*p).pattern)
+	}
+
+	// Unnamed groups are labelled by their position, like default labels.
+	for i, name := range (*p).pattern.SubexpNames()[1:] {
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		labels = append(labels, name)
+		values = append(values, tokenizeValue(matches[i+1]))
+	}
+
+	return
+}
+
+// Joins columns past a number of columns into the last of them, copying them if they're joined.
+func joinColumns(columns []string, n int) []string {
+	if n <= 0 || len(columns) <= n {
+		return columns
+	}
+
+	return append(slices.Clone(columns[:n-1]), strings.Join(columns[n-1:], " "))
+}
+
+// Parses tabular output with a header into rows, using the latest header if there isn't one.
+func (p *Parser) parseTable(output string) (rows []ParsedRow, labels []string, err error) {
+	var (
+		key   = 0      // Index of the column keying rows.
+		lines []string // Lines of output, without empty ones.
+	)
+
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	switch {
+	case len(lines) == 0:
+		return nil, nil, fmt.Errorf("Expected a table row")
+	case len(lines) > 1:
+		(*p).header = strings.Fields(lines[0])
+		lines = lines[1:]
+	case (*p).header == nil || strings.Join(strings.Fields(lines[0]), " ") ==
+		strings.Join((*p).header, " "):
+		// This is a header on its own, which may be repeated.
+		(*p).header = strings.Fields(lines[0])
+		return nil, nil, errParseHeader
+	}
+
+	// Join extra columns of the header into its last column for the widest row, leaving the latest
+	// header as it was for later output.
+	width := 0
+	for _, line := range lines {
+		width = max(width, len(strings.Fields(line)))
+	}
+	header := joinColumns((*p).header, width)
+	if (*p).argument != "" {
+		if key = slices.Index(header, (*p).argument); key < 0 {
+			return nil, nil, fmt.Errorf("Key column %q not found in table header", (*p).argument)
+		}
+	}
+
+	for _, line := range lines {
+		// Join extra columns of the row into its last column.
+		fields := joinColumns(strings.Fields(line), len(header))
+		if key >= len(fields) {
+			return nil, nil, fmt.Errorf("Expected a key column in table row: %s", line)
+		}
+
+		row := ParsedRow{Key: fields[key], Line: line}
+		for i, field := range fields {
+			if i != key {
+				row.Values = append(row.Values, tokenizeValue(field))
+			}
+		}
+		rows = append(rows, row)
+	}
+	labels = append(slices.Clone(header[:key]), header[key+1:]...)
+
+	return rows, labels, nil
+}
+
+// Determines whether the parser parses output into rows, each being a result.
+func (p *Parser) IsTable() bool {
+	return (*p).name == PARSER_TABLE
+}
+
+// Parses query output into values, along with their labels if the output names them.
+func (p *Parser) Parse(output string) (values []interface{}, labels []string, err error) {
+	switch (*p).name {
+	case PARSER_CSV:
+		return p.parseCSV(output)
+	case PARSER_DELIMITER:
+		return p.parseDelimiter(output), nil, nil
+	case PARSER_JSON:
+		return p.parseJSON(output)
+	case PARSER_LOGFMT:
+		return p.parseLogfmt(output)
+	case PARSER_REGEX:
+		return p.parseRegex(output)
+	}
+
+	return TokenizeResult(output), nil, nil
+}
+
+// Parses tabular query output into rows, along with the labels of their values. Only parsers that are
+// tables may parse rows.
+func (p *Parser) ParseTable(output string) (rows []ParsedRow, labels []string, err error) {
+	if !p.IsTable() {
+		return nil, nil, fmt.Errorf("Parser %q doesn't parse tables", (*p).name)
+	}
+
+	return p.parseTable(output)
+}
+
+// Parses a decoded JSON value. Numbers become integers or floats, and anything other than a value,
+// e.g. a nested object, is kept as JSON.
+func parseJSONValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case bool, nil, string:
+		return value
+	case json.Number:
+		return tokenizeValue(value.String())
+	}
+
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewParser(t *testing.T) {
+	// It accepts supported parsers and their arguments.
+	for _, spec := range []string{
+		"", "whitespace", "delimiter:,", `delimiter:\t`, "json", "json:.data.items[0]", "logfmt", "csv",
+		"csv:;", `regex:(?P<user>\w+) from (\S+)`,
+	} {
+		if _, err := NewParser(spec); err != nil {
+			t.Errorf("Got: %v Expected: %v (%s)\n", err, nil, spec)
+		}
+	}
+
+	// It rejects unknown parsers and bad arguments.
+	for _, spec := range []string{"foo", "delimiter", "csv:;;", "regex:(", `regex:\w+`} {
+		if _, err := NewParser(spec); err == nil {
+			t.Errorf("Got: %v Expected: %v (%s)\n", err, "error", spec)
+		}
+	}
+}
+
+func TestParserParse(t *testing.T) {
+	for _, test := range []struct {
+		spec, output   string
+		expectedValues []interface{}
+		expectedLabels []string
+	}{
+		{"", "foo 1 2.5", []interface{}{"foo", int64(1), 2.5}, nil},
+		{"delimiter:,", "foo bar, 1", []interface{}{"foo bar", int64(1)}, nil},
+		{
+			"json", `{"status": "ok", "count": 3, "nested": {"a": 1}}`,
+			[]interface{}{int64(3), `{"a":1}`, "ok"}, []string{"count", "nested", "status"},
+		},
+		{
+			"json:.data.items[1]", `{"data": {"items": [1, 2.5]}}`,
+			[]interface{}{2.5}, []string{"1"},
+		},
+		{"json:.items", `{"items": [true, null]}`, []interface{}{true, nil}, nil},
+		{
+			"logfmt", `level=info msg="hello there" took=1.5 done`,
+			[]interface{}{"info", "hello there", 1.5, true},
+			[]string{"level", "msg", "took", "done"},
+		},
+		{"csv", "name,count\nfoo,3", []interface{}{"foo", int64(3)}, []string{"name", "count"}},
+		{
+			`regex:(?P<user>\w+) logged in from (\S+)`, "alice logged in from 10.0.0.1",
+			[]interface{}{"alice", "10.0.0.1"}, []string{"user", "1"},
+		},
+	} {
+		parser, err := NewParser(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// It parses values and any labels.
+		values, labels, err := parser.Parse(test.output)
+		if err != nil {
+			t.Errorf("Got: %v Expected: %v (%s)\n", err, nil, test.spec)
+		}
+		if !reflect.DeepEqual(values, test.expectedValues) {
+			t.Errorf("Got: %#v Expected: %#v (%s)\n", values, test.expectedValues, test.spec)
+		}
+		if !reflect.DeepEqual(labels, test.expectedLabels) {
+			t.Errorf("Got: %v Expected: %v (%s)\n", labels, test.expectedLabels, test.spec)
+		}
+	}
+
+	// It rejects output that doesn't parse.
+	for spec, output := range map[string]string{
+		"json":          "foo",
+		"json:.missing": `{"foo": 1}`,
+		"logfmt":        `msg="unterminated`,
+		"regex:(\\d+)":  "foo",
+	} {
+		parser, _ := NewParser(spec)
+		if _, _, err := parser.Parse(output); err == nil {
+			t.Errorf("Got: %v Expected: %v (%s)\n", err, "error", spec)
+		}
+	}
+}
+
+func TestParserParseCSVHeader(t *testing.T) {
+	parser, _ := NewParser("csv")
+
+	// It keeps a header provided on its own.
+	if _, _, err := parser.Parse("name,count"); !errors.Is(err, errParseHeader) {
+		t.Errorf("Got: %v Expected: %v\n", err, errParseHeader)
+	}
+
+	// It uses the header for later records.
+	values, labels, err := parser.Parse("foo,3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []interface{}{"foo", int64(3)}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", values, expected)
+	}
+	if expected := []string{"name", "count"}; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Got: %v Expected: %v\n", labels, expected)
+	}
+
+	// It rejects records that don't match the header.
+	if _, _, err := parser.Parse("foo,3,bar"); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+}
+
+func TestParserParseTable(t *testing.T) {
+	parser, _ := NewParser("table:Mounted on")
+
+	// It parses rows keyed by a column, joining extra header columns.
+	rows, labels, err := parser.ParseTable(`Filesystem Size Used Use% Mounted on
+/dev/sda1 100G 40G 40% /
+/dev/sda2 200G 20G 10% /home`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRows := []ParsedRow{
+		{
+			Key:    "/",
+			Line:   "/dev/sda1 100G 40G 40% /",
+			Values: []interface{}{"/dev/sda1", "100G", "40G", "40%"},
+		},
+		{
+			Key:    "/home",
+			Line:   "/dev/sda2 200G 20G 10% /home",
+			Values: []interface{}{"/dev/sda2", "200G", "20G", "10%"},
+		},
+	}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v Expected: %v\n", rows, expectedRows)
+	}
+	expectedLabels := []string{"Filesystem", "Size", "Used", "Use%"}
+	if !reflect.DeepEqual(labels, expectedLabels) {
+		t.Errorf("Got: %v Expected: %v\n", labels, expectedLabels)
+	}
+
+	// It joins extra row columns, keying by the first column by default.
+	parser, _ = NewParser("table")
+	rows, labels, err = parser.ParseTable("PID CPU COMMAND\n1 0.5 init --splash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRows = []ParsedRow{
+		{Key: "1", Line: "1 0.5 init --splash", Values: []interface{}{0.5, "init --splash"}},
+	}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v Expected: %v\n", rows, expectedRows)
+	}
+	if expected := []string{"CPU", "COMMAND"}; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Got: %v Expected: %v\n", labels, expected)
+	}
+
+	// It keeps a header provided on its own.
+	if _, _, err := parser.ParseTable("PID CPU COMMAND"); !errors.Is(err, errParseHeader) {
+		t.Errorf("Got: %v Expected: %v\n", err, errParseHeader)
+	}
+	if rows, _, _ = parser.ParseTable("2 1.5 bash"); len(rows) != 1 || rows[0].Key != "2" {
+		t.Errorf("Got: %v Expected: %v\n", rows, "a row keyed by 2")
+	}
+
+	// It leaves the header as it was after a row with fewer columns.
+	if _, labels, _ = parser.ParseTable("3 bash"); !reflect.DeepEqual(labels, []string{"CPU COMMAND"}) {
+		t.Errorf("Got: %v Expected: %v\n", labels, []string{"CPU COMMAND"})
+	}
+	if _, labels, _ = parser.ParseTable("4 0.5 sh"); !reflect.DeepEqual(labels, []string{"CPU", "COMMAND"}) {
+		t.Errorf("Got: %v Expected: %v\n", labels, []string{"CPU", "COMMAND"})
+	}
+
+	// It rejects missing key columns and parsers that aren't tables.
+	parser, _ = NewParser("table:foo")
+	if _, _, err := parser.ParseTable("PID CPU\n1 0.5"); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+	parser, _ = NewParser("json")
+	if _, _, err := parser.ParseTable("PID CPU\n1 0.5"); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+}
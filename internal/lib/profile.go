@@ -1,20 +1,46 @@
 //
 // Logic for 'profile' mode.
+//
+// Profiles are either of a process, given by its PID, or of the system, given as PROFILE_SYSTEM.
+// Process profiles read state, CPU usage, memory, and IO. System profiles read CPU usage per state
+// and per core, memory, load, and disk and network rates. Usage and rates are over the time since
+// the previous profile, so the first profile takes an extra sample to compare against.
 
 package lib
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/procfs"
+	"github.com/prometheus/procfs/blockdevice"
+)
+
+const (
+	PROFILE_SYSTEM = "system" // Query for profiling the system, rather than a process.
+
+	PROFILE_PRIMER       = 250 * time.Millisecond // Time between samples of first profiles.
+	PROFILE_SECTOR_BYTES = 512                    // Size of sectors in /proc/diskstats.
 )
 
 var (
+	processSampleMutex sync.Mutex                    // Guards previous process samples.
+	processSamplesPrev = make(map[int]processSample) // Previous process samples, keyed by PID.
+
+	systemSampleMutex sync.Mutex    // Guards the previous system sample.
+	systemSamplePrev  *systemSample // Previous system sample, for usage and rates between samples.
+
+	// Prefixes of block devices left out of system profiles, which aren't disks or are counted by
+	// the disks beneath them.
+	systemSkipDevices = []string{"dm-", "loop", "md", "ram", "zram"}
+	// Prefixes of network interfaces left out of system profiles, which don't leave the host.
+	systemSkipInterfaces = []string{"lo"}
+
 	processState = map[string]string{
 		"D": "uninterruptable sleep",
 		"I": "idle",
@@ -35,9 +61,30 @@ var (
 		"Swap (GB)",
 		"IO Read (MB)",
 		"IO Write (MB)",
+		"IO Read (MB/s)",
+		"IO Write (MB/s)",
 	} // Labels supplied for profile results.
 )
 
+// Sample of process statistics at a point in time.
+type processSample struct {
+	cpuTime    float64   // CPU time of the process, in seconds.
+	readBytes  uint64    // Bytes read by the process.
+	startTime  float64   // Start time of the process, for noticing reused PIDs.
+	time       time.Time // Time of the sample.
+	writeBytes uint64    // Bytes written by the process.
+}
+
+// Sample of system statistics at a point in time.
+type systemSample struct {
+	diskstats []blockdevice.Diskstats // Reads /proc/diskstats.
+	loadAvg   procfs.LoadAvg          // Reads /proc/loadavg.
+	meminfo   procfs.Meminfo          // Reads /proc/meminfo.
+	netDev    procfs.NetDev           // Reads /proc/net/dev.
+	stat      procfs.Stat             // Reads /proc/stat.
+	time      time.Time               // Time of the sample.
+}
+
 // Converts a byte count (commonly given by /proc) to some higher delinitation.
 func byteConv(bytes int, level string) (convBytes float64, err error) {
 	var (
@@ -62,37 +109,98 @@ func byteConv(bytes int, level string) (convBytes float64, err error) {
 	return
 }
 
-// Executes a pprof on a specific process, isolating specific data.
-func runProfile(pid int) string {
-	var (
-		uptime float64 // Total system uptime.
-	)
+// Reads process statistics.
+func readProcessSample(proc procfs.Proc) (procStat procfs.ProcStat, sample processSample) {
+	sample.time = time.Now()
+	procIO, err := proc.IO() // Reads /proc/[pid]/io.
+	e(err)
+	procStat, err = proc.Stat() // Read /proc/[pid]/stat.
+	e(err)
+	startTime, err := procStat.StartTime()
+	e(err)
+
+	sample.cpuTime, sample.startTime = procStat.CPUTime(), startTime
+	sample.readBytes, sample.writeBytes = procIO.ReadBytes, procIO.WriteBytes
+
+	return
+}
+
+// Profiles a process between two samples, producing CPU usage as a percentage of the time between
+// samples and IO read and write rates in bytes per second.
+func processProfile(prev, next processSample) (cpu, read, write float64) {
+	elapsed := next.time.Sub(prev.time)
+	if elapsed <= 0 {
+		return
+	}
+
+	return max(next.cpuTime-prev.cpuTime, 0) / elapsed.Seconds() * 100,
+		rate(prev.readBytes, next.readBytes, elapsed),
+		rate(prev.writeBytes, next.writeBytes, elapsed)
+}
 
+// Checks whether a process is running.
+func isProcessRunning(pid int) bool {
+	_, err := procfs.NewProc(pid)
+
+	return err == nil
+}
+
+// Removes previous samples of processes that are no longer running, so that they don't accumulate
+// over time. Expects previous process samples to be locked.
+func pruneProcessSamples(isRunning func(pid int) bool) {
+	for pid := range processSamplesPrev {
+		if !isRunning(pid) {
+			delete(processSamplesPrev, pid)
+		}
+	}
+}
+
+// Removes the previous sample of a process once it's no longer profiled, e.g. when its query stops.
+func forgetProcessSample(query string) {
+	if pid, err := strconv.Atoi(query); err == nil {
+		processSampleMutex.Lock()
+		delete(processSamplesPrev, pid)
+		processSampleMutex.Unlock()
+	}
+}
+
+// Executes a pprof on a specific process, isolating specific data. Usage and rates are since the
+// previous profile of the process, or for the first profile, over a short time waited for here.
+func runProfile(ctx context.Context, pid int) string {
 	// Read /proc/[pid] data.
 	proc, err := procfs.NewProc(pid)
 	e(err)
 	procSmap, err := proc.ProcSMapsRollup() // Reads /proc/[pid]/smaps_rollup.
 	e(err)
-	procIO, err := proc.IO() // Reads /proc/[pid]/io.
-	e(err)
-	procStat, err := proc.Stat() // Read /proc/[pid]/stat.
-	e(err)
 
-	// Calculate CPU usage.
-	startTime, err := procStat.StartTime()
-	e(err)
-	procUptime, err := os.Open("/proc/uptime")
-	e(err)
-	defer procUptime.Close()
-	scanner := bufio.NewScanner(procUptime)
-	for scanner.Scan() {
-		uptime, err = strconv.ParseFloat(strings.Split(scanner.Text(), " ")[0], 10)
+	// Compare against the previous sample, or take one if there isn't one for this process, such as
+	// when its PID has been reused.
+	procStat, next := readProcessSample(proc)
+	processSampleMutex.Lock()
+	prev, ok := processSamplesPrev[pid]
+	processSampleMutex.Unlock()
+	if !ok || prev.startTime != next.startTime {
+		select {
+		case <-ctx.Done():
+		case <-time.After(PROFILE_PRIMER):
+		}
+		prev = next
+		procStat, next = readProcessSample(proc)
 	}
+	processSampleMutex.Lock()
+	processSamplesPrev[pid] = next
+	pruneProcessSamples(isProcessRunning)
+	processSampleMutex.Unlock()
 
-	// Calculate IO usage.
-	read, err := byteConv(int(procIO.ReadBytes), "megabyte")
+	// Calculate CPU and IO usage.
+	cpu, readRate, writeRate := processProfile(prev, next)
+	read, err := byteConv(int(next.readBytes), "megabyte")
+	e(err)
+	write, err := byteConv(int(next.writeBytes), "megabyte")
 	e(err)
-	write, err := byteConv(int(procIO.WriteBytes), "megabyte")
+	readRate, err = byteConv(int(readRate), "megabyte")
+	e(err)
+	writeRate, err = byteConv(int(writeRate), "megabyte")
 	e(err)
 
 	// Calculate memory usage.
@@ -101,15 +209,239 @@ func runProfile(pid int) string {
 	swap, err := byteConv(int(procSmap.Swap), "gigabyte")
 
 	return fmt.Sprintf(
-		"%s %d %d %f %f %f %f %f %f",
+		"%s %d %d %f %f %f %f %f %f %f %f",
 		processState[procStat.State],
-		time.Now().Unix()-int64(startTime),
+		time.Now().Unix()-int64(next.startTime),
 		procStat.NumThreads,
-		(procStat.CPUTime()/uptime)*100,
+		cpu,
 		rss,
 		virt,
 		swap,
 		read,
 		write,
+		readRate,
+		writeRate,
 	)
 }
+
+// Sums the time a CPU spent in all states, in seconds. Guest time is already counted as user time.
+func cpuTotal(cpu procfs.CPUStat) float64 {
+	return cpu.User + cpu.Nice + cpu.System + cpu.Idle + cpu.Iowait + cpu.IRQ + cpu.SoftIRQ + cpu.Steal
+}
+
+// Retrieves a value of /proc/meminfo in bytes, where missing values are zero.
+func meminfoBytes(kilobytes *uint64) int {
+	if kilobytes == nil {
+		return 0
+	}
+
+	return int(*kilobytes) * 1024
+}
+
+// Calculates a rate per second of a counter between samples. Counters that reset are zero.
+func rate(prev, next uint64, elapsed time.Duration) float64 {
+	if next < prev || elapsed <= 0 {
+		return 0
+	}
+
+	return float64(next-prev) / elapsed.Seconds()
+}
+
+// Reads system statistics.
+func readSystemSample() (sample systemSample, err error) {
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		return
+	}
+	blockFS, err := blockdevice.NewDefaultFS()
+	if err != nil {
+		return
+	}
+
+	sample.time = time.Now()
+	if sample.stat, err = fs.Stat(); err != nil {
+		return
+	}
+	if sample.meminfo, err = fs.Meminfo(); err != nil {
+		return
+	}
+	loadAvg, err := fs.LoadAvg()
+	if err != nil {
+		return
+	}
+	sample.loadAvg = *loadAvg
+	if sample.diskstats, err = blockFS.ProcDiskstats(); err != nil {
+		return
+	}
+	sample.netDev, err = fs.NetDev()
+
+	return
+}
+
+// Checks whether a block device or network interface is left out of system profiles by its name.
+func isSystemSkipped(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks whether a block device is a partition of another, e.g. 'sda1' of 'sda' or 'nvme0n1p1' of
+// 'nvme0n1'.
+func isPartition(disk blockdevice.Diskstats, disks []blockdevice.Diskstats) bool {
+	for _, other := range disks {
+		number, ok := strings.CutPrefix(disk.DeviceName, other.DeviceName)
+		number = strings.TrimPrefix(number, "p")
+		if ok && number != "" && strings.Trim(number, "0123456789") == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Profiles the system between two samples, producing labels and their values. CPU usage is a
+// percentage of the time between samples, and disk and network rates are totals in megabytes per
+// second, so labels stay the same as disks and network interfaces come and go.
+func systemProfile(prev, next systemSample) (labels []string, values []float64) {
+	var (
+		elapsed = next.time.Sub(prev.time) // Time between samples.
+
+		// Adds a labelled value.
+		add = func(label string, value float64) {
+			labels = append(labels, label)
+			values = append(values, value)
+		}
+		// Adds a labelled byte count, converted to a higher delineation.
+		addBytes = func(label string, bytes float64, level string) {
+			convBytes, err := byteConv(int(bytes), level)
+			e(err)
+			add(label, convBytes)
+		}
+		// Calculates the percentage of CPU time spent in a state between samples.
+		cpuPercent = func(prevCPU, nextCPU procfs.CPUStat, state func(procfs.CPUStat) float64) float64 {
+			total := cpuTotal(nextCPU) - cpuTotal(prevCPU)
+			if total <= 0 {
+				return 0
+			}
+			return (state(nextCPU) - state(prevCPU)) / total * 100
+		}
+	)
+
+	// Calculate CPU usage, in total for each state and for each core.
+	for _, state := range []struct {
+		name  string
+		value func(procfs.CPUStat) float64
+	}{
+		{"User", func(c procfs.CPUStat) float64 { return c.User }},
+		{"Nice", func(c procfs.CPUStat) float64 { return c.Nice }},
+		{"System", func(c procfs.CPUStat) float64 { return c.System }},
+		{"Idle", func(c procfs.CPUStat) float64 { return c.Idle }},
+		{"IO Wait", func(c procfs.CPUStat) float64 { return c.Iowait }},
+		{"IRQ", func(c procfs.CPUStat) float64 { return c.IRQ }},
+		{"Soft IRQ", func(c procfs.CPUStat) float64 { return c.SoftIRQ }},
+		{"Steal", func(c procfs.CPUStat) float64 { return c.Steal }},
+	} {
+		add(fmt.Sprintf("CPU %s (%%)", state.name),
+			cpuPercent(prev.stat.CPUTotal, next.stat.CPUTotal, state.value))
+	}
+	cores := make([]int64, 0, len(next.stat.CPU))
+	for core := range next.stat.CPU {
+		cores = append(cores, core)
+	}
+	sort.Slice(cores, func(i, j int) bool { return cores[i] < cores[j] })
+	for _, core := range cores {
+		// Usage is time spent in any state but idle or waiting on IO.
+		idle := cpuPercent(prev.stat.CPU[core], next.stat.CPU[core],
+			func(c procfs.CPUStat) float64 { return c.Idle + c.Iowait })
+		add(fmt.Sprintf("CPU%d Usage (%%)", core), 100-idle)
+	}
+
+	// Calculate memory usage.
+	memTotal := meminfoBytes(next.meminfo.MemTotal)
+	memAvailable := meminfoBytes(next.meminfo.MemAvailable)
+	swapTotal, swapFree := meminfoBytes(next.meminfo.SwapTotal), meminfoBytes(next.meminfo.SwapFree)
+	addBytes("Memory Total (GB)", float64(memTotal), "gigabyte")
+	addBytes("Memory Used (GB)", float64(memTotal-memAvailable), "gigabyte")
+	addBytes("Memory Available (GB)", float64(memAvailable), "gigabyte")
+	addBytes("Memory Buffers (GB)", float64(meminfoBytes(next.meminfo.Buffers)), "gigabyte")
+	addBytes("Memory Cached (GB)", float64(meminfoBytes(next.meminfo.Cached)), "gigabyte")
+	addBytes("Swap Total (GB)", float64(swapTotal), "gigabyte")
+	addBytes("Swap Used (GB)", float64(swapTotal-swapFree), "gigabyte")
+
+	// Calculate load.
+	add("Load (1m)", next.loadAvg.Load1)
+	add("Load (5m)", next.loadAvg.Load5)
+	add("Load (15m)", next.loadAvg.Load15)
+
+	// Calculate disk rates in total, for disks in both samples. Partitions are left out, since they
+	// are counted by their disks.
+	prevDisks := make(map[string]blockdevice.IOStats, len(prev.diskstats))
+	for _, disk := range prev.diskstats {
+		prevDisks[disk.DeviceName] = disk.IOStats
+	}
+	var diskRead, diskWrite float64
+	for _, disk := range next.diskstats {
+		prevDisk, ok := prevDisks[disk.DeviceName]
+		if !ok || isSystemSkipped(disk.DeviceName, systemSkipDevices) || isPartition(disk, next.diskstats) {
+			continue
+		}
+		diskRead += rate(prevDisk.ReadSectors, disk.ReadSectors, elapsed) * PROFILE_SECTOR_BYTES
+		diskWrite += rate(prevDisk.WriteSectors, disk.WriteSectors, elapsed) * PROFILE_SECTOR_BYTES
+	}
+	addBytes("Disk Read (MB/s)", diskRead, "megabyte")
+	addBytes("Disk Write (MB/s)", diskWrite, "megabyte")
+
+	// Calculate network rates in total, for interfaces in both samples.
+	var netReceive, netTransmit float64
+	for name, nextNet := range next.netDev {
+		prevNet, ok := prev.netDev[name]
+		if !ok || isSystemSkipped(name, systemSkipInterfaces) {
+			continue
+		}
+		netReceive += rate(prevNet.RxBytes, nextNet.RxBytes, elapsed)
+		netTransmit += rate(prevNet.TxBytes, nextNet.TxBytes, elapsed)
+	}
+	addBytes("Network Receive (MB/s)", netReceive, "megabyte")
+	addBytes("Network Transmit (MB/s)", netTransmit, "megabyte")
+
+	return
+}
+
+// Profiles the system, producing labels and their values. Usage and rates are since the previous
+// profile, or for the first profile, over a short time waited for here.
+func runSystemProfile(ctx context.Context) (labels []string, result string, err error) {
+	systemSampleMutex.Lock()
+	defer systemSampleMutex.Unlock()
+
+	if systemSamplePrev == nil {
+		prev, err := readSystemSample()
+		if err != nil {
+			return nil, "", err
+		}
+		systemSamplePrev = &prev
+
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-time.After(PROFILE_PRIMER):
+		}
+	}
+
+	next, err := readSystemSample()
+	if err != nil {
+		return
+	}
+	labels, values := systemProfile(*systemSamplePrev, next)
+	systemSamplePrev = &next
+
+	formattedValues := make([]string, len(values))
+	for i, value := range values {
+		formattedValues[i] = fmt.Sprintf("%f", value)
+	}
+
+	return labels, strings.Join(formattedValues, " "), nil
+}
@@ -1,6 +1,13 @@
 package lib
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/procfs"
+	"github.com/prometheus/procfs/blockdevice"
+)
 
 func TestByteConv(t *testing.T) {
 	expected := 1.23
@@ -19,3 +26,108 @@ func TestByteConv(t *testing.T) {
 		t.Errorf("Got: %v Expected %v\n", got, expected)
 	}
 }
+
+func TestSystemProfile(t *testing.T) {
+	var (
+		memTotal, memAvailable = uint64(4000000), uint64(1000000)
+
+		prevTime = time.Now()
+	)
+
+	prev := systemSample{
+		diskstats: []blockdevice.Diskstats{
+			{Info: blockdevice.Info{DeviceName: "sda"}, IOStats: blockdevice.IOStats{ReadSectors: 0}},
+			{Info: blockdevice.Info{DeviceName: "sda1"}, IOStats: blockdevice.IOStats{ReadSectors: 0}},
+			{Info: blockdevice.Info{DeviceName: "loop0"}, IOStats: blockdevice.IOStats{ReadSectors: 0}},
+		},
+		netDev: procfs.NetDev{
+			"eth0": {Name: "eth0", RxBytes: 1000000},
+			"lo":   {Name: "lo", RxBytes: 1000000},
+		},
+		stat: procfs.Stat{
+			CPUTotal: procfs.CPUStat{User: 10, Idle: 10},
+			CPU:      map[int64]procfs.CPUStat{0: {User: 10, Idle: 10}},
+		},
+		time: prevTime,
+	}
+	next := systemSample{
+		diskstats: []blockdevice.Diskstats{
+			{Info: blockdevice.Info{DeviceName: "sda"}, IOStats: blockdevice.IOStats{ReadSectors: 4000}},
+			{Info: blockdevice.Info{DeviceName: "sda1"}, IOStats: blockdevice.IOStats{ReadSectors: 4000}},
+			{Info: blockdevice.Info{DeviceName: "loop0"}, IOStats: blockdevice.IOStats{ReadSectors: 4000}},
+			{Info: blockdevice.Info{DeviceName: "sdb"}, IOStats: blockdevice.IOStats{ReadSectors: 4000}},
+		},
+		loadAvg: procfs.LoadAvg{Load1: 1.5},
+		meminfo: procfs.Meminfo{MemTotal: &memTotal, MemAvailable: &memAvailable},
+		netDev: procfs.NetDev{
+			"eth0": {Name: "eth0", RxBytes: 3000000},
+			"lo":   {Name: "lo", RxBytes: 3000000},
+		},
+		stat: procfs.Stat{
+			CPUTotal: procfs.CPUStat{User: 13, Idle: 11},
+			CPU:      map[int64]procfs.CPUStat{0: {User: 13, Idle: 11}},
+		},
+		time: prevTime.Add(2 * time.Second),
+	}
+
+	labels, values := systemProfile(prev, next)
+	got := make(map[string]float64, len(labels))
+	for i, label := range labels {
+		got[label] = values[i]
+	}
+
+	// It calculates usage and rates between samples, totalling disks and network interfaces in both
+	// samples and leaving out partitions, devices that aren't disks, and loopback.
+	for label, expected := range map[string]float64{
+		"CPU User (%)":           75,
+		"CPU Idle (%)":           25,
+		"CPU0 Usage (%)":         75,
+		"Memory Used (GB)":       3.072,
+		"Load (1m)":              1.5,
+		"Disk Read (MB/s)":       1.024,
+		"Network Receive (MB/s)": 1,
+	} {
+		if got[label] != expected {
+			t.Errorf("Got: %v Expected: %v (%s)\n", got[label], expected, label)
+		}
+	}
+
+	// It has the same labels as disks and network interfaces come and go.
+	next.diskstats = next.diskstats[:1]
+	delete(next.netDev, "eth0")
+	if gotLabels, _ := systemProfile(prev, next); !reflect.DeepEqual(gotLabels, labels) {
+		t.Errorf("Got: %v Expected: %v\n", gotLabels, labels)
+	}
+}
+
+func TestProcessProfile(t *testing.T) {
+	prevTime := time.Now()
+	prev := processSample{cpuTime: 10, readBytes: 1000, time: prevTime, writeBytes: 5000}
+	next := processSample{
+		cpuTime: 11, readBytes: 5000, time: prevTime.Add(4 * time.Second), writeBytes: 1000,
+	}
+
+	// It calculates CPU usage and IO rates between samples, where reset counters are zero.
+	cpu, read, write := processProfile(prev, next)
+	if cpu != 25 || read != 1000 || write != 0 {
+		t.Errorf("Got: %v %v %v Expected: %v %v %v\n", cpu, read, write, 25, 1000, 0)
+	}
+}
+
+func TestPruneProcessSamples(t *testing.T) {
+	processSamplesPrev = map[int]processSample{1: {}, 2: {}, 3: {}}
+	defer func() { processSamplesPrev = make(map[int]processSample) }()
+
+	// It removes samples of processes that are no longer running.
+	pruneProcessSamples(func(pid int) bool { return pid != 2 })
+	if _, ok := processSamplesPrev[2]; ok || len(processSamplesPrev) != 2 {
+		t.Errorf("Got: %v Expected: %v\n", processSamplesPrev, "samples of 1 and 3")
+	}
+
+	// It removes samples of processes that are no longer profiled.
+	forgetProcessSample("3")
+	forgetProcessSample(PROFILE_SYSTEM)
+	if _, ok := processSamplesPrev[1]; !ok || len(processSamplesPrev) != 1 {
+		t.Errorf("Got: %v Expected: %v\n", processSamplesPrev, "a sample of 1")
+	}
+}
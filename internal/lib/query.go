@@ -4,40 +4,88 @@
 package lib
 
 import (
-	"io"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"log/slog"
 	"os/exec"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
 )
 
 const (
 	QUERY_MODE_COMMAND int = iota + 1 // Queries are commands.
 	QUERY_MODE_PROFILE                // Queries are PIDs to profile.
+	QUERY_MODE_STREAM                 // Queries are long-running commands producing many results.
+)
+
+const (
+	QUERY_STREAM_BACKOFF_MAX = time.Minute // Maximum delay before restarting a streaming query.
+	QUERY_STREAM_BACKOFF_MIN = time.Second // Minimum delay before restarting a streaming query.
+	QUERY_STREAM_RECORD_INIT = 64 * 1024   // Initial buffer size for streamed records, in bytes.
+	QUERY_STREAM_RECORD_MAX  = 1024 * 1024 // Maximum size of a streamed record, in bytes.
+
+	// Time to wait for a killed query's output pipes to close before abandoning them. This accounts
+	// for processes that escape the query's process group but still hold its output open.
+	QUERY_WAIT_DELAY = time.Second
+)
+
+var (
+	queriesCancel    context.CancelFunc = func() {} // Cancels all running queries.
+	queriesWaitGroup sync.WaitGroup                 // Tracks running queries.
+	queryLabelled    map[string]bool                // Whether or not queries have their own labels.
+	queryParsers     map[string]*Parser             // Parsers of query output, per query.
+	queryUnits       map[string]bool                // Whether or not queries parse values with units.
 )
 
 // Wrapper for query execution.
 func runQuery(
+	ctx context.Context,
 	query string,
-	attempts, delay int,
+	attempts, delay, timeout int,
 	history bool,
 	doneChan, pauseChan chan bool,
-	queryFunc func(string, bool),
+	queryFunc func(context.Context, string, bool),
 ) {
+	defer queriesWaitGroup.Done()
+
 	// This loop executes as long as attempts has not been reached, or indefinitely if attempts is
 	// less than zero.
 	for i := 0; attempts < 0 || i < attempts; i++ {
 		select {
+		case <-ctx.Done():
+			// Queries have been cancelled.
+			doneChan <- true
+			return
 		case <-pauseChan:
 			// Manage pausing. If we receive from the pause channel, wait for another message from the
 			// pause channel.
-			<-pauseChan
+			select {
+			case <-ctx.Done():
+			case <-pauseChan:
+			}
 		default:
-			queryFunc(query, history)
+			// Apply any timeout to this execution of the query.
+			queryCtx, queryCancel := context.WithCancel(ctx)
+			if timeout > 0 {
+				queryCtx, queryCancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+			}
+			queryFunc(queryCtx, query, history)
+			queryCancel()
 
 			// This is not the last execution--add a delay.
 			if i != attempts {
-				time.Sleep(time.Duration(delay) * time.Second)
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Duration(delay) * time.Second):
+				}
 			}
 		}
 	}
@@ -46,56 +94,283 @@ func runQuery(
 }
 
 // Executes a query as a command to exec.
-func runQueryExec(query string, history bool) {
-	slog.Debug("Executing query", "query", query)
+func runQueryExec(ctx context.Context, query string, history bool) {
+	var (
+		stderr, stdout bytes.Buffer // Command output.
+
+		exitCode = -1 // Exit code of the command, if it exits at all.
+	)
 
-	// Prepare query execution.
-	cmd := exec.Command("bash", "-c", query)
+	slog.Debug("Executing query", "query", query)
+	start := time.Now()
 
-	// Set-up pipes for command output.
-	stdout, stdout_err := cmd.StdoutPipe()
-	stderr, stderr_err := cmd.StderrPipe()
-	e(stdout_err)
-	e(stderr_err)
+	// Prepare query execution. Queries run in their own process group so that cancellation reaches
+	// anything the shell has started, not just the shell itself.
+	cmd := exec.CommandContext(ctx, "bash", "-c", query)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = QUERY_WAIT_DELAY
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
 
 	// Execute the query.
-	cmd_err := cmd.Start()
-	e(cmd_err)
+	cmd_err := cmd.Run()
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	result := storage.Result{
+		Duration: time.Since(start),
+		ExitCode: exitCode,
+		Stderr:   strings.TrimSpace(stderr.String()),
+		Value:    stdout.String(),
+	}
 
-	// Manage potential errors coming from the command itself.
-	cmd_stderr_output, cmd_stderr_output_err := io.ReadAll(stderr)
-	e(cmd_stderr_output_err)
-	if len(cmd_stderr_output) != 0 {
-		slog.Error("Query error", "query", query)
+	// Manage query cancellation.
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		slog.Warn("Query timed out", "query", query, "after", result.Duration.Round(time.Millisecond))
+		result.TimedOut = true
+		AddStorageResult(query, result, history)
+		return
+	case errors.Is(ctx.Err(), context.Canceled):
+		slog.Debug("Query cancelled", "query", query)
+		return
 	}
 
-	// Interpret results.
-	cmd_output, cmd_output_err := io.ReadAll(stdout)
-	e(cmd_output_err)
+	// Manage potential errors coming from the command itself.
+	var exitErr *exec.ExitError
+	if cmd_err != nil && !errors.As(cmd_err, &exitErr) {
+		e(cmd_err)
+		return
+	}
+	if result.ExitCode != 0 || result.Stderr != "" {
+		slog.Error(
+			"Query error",
+			"query", query,
+			"exitCode", result.ExitCode,
+			"stderr", result.Stderr,
+		)
+	}
 
 	// Store results.
-	slog.Debug("Query success", "query", query, "result", cmd_output)
-	AddResult(query, string(cmd_output), history)
+	slog.Debug(
+		"Query success",
+		"query", query,
+		"result", stdout.Bytes(),
+		"duration", result.Duration,
+	)
+	AddStorageResult(query, result, history)
+}
+
+// Creates a split function for scanning records delimited by a separator. Separators are dropped
+// from records.
+func splitRecords(separator string) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			// There is nothing left.
+			return 0, nil, nil
+		}
+		if i := bytes.Index(data, []byte(separator)); i >= 0 {
+			// We have a full record.
+			return i + len(separator), data[:i], nil
+		}
+		if atEOF {
+			// The last record has no separator.
+			return len(data), data, nil
+		}
+
+		// Request more data.
+		return 0, nil, nil
+	}
+}
+
+// Executes a query as a long-running command, calling a function for each record the command
+// outputs. Returns when the command exits or the record function returns false.
+func runQueryStreamExec(
+	ctx context.Context,
+	query, separator string,
+	recordFunc func(string) bool,
+) error {
+	slog.Debug("Executing streaming query", "query", query)
+
+	// Allow the query to be stopped early.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Prepare query execution. Queries run in their own process group so that cancellation reaches
+	// anything the shell has started, not just the shell itself.
+	cmd := exec.CommandContext(ctx, "bash", "-c", query)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = QUERY_WAIT_DELAY
+
+	// Set-up pipes for command output.
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	// Execute the query.
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	// Errors are reported as they happen, since the command may never exit.
+	go func() {
+		stderrScanner := bufio.NewScanner(stderr)
+		for stderrScanner.Scan() {
+			slog.Error("Query error", "query", query, "stderr", stderrScanner.Text())
+		}
+	}()
+
+	// Interpret results.
+	stdoutScanner := bufio.NewScanner(stdout)
+	stdoutScanner.Buffer(make([]byte, QUERY_STREAM_RECORD_INIT), QUERY_STREAM_RECORD_MAX)
+	stdoutScanner.Split(splitRecords(separator))
+	for stdoutScanner.Scan() {
+		if strings.TrimSpace(stdoutScanner.Text()) == "" {
+			// Ignore empty records.
+			continue
+		}
+		if !recordFunc(stdoutScanner.Text()) {
+			// We've been asked to stop.
+			cancel()
+			break
+		}
+	}
+	if err = stdoutScanner.Err(); err != nil {
+		slog.Error("Failed reading streaming query output", "query", query, "err", err)
+	}
 
 	// Clean-up.
-	cmd.Wait()
+	return cmd.Wait()
+}
+
+// Wrapper for streaming query execution. Each record produced by a query becomes a result, and
+// attempts are counted in records. Queries that exit are restarted with a backoff.
+func runQueryStream(
+	ctx context.Context,
+	query, separator string,
+	attempts int,
+	history bool,
+	doneChan, pauseChan chan bool,
+) {
+	var (
+		paused atomic.Bool // Whether the query is paused.
+
+		backoff = QUERY_STREAM_BACKOFF_MIN // Delay before restarting the query.
+		records = 0                        // Records produced by the query.
+	)
+
+	defer queriesWaitGroup.Done()
+
+	// Stop the query once attempts are exhausted.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Manage pausing. Streaming queries keep running while paused, but their records are discarded.
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-pauseChan:
+				paused.Store(!paused.Load())
+			}
+		}
+	}()
+
+	for attempts < 0 || records < attempts {
+		start := time.Now()
+		err := runQueryStreamExec(ctx, query, separator, func(record string) bool {
+			if paused.Load() {
+				return true
+			}
+
+			AddStorageResult(query, storage.Result{Value: record}, history)
+			records += 1
+
+			return attempts < 0 || records < attempts
+		})
+		if ctx.Err() != nil || (attempts >= 0 && records >= attempts) {
+			break
+		}
+
+		// Reset the backoff if the query ran for a while before exiting.
+		if time.Since(start) > QUERY_STREAM_BACKOFF_MAX {
+			backoff = QUERY_STREAM_BACKOFF_MIN
+		}
+
+		// The query has exited on its own--restart it after a delay.
+		slog.Warn("Streaming query exited", "query", query, "err", err, "restartIn", backoff)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, QUERY_STREAM_BACKOFF_MAX)
+	}
+
+	doneChan <- true
 }
 
-// Executes a query as a process to profile.
-func runQueryProfile(pid string, history bool) {
-	slog.Debug("Profiling pid", "pid", pid)
+// Executes a query as a process to profile, or profiles the system if the query is PROFILE_SYSTEM.
+// Profiles label their results, unless the query has labels of its own.
+func runQueryProfile(ctx context.Context, query string, history bool) {
+	var (
+		labels []string // Labels of profiled values.
+		result string   // Profiled values.
+	)
+
+	slog.Debug("Profiling", "query", query)
+
+	start := time.Now()
+	if query == PROFILE_SYSTEM {
+		var err error // General error holder.
 
-	pidInt, err := strconv.Atoi(pid)
-	e(err)
-	AddResult(pid, runProfile(pidInt), history)
+		if labels, result, err = runSystemProfile(ctx); err != nil {
+			e(err)
+			return
+		}
+	} else {
+		pidInt, err := strconv.Atoi(query)
+		e(err)
+		labels, result = ProfileLabels, runProfile(ctx, pidInt)
+	}
+	if !queryLabelled[query] {
+		e(store.PutLabels(query, labels))
+	}
+	AddStorageResult(query, storage.Result{Duration: time.Since(start), Value: result}, history)
 }
 
-// Entrypoint for 'query' mode.
+// Cancels all running queries, including any in-flight commands, and waits for them to stop.
+func StopQueries() {
+	queriesCancel()
+	queriesWaitGroup.Wait()
+}
+
+// Entrypoint for 'query' mode. Queries run until their attempts are exhausted or the provided
+// context is cancelled. Timeouts are in seconds, are applied to each execution of a query, and
+// prefer a query-specific timeout to the global one. A timeout of zero disables it. Queries with
+// their own settings use those instead of the provided mode, attempts, delay, timeout, labels,
+// parser, and units. The record separator only applies to streaming queries, and parsers don't apply
+// to profiles.
 func Query(
-	queryMode, attempts, delay int,
-	queries []string,
+	ctx context.Context,
+	queryMode, attempts, delay, timeout int,
+	queries, labels []string,
+	queryTimeouts map[string]int,
+	queryConfigs map[string]QueryConfig,
+	parser, recordSeparator string,
 	port string,
-	history bool,
+	history, units bool,
 	resultsReadyChan chan bool,
 ) (chan bool, map[string]chan bool) {
 	var (
@@ -104,12 +379,31 @@ func Query(
 		pauseQueryChans = make(map[string]chan bool, len(queries)) // Signals query pausing.
 	)
 
-	// Start the RPC server.
-	initServer(port)
+	// Allow queries to be stopped when quitting.
+	ctx, queriesCancel = context.WithCancel(ctx)
 
+	queryLabelled = make(map[string]bool, len(queries))
+	queryParsers = make(map[string]*Parser, len(queries))
+	queryUnits = make(map[string]bool, len(queries))
 	for _, query := range queries {
 		// Initialize pause channels.
 		pauseQueryChans[query] = make(chan bool)
+
+		// Initialize parsers, which have already been validated.
+		mode, queryLabels, queryParser, queryUnit := queryMode, labels, parser, units
+		if queryConfig, ok := queryConfigs[query]; ok {
+			mode, queryLabels, queryParser, queryUnit =
+				queryConfig.Mode, queryConfig.Labels, queryConfig.Parser, queryConfig.Units
+		}
+		if mode != QUERY_MODE_PROFILE {
+			queryParser, err := NewParser(queryParser)
+			e(err)
+			if err == nil {
+				queryParsers[query] = queryParser
+			}
+		}
+		queryLabelled[query] = len(queryLabels) > 0
+		queryUnits[query] = queryUnit
 	}
 
 	go func() {
@@ -117,15 +411,32 @@ func Query(
 		slog.Debug("Waiting for results readiness")
 		<-resultsReadyChan
 
+		// Start the RPC server, now that the storage it serves has been initialized.
+		initServer(port)
+
 		for _, query := range queries {
+			// Determine settings for the query.
+			queryTimeout, ok := queryTimeouts[query]
+			if !ok {
+				queryTimeout = timeout
+			}
+			mode, queryAttempts, queryDelay := queryMode, attempts, delay
+			if queryConfig, ok := queryConfigs[query]; ok {
+				mode, queryAttempts, queryDelay, queryTimeout =
+					queryConfig.Mode, queryConfig.Count, queryConfig.Delay, queryConfig.Timeout
+			}
+
 			// Execute the queries.
-			switch queryMode {
+			switch mode {
 			case QUERY_MODE_COMMAND:
 				slog.Debug("Executing in query mode command")
+				queriesWaitGroup.Add(1)
 				go runQuery(
+					ctx,
 					query,
-					attempts,
-					delay,
+					queryAttempts,
+					queryDelay,
+					queryTimeout,
 					history,
 					doneQueryChan,
 					pauseQueryChans[query],
@@ -133,14 +444,32 @@ func Query(
 				)
 			case QUERY_MODE_PROFILE:
 				slog.Debug("Executing in query mode profile")
-				go runQuery(
+				queriesWaitGroup.Add(1)
+				go func(query string, queryAttempts, queryDelay, queryTimeout int) {
+					runQuery(
+						ctx,
+						query,
+						queryAttempts,
+						queryDelay,
+						queryTimeout,
+						history,
+						doneQueryChan,
+						pauseQueryChans[query],
+						runQueryProfile,
+					)
+					forgetProcessSample(query)
+				}(query, queryAttempts, queryDelay, queryTimeout)
+			case QUERY_MODE_STREAM:
+				slog.Debug("Executing in query mode stream")
+				queriesWaitGroup.Add(1)
+				go runQueryStream(
+					ctx,
 					query,
-					attempts,
-					delay,
+					recordSeparator,
+					queryAttempts,
 					history,
 					doneQueryChan,
 					pauseQueryChans[query],
-					runQueryProfile,
 				)
 			}
 		}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
+
+func TestRunQueryExecTimeout(t *testing.T) {
+	var (
+		err   error
+		query = "sleep 10 & sleep 10"
+	)
+
+	store, err = storage.NewStorage(false, storage.Location{}, storage.Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// It stops the query, including any processes it started, shortly after the timeout.
+	start := time.Now()
+	runQueryExec(ctx, query, false)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Got: %v Expected: < %v\n", elapsed, 5*time.Second)
+	}
+
+	// It records the result as a timeout.
+	got := store.GetAll(query)
+	if len(got) != 1 || !got[0].TimedOut {
+		t.Errorf("Got: %v\n", got)
+	}
+}
+
+func TestSplitRecords(t *testing.T) {
+	tests := []struct {
+		separator string
+		input     string
+		expected  []string
+	}{
+		{"\n", "foo\nbar\n", []string{"foo", "bar"}},
+		{"\n", "foo\nbar", []string{"foo", "bar"}},
+		{"--", "foo--bar--baz", []string{"foo", "bar", "baz"}},
+	}
+
+	// It splits records by a separator, dropping the separator.
+	for _, test := range tests {
+		var got []string
+
+		scanner := bufio.NewScanner(strings.NewReader(test.input))
+		scanner.Split(splitRecords(test.separator))
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Got: %v Expected: %v\n", got, test.expected)
+		}
+	}
+}
+
+func TestRunQueryStream(t *testing.T) {
+	var (
+		err   error
+		query = "printf 'foo 1\\nbar 2\\nbaz 3\\n'; sleep 10"
+
+		doneChan  = make(chan bool, 1)
+		pauseChan = make(chan bool)
+	)
+
+	store, err = storage.NewStorage(false, storage.Location{}, storage.Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It stops the query once attempts are exhausted.
+	queriesWaitGroup.Add(1)
+	go runQueryStream(context.Background(), query, "\n", 2, false, doneChan, pauseChan)
+	select {
+	case <-doneChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Streaming query did not stop")
+	}
+
+	// It stores a result for each record.
+	got := store.GetAll(query)
+	if len(got) != 2 || got[0].Value != "foo 1" || got[1].Value != "bar 2" {
+		t.Errorf("Got: %v\n", got)
+	}
+}
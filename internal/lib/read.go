@@ -3,9 +3,152 @@
 
 package lib
 
-func Read(port string) (done chan int) {
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	READ_RETRY_DELAY = 3 * time.Second // Delay before retrying failed reads.
+	READ_WAIT        = time.Second     // How long the server should wait for new results per read.
+)
+
+// Follows new results for a query, storing them as they are read.
+// Labels are only updated when they change on the other Cryptarch.
+func readQuery(
+	ctx context.Context,
+	query string,
+	index int,
+	labels []string,
+	doneChan, pauseChan chan bool,
+) {
+	var (
+		args  storage.ArgsRPC    // Arguments for reading.
+		reply storage.ResultsRPC // Read results.
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			doneChan <- true
+			return
+		case <-pauseChan:
+			// Manage pausing. If we receive from the pause channel, wait for another message from the
+			// pause channel. Results stored while paused are read once unpaused.
+			select {
+			case <-ctx.Done():
+			case <-pauseChan:
+			}
+		default:
+			args = storage.ArgsRPC{Index: index, Query: query, Wait: READ_WAIT}
+			reply = storage.ResultsRPC{}
+
+			if err := callClient(storage.RPC_NAME+".GetFrom", &args, &reply); err != nil {
+				slog.Error("Failed reading results", "query", query, "err", err)
+				time.Sleep(READ_RETRY_DELAY)
+				continue
+			}
+
+			if len(reply.Labels) > 0 && !slices.Equal(labels, reply.Labels) {
+				labels = reply.Labels
+				e(store.PutLabels(query, labels))
+			}
+			for _, result := range reply.Results {
+				_, err := store.PutResult(query, result, false)
+				e(err)
+			}
+			index = reply.Index
+		}
+	}
+}
+
+// Entrypoint for 'read' mode. Connects to another Cryptarch over RPC, loads its existing results,
+// and follows new ones. The network may be 'tcp' or 'unix', for reading from a daemon. If no queries
+// are provided, all queries known to the other Cryptarch are read. Returns the queries being read.
+func Read(
+	ctx context.Context,
+	queries []string,
+	network, address string,
+	inputConfig *Config,
+	resultsReadyChan chan bool,
+) (chan bool, map[string]chan bool, []string, error) {
+	var (
+		err     error               // General error holder.
+		indexes map[string]int      // Indexes to begin following results from.
+		labels  map[string][]string // Labels for results being followed.
+		qReply  storage.QueriesRPC  // Queries read from the server.
+
+		doneQueriesChan = make(chan bool) // Signals overall completion.
+	)
+
 	// Start the RPC client.
-	initClient(port)
+	if err = initClient(network, address); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Discover queries, if none were given.
+	if len(queries) == 0 {
+		err = callClient(storage.RPC_NAME+".GetQueries", &storage.ArgsRPC{}, &qReply)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		queries = qReply.Queries
+	}
+	if len(queries) == 0 {
+		return nil, nil, nil, fmt.Errorf("No queries to read from %s", address)
+	}
+
+	// Results read from elsewhere are never persisted here.
+	if err = initStorage(false, inputConfig); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Load existing results before any displays start, so that they are presented as history.
+	indexes, labels = make(map[string]int, len(queries)), make(map[string][]string, len(queries))
+	for _, query := range queries {
+		reply := storage.ResultsRPC{}
+		err = callClient(storage.RPC_NAME+".GetFrom", &storage.ArgsRPC{Query: query}, &reply)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		slog.Debug("Loaded results", "query", query, "count", len(reply.Results))
+		store.Load(query, reply.Labels, reply.Results)
+		indexes[query], labels[query] = reply.Index, reply.Labels
+	}
+
+	doneQueryChan := make(chan bool, len(queries))
+	pauseQueryChans := make(map[string]chan bool, len(queries))
+	for _, query := range queries {
+		pauseQueryChans[query] = make(chan bool)
+	}
+
+	go func() {
+		// Wait for result consumption to become ready.
+		slog.Debug("Waiting for results readiness")
+		<-resultsReadyChan
+
+		for _, query := range queries {
+			go readQuery(
+				ctx, query, indexes[query], labels[query], doneQueryChan, pauseQueryChans[query])
+		}
+	}()
+
+	// Begin the goroutine to wait for reading completion.
+	go func() {
+		defer close(doneQueryChan)
+
+		for i := 0; i < len(queries); i++ {
+			<-doneQueryChan
+		}
+
+		doneQueriesChan <- true
+	}()
 
-	return
+	return doneQueriesChan, pauseQueryChans, queries, nil
 }
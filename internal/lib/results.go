@@ -11,6 +11,8 @@ package lib
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"strconv"
@@ -27,12 +29,13 @@ import (
 )
 
 var (
-	config          Config                          // Global configuration.
-	currentCtx      context.Context                 // Current context.
-	driver          DisplayDriver                   // Display driver, dictated by the results.
-	pauseQueryChans map[string]chan bool            // Channels for dealing with 'pause' events for results.
-	readerIndexes   map[string]*storage.ReaderIndex // Collection of reader index ids per query.
-	store           storage.Storage                 // Stored results.
+	config           Config                           // Global configuration.
+	currentCtx       context.Context                  // Current context.
+	driver           DisplayDriver                    // Display driver, dictated by the results.
+	pauseQueryChans  map[string]chan bool             // Channels for dealing with 'pause' events for results.
+	store            storage.Storage                  // Stored results.
+	storeInitialized bool                             // Whether or not stored results are ready.
+	subscriptions    map[string]*storage.Subscription // Subscriptions to results per query.
 
 	ctxDefaults = map[string]interface{}{
 		"advanceDisplayMode": false,
@@ -59,8 +62,12 @@ func exprResult(
 
 	// Construct the expression environment.
 	env = map[string]interface{}{
-		"prevResult": prevResult.Map(store.GetLabels(query, []string{})),
-		"result":     result.Map(store.GetLabels(query, []string{})),
+		"duration":   result.Duration,
+		"exitCode":   result.ExitCode,
+		"prevResult": exprValues(prevResult.Map(store.GetLabels(query, []string{}))),
+		"result":     exprValues(result.Map(store.GetLabels(query, []string{}))),
+		"stderr":     result.Stderr,
+		"timedOut":   result.TimedOut,
 	}
 	slog.Debug("Expression executing", "query", query, "expression", expression, "env", env)
 
@@ -76,48 +83,35 @@ func exprResult(
 		return result, err
 	}
 
-	// Re-define result based on the expression output.
-	switch output.(type) {
-	case bool:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  strconv.FormatBool(output.(bool)),
-			Values: storage.Values{strconv.FormatBool(output.(bool))},
-		}
+	// Re-define result based on the expression output, preserving result metadata.
+	newResult = result
+	switch output := output.(type) {
+	case bool, float64, int64, string, time.Duration:
+		newResult.Values = storage.Values{output}
 	case int:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  strconv.Itoa(output.(int)),
-			Values: storage.Values{strconv.Itoa(output.(int))},
-		}
-	case int64:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  strconv.FormatInt(output.(int64), 10),
-			Values: storage.Values{strconv.FormatInt(output.(int64), 10)},
-		}
-	case float64:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  strconv.FormatFloat(output.(float64), 'f', -1, 64),
-			Values: storage.Values{strconv.FormatFloat(output.(float64), 'f', -1, 64)},
-		}
-	case string:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  output.(string),
-			Values: storage.Values{output.(string)},
-		}
+		newResult.Values = storage.Values{int64(output)}
 	default:
 		// The output type isn't one that may be processed by an expression (like nil), so return the
 		// result unmodified.
 		slog.Warn("Expression output not supported", "expr", expression, "env", env, "output", output)
-		newResult = result
+		return
 	}
+	newResult.Value = storage.FormatValue(newResult.Values[0])
 
 	return
 }
 
+// Prepares result values for expressions, where quantities are numbers in their base unit.
+func exprValues(values map[string]interface{}) map[string]interface{} {
+	for label, value := range values {
+		if quantity, ok := value.(storage.Quantity); ok {
+			values[label] = quantity.Value
+		}
+	}
+
+	return values
+}
+
 // Resets the current context to its default values.
 func resetContext(query string) {
 	for k, v := range ctxDefaults {
@@ -129,9 +123,60 @@ func resetContext(query string) {
 // Adds a result to the result store based on a string. It is assumed that all processing has
 // ocurred on the result itself.
 func AddResult(query, result string, history bool) {
-	result = strings.TrimSpace(result)
-	_, err := store.Put(query, result, history, TokenizeResult(result)...)
-	e(err)
+	AddStorageResult(query, storage.Result{Value: result}, history)
+}
+
+// Adds a result to the result store, preserving any metadata it carries. The result value will be
+// parsed by the query's parser unless the query timed out, labelling the results if the parser
+// provides labels and the query has none of its own. Output that fails to parse is stored without
+// values. Parsers that are tables store a result for each row, all at the same time. Values with
+// human units become quantities if the query parses units.
+func AddStorageResult(query string, result storage.Result, history bool) {
+	var (
+		err    error       // General error holder.
+		labels []string    // Labels provided by parsing.
+		rows   []ParsedRow // Rows provided by parsing tables.
+	)
+
+	result.Value = strings.TrimSpace(result.Value)
+	if !result.TimedOut {
+		parser, ok := queryParsers[query]
+		switch {
+		case !ok:
+			result.Values = TokenizeResult(result.Value)
+		case parser.IsTable():
+			rows, labels, err = parser.ParseTable(result.Value)
+		default:
+			result.Values, labels, err = parser.Parse(result.Value)
+		}
+	}
+	switch {
+	case errors.Is(err, errParseHeader):
+		slog.Debug("Parsed a header", "query", query, "labels", labels)
+		return
+	case err != nil:
+		slog.Warn("Failed to parse result", "query", query, "err", err)
+	case len(labels) > 0 && !queryLabelled[query]:
+		e(store.PutLabels(query, labels))
+	}
+	if queryUnits[query] {
+		parseUnits(result.Values)
+		for _, row := range rows {
+			parseUnits(row.Values)
+		}
+	}
+	if len(rows) == 0 {
+		_, err = store.PutResult(query, result, history)
+		e(err)
+		return
+	}
+
+	result.Time = time.Now()
+	for _, row := range rows {
+		result.Key, result.Value, result.Values = row.Key, row.Line, row.Values
+		_, err = store.PutResult(query, result, history)
+		e(err)
+	}
 }
 
 // Get results previous to the last read result.
@@ -139,14 +184,16 @@ func GetPrevResults(query string, filters []string) (results []storage.Result) {
 	slog.Debug("Fetching previous results", "query", query)
 
 	// Retrieve previous results.
-	return store.GetToIndex(query, filters, readerIndexes[query])
+	return store.GetToIndex(query, filters, subscriptions[query].Index())
 }
 
 // Retrieves a next result.
 func GetResult(query string, filters []string) (result storage.Result) {
 	slog.Debug("Fetching next result", "query", query)
 
-	return store.Next(query, filters, readerIndexes[query])
+	result, _ = subscriptions[query].Next()
+
+	return FilterResult(result, filters, store.GetLabels(query, []string{}))
 }
 
 // Returns a result after applying expressions. Requires a previous result for calculations
@@ -173,7 +220,7 @@ func ExprResult(
 // Retrieves a next result, waiting for a non-empty return in a non-blocking manner.
 func GetResultWait(query string) (result storage.Result) {
 	for {
-		if result = store.NextOrEmpty(query, readerIndexes[query]); result.IsEmpty() {
+		if result = subscriptions[query].NextOrEmpty(); result.IsEmpty() {
 			// Wait a tiny bit if we receive an empty result to avoid an excessive amount of busy waiting.
 			// This wait time should be less than the query delay, otherwise displays will show a release
 			// of buffered results.
@@ -204,11 +251,9 @@ func FilterResult(result storage.Result, filters, labels []string) storage.Resul
 	// Filter the results.
 	resultValues = FilterSlice(result.Values, labelIndexes)
 
-	return storage.Result{
-		Time:   result.Time,
-		Value:  result.Value,
-		Values: resultValues,
-	}
+	result.Values = resultValues
+
+	return result
 }
 
 // Parses a result into tokens for compound storage.
@@ -226,28 +271,110 @@ func TokenizeResult(result string) (parsedResult []interface{}) {
 
 	for token := s.Scan(); token != scanner.EOF; token = s.Scan() {
 		next = s.TokenText()
+		parsedResult = append(parsedResult, tokenizeValue(next))
+	}
 
-		// Attempt to parse this value as an integer.
-		nextInt, err := strconv.ParseInt(next, 10, 0)
-		if err == nil {
-			parsedResult = append(parsedResult, nextInt)
-			continue
-		}
+	return
+}
 
-		// Attempt to parse this value as a float.
-		nextFloat, err := strconv.ParseFloat(next, 10)
-		if err == nil {
-			parsedResult = append(parsedResult, nextFloat)
-			continue
+// Parses a single token into a value, e.g. numbers become numbers.
+func tokenizeValue(token string) interface{} {
+	// Attempt to parse this value as an integer.
+	tokenInt, err := strconv.ParseInt(token, 10, 0)
+	if err == nil {
+		return tokenInt
+	}
+
+	// Attempt to parse this value as a float.
+	tokenFloat, err := strconv.ParseFloat(token, 10)
+	if err == nil {
+		return tokenFloat
+	}
+
+	// Everything else has failed--just pass it as a string.
+	return token
+}
+
+// Parses values with human units into quantities, e.g. '512Mi' or '87%'. Other values are left as
+// they are.
+func parseUnits(values []interface{}) {
+	for i, value := range values {
+		if value, ok := value.(string); ok {
+			if quantity, ok := storage.ParseQuantity(value); ok {
+				values[i] = quantity
+			}
 		}
+	}
+}
+
+// Initializes result storage and any external storages. Storage is only initialized once, so that
+// it may be prepared before results are displayed.
+func initStorage(history bool, inputConfig *Config) (err error) {
+	var (
+		elasticsearch storage.ElasticsearchStorage // Elasticsearch configuration.
+		pushgateway   storage.PushgatewayStorage   // Pushgateway configuration.
+		prometheus    storage.PrometheusStorage    // Prometheus configuration.
+	)
+
+	if storeInitialized {
+		return
+	}
 
-		// Everything else has failed--just pass it as a string.
-		parsedResult = append(parsedResult, next)
+	// Initialize storage, with any retention specific to queries.
+	queryRetentions := make(map[string]storage.Retention, len(inputConfig.QueryConfigs))
+	for query, queryConfig := range inputConfig.QueryConfigs {
+		queryRetentions[query] = queryConfig.Retention
+	}
+	store, err = storage.NewStorage(
+		history, inputConfig.StorageLocation, inputConfig.Retention, queryRetentions)
+	if err != nil {
+		return
+	}
+	storeInitialized = true
+
+	// Initialize external storage.
+	if inputConfig.ElasticsearchAddr != "" {
+		elasticsearch = storage.NewElasticsearchStorage(
+			inputConfig.ElasticsearchAddr,
+			inputConfig.ElasticsearchIndex,
+			inputConfig.ElasticsearchPassword,
+			inputConfig.ElasticsearchUser,
+		)
+		store.AddExternalStorage(&elasticsearch)
+	}
+	if inputConfig.PushgatewayAddr != "" {
+		pushgateway = storage.NewPushgatewayStorage(inputConfig.PushgatewayAddr)
+		store.AddExternalStorage(&pushgateway)
+	}
+	if inputConfig.PrometheusExporterAddr != "" {
+		prometheus = storage.NewPrometheusStorage(inputConfig.PrometheusExporterAddr)
+		store.AddExternalStorage(&prometheus)
 	}
 
 	return
 }
 
+// Removes results for a query from a persisted storage database according to a retention policy,
+// returning how many results were removed.
+func Prune(location storage.Location, query string, retention storage.Retention) (int, error) {
+	prunedStore, err := storage.NewStorage(true, location, storage.Retention{}, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer prunedStore.Close()
+
+	return prunedStore.Prune(query, retention)
+}
+
+// Retrieves how a query should be presented, including its name if it has one.
+func queryTitle(query string) string {
+	if queryConfig, ok := config.QueryConfigs[query]; ok && queryConfig.Name != "" {
+		return fmt.Sprintf("%s: %s", queryConfig.Name, query)
+	}
+
+	return query
+}
+
 // Entry-point function for results.
 func Results(
 	ctx context.Context,
@@ -260,15 +387,13 @@ func Results(
 	resultsReadyChan chan bool,
 ) {
 	var (
-		err           error                        // General error holder.
-		elasticsearch storage.ElasticsearchStorage // Elasticsearch configuration.
-		pushgateway   storage.PushgatewayStorage   // Pushgateway configuration.
-		prometheus    storage.PrometheusStorage    // Prometheus configuration.
+		err error // General error holder.
 
 		expressions = ctx.Value("expressions").([]string) // Capture expressions from context.
 		filters     = ctx.Value("filters").([]string)     // Capture filters from context.
 		labels      = ctx.Value("labels").([]string)      // Capture labels from context.
 		queries     = ctx.Value("queries").([]string)     // Capture queries from context.
+		rows        = ctx.Value("rows").([]string)        // Capture rows from context.
 	)
 
 	// Assign global config and global control channels.
@@ -279,33 +404,19 @@ func Results(
 	}
 
 	// Initialize storage.
-	store, err = storage.NewStorage(history)
-	e(err)
-	defer store.Close()
-
-	// Initialize external storage.
-	if config.ElasticsearchAddr != "" {
-		elasticsearch = storage.NewElasticsearchStorage(
-			config.ElasticsearchAddr,
-			config.ElasticsearchIndex,
-			config.ElasticsearchPassword,
-			config.ElasticsearchUser,
-		)
-		store.AddExternalStorage(&elasticsearch)
-	}
-	if config.PushgatewayAddr != "" {
-		pushgateway = storage.NewPushgatewayStorage(config.PushgatewayAddr)
-		store.AddExternalStorage(&pushgateway)
-	}
-	if config.PrometheusExporterAddr != "" {
-		prometheus = storage.NewPrometheusStorage(config.PrometheusExporterAddr)
-		store.AddExternalStorage(&prometheus)
+	if err = initStorage(history, inputConfig); err != nil {
+		slog.Error(fmt.Sprintf("Failed to initialize storage: %v\n", err))
+		os.Exit(1)
 	}
+	defer store.Close()
 
-	// Initialize reader indexes.
-	readerIndexes = make(map[string]*storage.ReaderIndex, len(queries))
+	// Subscribe to results. Displays only present one query at a time, so results of other queries
+	// are caught up on from storage once they are presented again.
+	subscriptions = make(map[string]*storage.Subscription, len(queries))
 	for _, query := range queries {
-		readerIndexes[query] = store.NewReaderIndex(query)
+		subscriptions[query], err = store.Subscribe(
+			query, storage.SubscribeOptions{Policy: storage.SUBSCRIBE_CATCH_UP})
+		e(err)
 	}
 
 	// Signals that results are ready to be received.
@@ -317,31 +428,38 @@ func Results(
 		currentCtx = ctx
 		resetContext(query)
 
+		// Use settings specific to the query, if there are any.
+		queryExpressions, queryFilters, queryLabels, queryRows := expressions, filters, labels, rows
+		if queryConfig, ok := config.QueryConfigs[query]; ok {
+			queryExpressions, queryFilters, queryLabels, queryRows =
+				queryConfig.Expressions, queryConfig.Filters, queryConfig.Labels, queryConfig.Rows
+		}
+
 		// Set up labelling or any schema for the results store, if any were explicitly provided.
-		if len(labels) > 0 {
-			store.PutLabels(query, labels)
+		if len(queryLabels) > 0 {
+			e(store.PutLabels(query, queryLabels))
 		}
 
 		switch displayMode {
 		case DISPLAY_MODE_RAW:
 			driver = DISPLAY_RAW
-			RawDisplay(query, filters, expressions)
+			RawDisplay(query, queryFilters, queryExpressions, queryRows)
 		case DISPLAY_MODE_STREAM:
 			driver = DISPLAY_TVIEW
-			StreamDisplay(query, filters, expressions, displayConfig)
+			StreamDisplay(query, queryFilters, queryExpressions, queryRows, displayConfig)
 		case DISPLAY_MODE_TABLE:
 			driver = DISPLAY_TVIEW
-			TableDisplay(query, filters, expressions, displayConfig)
+			TableDisplay(query, queryFilters, queryExpressions, queryRows, displayConfig)
 		case DISPLAY_MODE_GRAPH:
-			if len(filters) == 0 {
-				slog.Error("Graph mode requires a filter")
+			if len(queryFilters) == 0 {
+				slog.Error("Graph mode requires a filter", "query", query)
 				os.Exit(1)
 			}
-			if len(filters) > 1 {
+			if len(queryFilters) > 1 {
 				slog.Warn("Graph mode can only apply one filter; ignoring all but the first")
 			}
 			driver = DISPLAY_TERMDASH
-			GraphDisplay(query, filters[0], expressions, displayConfig)
+			GraphDisplay(query, queryFilters[0], queryExpressions, queryRows, displayConfig)
 		default:
 			slog.Error("Invalid result driver", "displayMode", displayMode)
 			os.Exit(1)
@@ -353,6 +471,7 @@ func Results(
 		if currentCtx.Value("quit").(bool) {
 			// Guess I'll die.
 			displayQuit()
+			StopQueries()
 			os.Exit(0)
 		}
 		if currentCtx.Value("advanceDisplayMode").(bool) {
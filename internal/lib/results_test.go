@@ -43,3 +43,17 @@ func TestFilterResult(t *testing.T) {
 		t.Errorf("Got: %v Expected %v\n", got, expected)
 	}
 }
+
+func TestParseUnits(t *testing.T) {
+	values := []interface{}{"512Mi", "foo", int64(3), "87%"}
+	expected := []interface{}{
+		storage.Quantity{Value: 512 << 20, Unit: "Mi"}, "foo", int64(3),
+		storage.Quantity{Value: 0.87, Unit: "%"},
+	}
+
+	// It parses values with units into quantities, leaving others alone.
+	parseUnits(values)
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", values, expected)
+	}
+}
@@ -1,26 +1,72 @@
 //
 // Server for RPC.
-//
-// TODO RPC is currently disabled.
 
 package lib
 
-// import (
-// 	"fmt"
-// 	"log/slog"
-// 	"net"
-// 	"net/http"
-// 	"net/rpc"
-// )
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/rpc"
+
+	"github.com/spacez320/cryptarch/pkg/storage"
+)
+
+// Builds an RPC handler allowing access to stored results, along with any other provided receivers.
+func newServerHandler(receivers map[string]interface{}) (http.Handler, error) {
+	var (
+		mux    = http.NewServeMux() // Dedicated mux, to avoid sharing endpoints with other servers.
+		server = rpc.NewServer()    // RPC server.
+	)
+
+	err := server.RegisterName(storage.RPC_NAME, storage.NewStorageRPC(&store))
+	if err != nil {
+		return nil, err
+	}
+	for name, receiver := range receivers {
+		if err = server.RegisterName(name, receiver); err != nil {
+			return nil, err
+		}
+	}
+	mux.Handle(rpc.DefaultRPCPath, server)
+
+	return mux, nil
+}
 
 // Establish the RPC server to allow access to stored results.
 func initServer(port string) {
-	// rpc.Register(&store)
-	// rpc.HandleHTTP()
-	//
-	// listener, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
-	// e(err)
-	//
-	// slog.Debug(fmt.Sprintf("Listening on :%v.\n", port))
-	// go http.Serve(listener, nil)
+	handler, err := newServerHandler(nil)
+	if err != nil {
+		e(err)
+		return
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
+	if err != nil {
+		e(err)
+		return
+	}
+
+	slog.Debug(fmt.Sprintf("Listening on :%v", port))
+	go http.Serve(listener, handler)
+}
+
+// Establish the RPC server on a Unix socket, allowing access to stored results and any other
+// provided receivers. The listener is returned so that it may be closed.
+func initSocketServer(path string, receivers map[string]interface{}) (net.Listener, error) {
+	handler, err := newServerHandler(receivers)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug(fmt.Sprintf("Listening on %v", path))
+	go http.Serve(listener, handler)
+
+	return listener, nil
 }
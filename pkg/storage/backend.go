@@ -0,0 +1,122 @@
+//
+// Backends for persisting storage.
+//
+// Storage keeps results series in memory and persists them to a backend as they change, so that
+// they may be restored later. Backends are:
+//
+// - A log of records, see wal.go. This is the default.
+// - An embedded SQLite database, see sqlite.go, which may also be queried with SQL.
+//
+// A database keeps using whichever backend it was created with. Backends may also be read directly,
+// without restoring storage, e.g. for reading a range of results.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	BACKEND_SQLITE = "sqlite" // Backend persisting to an embedded SQLite database.
+	BACKEND_WAL    = "wal"    // Backend persisting to a log.
+)
+
+var (
+	// Supported backends, with the default first.
+	Backends = []string{BACKEND_WAL, BACKEND_SQLITE}
+	// Files that backends persist to, within a database directory.
+	backendFiles = map[string]string{BACKEND_SQLITE: SQLITE_FILE_NAME, BACKEND_WAL: WAL_FILE_NAME}
+)
+
+// Persistence for storage.
+type Backend interface {
+	// Restores storage from the backend, after which changes to storage are persisted to it. Results
+	// outside of retention are removed while restoring.
+	Open(storage *Storage) error
+	// Restores storage from the backend without modifying it, e.g. while another process writes it.
+	Read(storage *Storage) error
+	// Persists a result after it is put into storage, along with its series if necessary. Pruned is
+	// how many results of the series were removed by retention when putting it.
+	PutResult(query string, result Result, pruned int) error
+	// Persists a results series without its results, e.g. after its labels change.
+	PutSeries(query string) error
+	// Persists the archiving of a results series as a previous generation. The new series for the
+	// query is expected to be persisted afterwards.
+	PutArchive(query, archive string) error
+	// Persists the removal of results and rollups from a series by retention.
+	Prune(query string) error
+	// Persists all of storage, replacing whatever was persisted, e.g. after migrating storage.
+	Save() error
+	// Retrieves the number of persisted results of a series and the times of its first and last
+	// results, without retrieving the results themselves. Times are zero without results.
+	Bounds(query string) (count int, first, last time.Time, err error)
+	// Retrieves the persisted labels of a results series.
+	Labels(query string) ([]string, error)
+	// Retrieves persisted results of a series between two times, inclusive and in time order. Zero
+	// times are unbounded.
+	Range(query string, startTime, endTime time.Time) ([]Result, error)
+	// Retrieves the queries of persisted results series, in order.
+	Series() ([]string, error)
+	// Stops using the backend.
+	Close() error
+}
+
+// Creates a backend for a database directory.
+func newBackend(name, dir string) (Backend, error) {
+	switch name {
+	case BACKEND_SQLITE:
+		return &sqliteBackend{path: filepath.Join(dir, SQLITE_FILE_NAME)}, nil
+	case BACKEND_WAL:
+		return &walBackend{path: filepath.Join(dir, WAL_FILE_NAME)}, nil
+	}
+
+	return nil, fmt.Errorf(
+		"Unknown storage backend %q, expected one of: %s", name, strings.Join(Backends, ", "))
+}
+
+// Determines the backend of a database directory, which is whichever backend has persisted to it,
+// or otherwise the one requested. An empty request is the default backend. Returns whether or not
+// the backend has persisted to the directory.
+func detectBackend(name, dir string) (string, bool, error) {
+	for _, backend := range Backends {
+		_, err := os.Stat(filepath.Join(dir, backendFiles[backend]))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", false, err
+		}
+
+		if name != "" && name != backend {
+			return "", false, fmt.Errorf(
+				"Storage at %s uses the %q backend, not %q", dir, backend, name)
+		}
+		return backend, true, nil
+	}
+
+	if name == "" {
+		name = Backends[0]
+	}
+
+	return name, false, nil
+}
+
+// Opens the backend of a database for reading persisted results directly, without restoring
+// storage. Databases that don't exist have nothing persisted.
+func ReadBackend(location Location) (Backend, error) {
+	dir, err := location.Dir()
+	if err != nil {
+		return nil, err
+	}
+	name, _, err := detectBackend(location.Backend, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBackend(name, dir)
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Every backend is expected to behave the same.
+func TestBackends(t *testing.T) {
+	for _, name := range Backends {
+		t.Run(name, func(t *testing.T) {
+			testBackend(t, name)
+		})
+	}
+}
+
+func testBackend(t *testing.T, name string) {
+	var (
+		location = Location{Backend: name, Path: t.TempDir()}
+		start    = time.Now().Truncate(time.Hour)
+	)
+
+	// It removes exactly the results removed by retention, including those with the same time as
+	// results that are kept, e.g. rows of a table.
+	sameTime := Location{Backend: name, Path: t.TempDir()}
+	storage, err := NewStorage(true, sameTime, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		storage.PutResult("foo", Result{Key: key, Time: start, Value: key}, true)
+	}
+	if _, err := storage.Prune("foo", Retention{MaxCount: 2}); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+	storage, err = NewStorage(true, sameTime, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.GetAll("foo"); len(got) != 2 || got[0].Key != "b" || got[1].Key != "c" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"b", "c"})
+	}
+	storage.Close()
+
+	storage, err = NewStorage(true, location, Retention{MaxCount: 3}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.PutLabels("foo", []string{"a", "b"})
+	for _, i := range []int{0, 1, 2, 4, 3} {
+		storage.PutResult("foo", Result{
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Value:  "a b",
+			Values: Values{int64(i), 1.5},
+		}, true)
+	}
+
+	// It reads series, labels, and ranges of results.
+	if got, err := storage.backend.Series(); err != nil || !reflect.DeepEqual(got, []string{"foo"}) {
+		t.Errorf("Got: %v, %v Expected: %v\n", got, err, []string{"foo"})
+	}
+	if got, err := storage.backend.Labels("foo"); err != nil || !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Got: %v, %v Expected: %v\n", got, err, []string{"a", "b"})
+	}
+	if got, err := storage.backend.Range("foo", time.Time{}, time.Time{}); err != nil || len(got) != 3 {
+		t.Errorf("Got: %v, %v Expected: %v\n", got, err, 3)
+	}
+	got, err := storage.backend.Range("foo", start.Add(3*time.Second), start.Add(4*time.Second))
+	if err != nil || len(got) != 2 || got[0].Values[0] != int64(3) || got[1].Values[0] != int64(4) {
+		t.Errorf("Got: %v, %v Expected: %v\n", got, err, "results 3 and 4")
+	}
+	if _, err := storage.backend.Range("bar", time.Time{}, time.Time{}); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+
+	// It counts results, with the times of the first and last, without reading them.
+	count, first, last, err := storage.backend.Bounds("foo")
+	if err != nil || count != 3 || !first.Equal(start.Add(2*time.Second)) ||
+		!last.Equal(start.Add(4*time.Second)) {
+		t.Errorf("Got: %v, %v, %v, %v Expected: %v\n", count, first, last, err, "3 from 2s to 4s")
+	}
+	if _, _, _, err := storage.backend.Bounds("bar"); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+
+	// It archives series when labels change.
+	storage.PutLabels("foo", []string{"c"})
+	storage.Put("foo", "c", true, "c")
+	if got, err := storage.backend.Series(); err != nil ||
+		!reflect.DeepEqual(got, []string{"foo", "foo#0"}) {
+		t.Errorf("Got: %v, %v Expected: %v\n", got, err, []string{"foo", "foo#0"})
+	}
+
+	// It may be read while it is being written.
+	read, err := ReadStorage(Location{Path: location.Path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read.GetLabels("foo#0", nil); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"a", "b"})
+	}
+	if got := read.GetAll("foo"); len(got) != 1 {
+		t.Errorf("Got: %v Expected: %v\n", len(got), 1)
+	}
+	backend, err := ReadBackend(Location{Path: location.Path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := backend.Range("foo", time.Time{}, time.Time{}); err != nil || len(got) != 1 {
+		t.Errorf("Got: %v, %v Expected: %v\n", got, err, 1)
+	}
+	if got, _, _, err := backend.Bounds("foo"); err != nil || got != 1 {
+		t.Errorf("Got: %v, %v Expected: %v\n", got, err, 1)
+	}
+	backend.Close()
+	storage.Close()
+
+	// It keeps using the backend it was created with.
+	for _, other := range Backends {
+		if other == name {
+			continue
+		}
+		other := Location{Backend: other, Path: location.Path}
+		if _, err := NewStorage(true, other, Retention{}, nil); err == nil {
+			t.Errorf("Got: %v Expected: %v (%s)\n", err, "error", other.Backend)
+		}
+	}
+
+	// It restores results, labels, and rollups, including of removed results.
+	storage, err = NewStorage(true, Location{Path: location.Path}, Retention{MaxCount: 3}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.GetLabels("foo#0", nil); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"a", "b"})
+	}
+	if got := storage.GetAll("foo#0"); len(got) != 3 || got[2].Values[0] != int64(4) ||
+		got[2].Values[1] != 1.5 {
+		t.Errorf("Got: %v Expected: %v\n", got, "results 2 to 4")
+	}
+	if got := storage.GetAll("foo"); len(got) != 1 || got[0].Values[0] != "c" {
+		t.Errorf("Got: %v Expected: %v\n", got, "c")
+	}
+	for _, resolution := range []time.Duration{time.Minute, time.Hour} {
+		got := storage.GetRollups("foo#0", resolution, time.Time{}, time.Time{})
+		if len(got) != 1 || got[0].Count != 5 {
+			t.Errorf("Got: %v Expected: %v (%v)\n", got, 5, resolution)
+		}
+	}
+
+	// It persists removing results by hand.
+	if _, err := storage.Prune("foo#0", Retention{MaxCount: 2}); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+	storage, err = NewStorage(true, Location{Path: location.Path}, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.GetAll("foo#0"); len(got) != 2 {
+		t.Errorf("Got: %v Expected: %v\n", len(got), 2)
+	}
+	storage.Close()
+
+	// It removes results outside of retention as it is restored, but not their rollups.
+	storage, err = NewStorage(true, Location{Path: location.Path}, Retention{MaxCount: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if got := storage.GetAll("foo#0"); len(got) != 1 || got[0].Values[0] != int64(4) {
+		t.Errorf("Got: %v Expected: %v\n", got, 4)
+	}
+	for _, resolution := range []time.Duration{time.Minute, time.Hour} {
+		got := storage.GetRollups("foo#0", resolution, time.Time{}, time.Time{})
+		if len(got) != 1 || got[0].Count != 5 {
+			t.Errorf("Got: %v Expected: %v (%v)\n", got, 5, resolution)
+		}
+	}
+}
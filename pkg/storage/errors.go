@@ -16,3 +16,25 @@ type NaNError struct {
 func (e *NaNError) Error() string {
 	return fmt.Sprintf("Attempted to use non-numeric value in numerical context, Value: %v", e.Value)
 }
+
+// Error indicating there are no results for a query.
+type QueryNotFoundError struct {
+	// Query without results.
+	Query string
+}
+
+func (e *QueryNotFoundError) Error() string {
+	return fmt.Sprintf("No results found for query: %s", e.Query)
+}
+
+// Error indicating a database is being written by another process.
+type StorageLockedError struct {
+	// Directory of the locked database.
+	Path string
+	// Process holding the lock, or zero if unknown.
+	Pid int
+}
+
+func (e *StorageLockedError) Error() string {
+	return fmt.Sprintf("Storage is in use by another Cryptarch (pid %d): %s", e.Pid, e.Path)
+}
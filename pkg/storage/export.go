@@ -0,0 +1,266 @@
+//
+// Exporting of stored results.
+//
+// Results are exported as they are written, so exports don't need to be built up in memory first.
+// Supported formats are:
+//
+// - CSV, with a header of labels. Queries with different labels share columns for all labels.
+// - NDJSON, with one result per line, keeping values typed, along with the row of results of tables.
+// - Columnar JSON, with a list of times and a list of values per label for each query.
+
+package storage
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	EXPORT_FORMAT_COLUMNS = "columns" // Columnar JSON export.
+	EXPORT_FORMAT_CSV     = "csv"     // CSV export.
+	EXPORT_FORMAT_NDJSON  = "ndjson"  // NDJSON export.
+)
+
+var (
+	// Supported export formats.
+	ExportFormats = []string{EXPORT_FORMAT_COLUMNS, EXPORT_FORMAT_CSV, EXPORT_FORMAT_NDJSON}
+)
+
+// Selection of results to export. Zero times are unbounded.
+type ExportSelection struct {
+	EndTime   time.Time // Time of the last results to export.
+	Filters   []string  // Labels of values to export. All values are exported if empty.
+	Queries   []string  // Queries to export. All queries are exported if empty.
+	StartTime time.Time // Time of the first results to export.
+}
+
+// Result as exported to NDJSON.
+type exportRecord struct {
+	Query    string                 `json:"query"`
+	Row      string                 `json:"row,omitempty"`
+	Time     time.Time              `json:"time"`
+	Value    string                 `json:"value"`
+	Values   map[string]interface{} `json:"values"`
+	TimedOut bool                   `json:"timed_out,omitempty"`
+}
+
+// Retrieves the filters that apply to a query, i.e. those it has labels for. Queries with none of
+// the filters have nothing to export.
+func (s *Storage) exportFilters(query string, filters []string) (queryFilters []string, ok bool) {
+	for _, filter := range filters {
+		if slices.Contains((*s).Results[query].Labels, filter) {
+			queryFilters = append(queryFilters, filter)
+		}
+	}
+
+	return queryFilters, len(filters) == 0 || len(queryFilters) > 0
+}
+
+// Retrieves the labels of exported values for a query.
+func (s *Storage) exportLabels(query string, filters []string) []string {
+	if queryFilters, ok := s.exportFilters(query, filters); ok {
+		return s.getLabels(query, queryFilters)
+	}
+
+	return []string{}
+}
+
+// Writes results for a query and selection to a callback, along with the labels of exported values.
+func (s *Storage) exportQuery(
+	query string,
+	selection ExportSelection,
+	write func(labels []string, result Result) error,
+) error {
+	queryFilters, ok := s.exportFilters(query, selection.Filters)
+	if !ok {
+		return nil
+	}
+
+	results := (*s).Results[query]
+	labels := s.getLabels(query, queryFilters)
+	start, end := results.rangeBounds(selection.StartTime, selection.EndTime)
+	for _, result := range results.Results[start:end] {
+		if err := write(labels, filterResult(query, queryFilters, results.Labels, result)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writes results as CSV.
+func (s *Storage) exportCSV(w io.Writer, queries []string, selection ExportSelection) error {
+	var (
+		columns []string // Labels for columns, across all queries.
+	)
+
+	for _, query := range queries {
+		for _, label := range s.exportLabels(query, selection.Filters) {
+			if !slices.Contains(columns, label) {
+				columns = append(columns, label)
+			}
+		}
+	}
+
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(append([]string{"time", "query"}, columns...)); err != nil {
+		return err
+	}
+
+	row := make([]string, len(columns)+2)
+	for _, query := range queries {
+		err := s.exportQuery(query, selection, func(labels []string, result Result) error {
+			clear(row)
+			row[0], row[1] = result.Time.Format(time.RFC3339Nano), query
+			for i, label := range labels {
+				if value := result.Values.Get(i); value != nil {
+					row[slices.Index(columns, label)+2] = FormatValue(value)
+				}
+			}
+
+			return csvWriter.Write(row)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
+
+// Writes results as NDJSON.
+func (s *Storage) exportNDJSON(w io.Writer, queries []string, selection ExportSelection) error {
+	encoder := json.NewEncoder(w)
+
+	for _, query := range queries {
+		err := s.exportQuery(query, selection, func(labels []string, result Result) error {
+			record := exportRecord{
+				Query:    query,
+				Row:      result.Key,
+				Time:     result.Time,
+				Value:    result.Value,
+				Values:   make(map[string]interface{}, len(labels)),
+				TimedOut: result.TimedOut,
+			}
+			for i, label := range labels {
+				record.Values[label] = result.Values.Get(i)
+			}
+
+			return encoder.Encode(&record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writes results as columnar JSON, i.e. a list of queries each with a list of times and a list of
+// values for each label. Each column is written by passing over the results again.
+func (s *Storage) exportColumns(w io.Writer, queries []string, selection ExportSelection) error {
+	var (
+		err error // General error holder.
+	)
+
+	// Writes JSON values, remembering the first error.
+	write := func(values ...interface{}) {
+		for _, value := range values {
+			if err != nil {
+				return
+			}
+			switch value := value.(type) {
+			case json.RawMessage:
+				_, err = w.Write(value)
+			default:
+				var valueJson []byte
+				if valueJson, err = json.Marshal(value); err == nil {
+					_, err = w.Write(valueJson)
+				}
+			}
+		}
+	}
+	raw := func(s string) json.RawMessage { return json.RawMessage(s) }
+
+	// Writes a column of values for results.
+	writeColumn := func(query string, column func(result Result) interface{}) {
+		first := true
+		write(raw("["))
+		s.exportQuery(query, selection, func(_ []string, result Result) error {
+			if !first {
+				write(raw(","))
+			}
+			first = false
+			write(column(result))
+			return err
+		})
+		write(raw("]"))
+	}
+
+	write(raw("["))
+	for i, query := range queries {
+		labels := s.exportLabels(query, selection.Filters)
+
+		if i > 0 {
+			write(raw(","))
+		}
+		write(raw(`{"query":`), query, raw(`,"labels":`), labels, raw(`,"time":`))
+		writeColumn(query, func(result Result) interface{} { return result.Time })
+		write(raw(`,"columns":{`))
+		for j, label := range labels {
+			if j > 0 {
+				write(raw(","))
+			}
+			write(label, raw(":"))
+			writeColumn(query, func(result Result) interface{} { return result.Values.Get(j) })
+		}
+		write(raw("}}"))
+	}
+	write(raw("]\n"))
+
+	return err
+}
+
+// Exports selected results in a format, writing them as they are exported.
+func (s *Storage) Export(w io.Writer, format string, selection ExportSelection) error {
+	var (
+		err     error                // General error holder.
+		queries = selection.Queries  // Queries to export.
+		writer  = bufio.NewWriter(w) // Buffered writer for exporting.
+	)
+
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	if len(queries) == 0 {
+		queries = s.queries()
+	}
+	for _, query := range queries {
+		if _, ok := (*s).Results[query]; !ok {
+			return &QueryNotFoundError{Query: query}
+		}
+	}
+
+	switch format {
+	case EXPORT_FORMAT_COLUMNS:
+		err = s.exportColumns(writer, queries, selection)
+	case EXPORT_FORMAT_CSV:
+		err = s.exportCSV(writer, queries, selection)
+	case EXPORT_FORMAT_NDJSON:
+		err = s.exportNDJSON(writer, queries, selection)
+	default:
+		return fmt.Errorf("Unknown export format %q, expected one of: %v", format, ExportFormats)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Builds a test storage with results to export.
+func testExportStorage() Storage {
+	storage := testStorage()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	storage.PutLabels("foo", []string{"a", "b"})
+	storage.PutLabels("bar", []string{"b", "c"})
+	for i := 0; i < 3; i++ {
+		storage.PutResult("foo", Result{
+			Time: start.Add(time.Duration(i) * time.Minute), Value: "x", Values: Values{i, "x"},
+		}, false)
+	}
+	storage.PutResult("bar", Result{Time: start, Value: "1.5 y", Values: Values{1.5, "y"}}, false)
+
+	return storage
+}
+
+func TestExport(t *testing.T) {
+	storage := testExportStorage()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		format    string
+		selection ExportSelection
+		expected  string
+	}{
+		{
+			EXPORT_FORMAT_CSV,
+			ExportSelection{},
+			"time,query,b,c,a\n" +
+				"2024-01-01T00:00:00Z,bar,1.5,y,\n" +
+				"2024-01-01T00:00:00Z,foo,x,,0\n" +
+				"2024-01-01T00:01:00Z,foo,x,,1\n" +
+				"2024-01-01T00:02:00Z,foo,x,,2\n",
+		},
+		{
+			EXPORT_FORMAT_CSV,
+			ExportSelection{
+				Filters:   []string{"a"},
+				StartTime: start.Add(time.Minute),
+				EndTime:   start.Add(time.Minute),
+			},
+			"time,query,a\n" +
+				"2024-01-01T00:01:00Z,foo,1\n",
+		},
+		{
+			EXPORT_FORMAT_NDJSON,
+			ExportSelection{Queries: []string{"bar"}},
+			`{"query":"bar","time":"2024-01-01T00:00:00Z","value":"1.5 y","values":{"b":1.5,"c":"y"}}` +
+				"\n",
+		},
+		{
+			EXPORT_FORMAT_COLUMNS,
+			ExportSelection{Filters: []string{"a"}, StartTime: start.Add(time.Minute)},
+			`[{"query":"bar","labels":[],"time":[],"columns":{}},` +
+				`{"query":"foo","labels":["a"],` +
+				`"time":["2024-01-01T00:01:00Z","2024-01-01T00:02:00Z"],"columns":{"a":[1,2]}}]` +
+				"\n",
+		},
+	} {
+		var got strings.Builder
+
+		// It exports selected results.
+		if err := storage.Export(&got, test.format, test.selection); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != test.expected {
+			t.Errorf("Got: %v Expected: %v (%s)\n", got.String(), test.expected, test.format)
+		}
+		if test.format == EXPORT_FORMAT_COLUMNS && !json.Valid([]byte(got.String())) {
+			t.Errorf("Got: %v Expected: %v\n", got.String(), "valid JSON")
+		}
+	}
+
+	// It refuses unknown formats and queries.
+	if err := storage.Export(&strings.Builder{}, "xml", ExportSelection{}); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+	err := storage.Export(&strings.Builder{}, EXPORT_FORMAT_CSV, ExportSelection{Queries: []string{"fizz"}})
+	if err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+}
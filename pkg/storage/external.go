@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -27,6 +28,7 @@ const (
 	PROMETHEUS_METRICS_HELP     = "Produced by Cryptarch." // Help text for all Prometheus metrics.
 	PROMETHEUS_METRIC_LABEL     = "cryptarch_label"        // What Prometheus label to use for the Cryptarch label.
 	PROMETHEUS_METRIC_PREFIX    = "cryptarch"              // Prefix for all Prometheus metrics.
+	PROMETHEUS_METRIC_ROW_LABEL = "cryptarch_row"          // What Prometheus label to use for the row of a result.
 )
 
 var (
@@ -120,9 +122,8 @@ type PushgatewayStorage struct {
 // Add a result to Prometheus Pushgtateway.
 func (p *PushgatewayStorage) Put(query string, labels []string, result Result) error {
 	var (
-		err      error                // General error holder.
-		instance string               // Prometheus instance value.
-		metric   *prometheus.GaugeVec // Produced metric to push.
+		err      error  // General error holder.
+		instance string // Prometheus instance value.
 
 		name = normalizeString(query) // Name for the metric.
 	)
@@ -133,12 +134,11 @@ func (p *PushgatewayStorage) Put(query string, labels []string, result Result) e
 		return err
 	}
 
-	// Build the metric.
-	metric, err = resultToPromMetric(name, labels, result)
+	// Build the metrics.
+	err = resultToPromMetrics((*p).registry, name, labels, result)
 	if err != nil {
 		return err
 	}
-	(*p).registry.Register(metric)
 
 	slog.Debug("Pushing to Pushgtateway", "name", name, "result", result)
 	push.New((*p).address, PROMETHEUS_JOB).Grouping("instance", instance).Gatherer((*p).registry).Push()
@@ -167,18 +167,16 @@ type PrometheusStorage struct {
 // Register a result in a Prometheus registry.
 func (p *PrometheusStorage) Put(query string, labels []string, result Result) error {
 	var (
-		err    error                // General error holder.
-		metric *prometheus.GaugeVec // Produced metric to push.
+		err error // General error holder.
 
 		name = normalizeString(query) // Name for the metric.
 	)
 
-	// Build the metric.
-	metric, err = resultToPromMetric(name, labels, result)
+	// Build the metrics.
+	err = resultToPromMetrics((*p).registry, name, labels, result)
 	if err != nil {
 		return err
 	}
-	(*p).registry.Register(metric)
 
 	slog.Debug("Pushing to Prometheus", "name", name, "result", result)
 
@@ -247,8 +245,8 @@ func resultToElasticsearchDocument(
 	labels []string,
 	result Result,
 ) (document []byte, err error) {
-	// Payload to construct the document from, accounting for the two additional fields added.
-	var payload = make(map[string]interface{}, len(labels)+2)
+	// Payload to construct the document from, accounting for the seven additional fields added.
+	var payload = make(map[string]interface{}, len(labels)+7)
 
 	// Add fields for each value.
 	for k, v := range result.Map(labels) {
@@ -258,6 +256,13 @@ func resultToElasticsearchDocument(
 	// Add additional fields to the payload.
 	payload["timestamp"] = result.Time
 	payload["cryptarch.query"] = query
+	payload["cryptarch.duration_seconds"] = result.Duration.Seconds()
+	payload["cryptarch.exit_code"] = result.ExitCode
+	payload["cryptarch.stderr"] = result.Stderr
+	payload["cryptarch.timed_out"] = result.TimedOut
+	if result.Key != "" {
+		payload["cryptarch.row"] = result.Key
+	}
 
 	// Build the document body.
 	document, err = json.Marshal(payload)
@@ -265,31 +270,92 @@ func resultToElasticsearchDocument(
 	return
 }
 
-// Converts a result to a Prometheus metric.
-func resultToPromMetric(
+// Registers a Prometheus collector. If an equivalent collector is already registered, it is returned
+// instead so that it may continue to be updated.
+func registerPromCollector[T prometheus.Collector](
+	registry *prometheus.Registry,
+	collector T,
+) (T, error) {
+	var alreadyRegisteredErr prometheus.AlreadyRegisteredError
+
+	err := registry.Register(collector)
+	if errors.As(err, &alreadyRegisteredErr) {
+		if existing, ok := alreadyRegisteredErr.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+	}
+
+	return collector, err
+}
+
+// Records a result's metadata as Prometheus metrics in a registry.
+func resultMetaToPromMetrics(registry *prometheus.Registry, name string, result Result) error {
+	var (
+		timedOut float64 // Timeouts as a number.
+	)
+
+	if result.TimedOut {
+		timedOut = 1
+	}
+
+	for suffix, value := range map[string]float64{
+		"duration_seconds": result.Duration.Seconds(),
+		"exit_code":        float64(result.ExitCode),
+		"timed_out":        timedOut,
+	} {
+		metric, err := registerPromCollector(registry, prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf("%s_%s_%s", PROMETHEUS_METRIC_PREFIX, name, suffix),
+				Help: PROMETHEUS_METRICS_HELP,
+			},
+		))
+		if err != nil {
+			return err
+		}
+		metric.Set(value)
+	}
+
+	return nil
+}
+
+// Records a result as Prometheus metrics in a registry. Results of rows are labelled with their row.
+func resultToPromMetrics(
+	registry *prometheus.Registry,
 	name string,
 	labels []string,
 	result Result,
-) (metric *prometheus.GaugeVec, err error) {
-	metric = prometheus.NewGaugeVec(
+) (err error) {
+	var (
+		metric *prometheus.GaugeVec // Metric for result values.
+
+		metricLabels = []string{PROMETHEUS_METRIC_LABEL} // Labels of the metric.
+	)
+
+	if result.Key != "" {
+		metricLabels = append(metricLabels, PROMETHEUS_METRIC_ROW_LABEL)
+	}
+
+	// Record result metadata.
+	err = resultMetaToPromMetrics(registry, name, result)
+	if err != nil {
+		return
+	}
+
+	// Record result values.
+	metric, err = registerPromCollector(registry, prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: fmt.Sprintf("%s_%s", PROMETHEUS_METRIC_PREFIX, name),
 			Help: PROMETHEUS_METRICS_HELP,
 		},
-		[]string{PROMETHEUS_METRIC_LABEL},
-	)
+		metricLabels,
+	))
+	if err != nil {
+		return
+	}
 
 	for i, value := range result.Values {
-		switch value.(type) {
-		case int64:
-			metric.With(prometheus.Labels{
-				PROMETHEUS_METRIC_LABEL: labels[i],
-			}).Set(float64(value.(int64)))
-		case float64:
-			metric.With(prometheus.Labels{
-				PROMETHEUS_METRIC_LABEL: labels[i],
-			}).Set(value.(float64))
-		default:
+		floatValue, ok := ValueFloat(value)
+		if !ok {
 			// We encountered a value Prometheus can't digest.
 			err = &NaNError{Value: value}
 
@@ -298,6 +364,11 @@ func resultToPromMetric(
 			// `Put`.
 			break
 		}
+		valueLabels := prometheus.Labels{PROMETHEUS_METRIC_LABEL: labels[i]}
+		if result.Key != "" {
+			valueLabels[PROMETHEUS_METRIC_ROW_LABEL] = result.Key
+		}
+		metric.With(valueLabels).Set(floatValue)
 	}
 
 	return
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNormalizeString(t *testing.T) {
@@ -18,3 +21,36 @@ func TestNormalizeString(t *testing.T) {
 		}
 	}
 }
+
+func TestResultToElasticsearchDocument(t *testing.T) {
+	result := Result{
+		Time:     testTime(),
+		Value:    "1 2",
+		Values:   Values{int64(1), int64(2)},
+		Duration: 1500 * time.Millisecond,
+		ExitCode: 2,
+		Stderr:   "foo",
+	}
+
+	document, err := resultToElasticsearchDocument("query", []string{"fizz", "buzz"}, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]interface{})
+	json.Unmarshal(document, &got)
+
+	// It includes result values and metadata.
+	expected := map[string]interface{}{
+		"cryptarch.duration_seconds": 1.5,
+		"cryptarch.exit_code":        float64(2),
+		"cryptarch.query":            "query",
+		"cryptarch.stderr":           "foo",
+		"cryptarch.timed_out":        false,
+		"cryptarch.value.buzz":       float64(2),
+		"cryptarch.value.fizz":       float64(1),
+		"timestamp":                  testTime().Format(time.RFC3339Nano),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+}
@@ -0,0 +1,224 @@
+//
+// Locations of persisted storage.
+//
+// Persisted storage is kept in a directory, by default 'cryptarch' in the user cache directory. A
+// storage directory holds a default database, as well as any named databases in its 'databases'
+// directory, so that separate sets of results (e.g. for different projects) are kept apart.
+//
+// Databases are locked while they are being written, so that only one Cryptarch writes to a database
+// at a time. Others may still read a locked database, e.g. for presenting history.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	STORAGE_DB_DEFAULT = "default"      // Name of the default database.
+	STORAGE_DB_DIR     = "databases"    // Directory in storage for named databases.
+	STORAGE_DIR_MODE   = 0770           // File mode of storage directories.
+	STORAGE_LOCK_MODE  = 0660           // File mode of database locks.
+	STORAGE_LOCK_NAME  = "storage.lock" // Filename of the lock held while writing a database.
+)
+
+// Location of a database in persisted storage.
+type Location struct {
+	Backend string // Backend of the database, e.g. BACKEND_SQLITE. Empty is whichever it already uses.
+	Name    string // Name of the database. Empty is the default database.
+	Path    string // Directory of storage. Empty is 'cryptarch' in the user cache directory.
+}
+
+// Summary of a database in persisted storage.
+type DatabaseInfo struct {
+	Backend     string    // Backend of the database.
+	Bytes       int64     // Size of the database on disk.
+	First, Last time.Time // Times of the first and last results, across all series.
+	Name        string    // Name of the database.
+	Path        string    // Directory of the database.
+	Results     int       // Number of results, across all series.
+	Series      int       // Number of results series.
+}
+
+// Validates the name of a database. Names are used as directory names, so must not be paths.
+func ValidateDatabaseName(name string) error {
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("Bad database name %q, expected a name without path separators", name)
+	}
+
+	return nil
+}
+
+// Retrieves the directory of storage, regardless of database.
+func (l Location) storageDir() (string, error) {
+	if l.Path != "" {
+		return l.Path, nil
+	}
+
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(userCacheDir, STORAGE_FILE_DIR), nil
+}
+
+// Retrieves the directory of a database.
+func (l Location) Dir() (string, error) {
+	if err := ValidateDatabaseName(l.Name); err != nil {
+		return "", err
+	}
+
+	storageDir, err := l.storageDir()
+	if err != nil {
+		return "", err
+	}
+	if l.Name == "" || l.Name == STORAGE_DB_DEFAULT {
+		return storageDir, nil
+	}
+
+	return filepath.Join(storageDir, STORAGE_DB_DIR, l.Name), nil
+}
+
+// Retrieves the directory of a database, creating it if necessary.
+func (l Location) makeDir() (string, error) {
+	dir, err := l.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir, os.MkdirAll(dir, fs.FileMode(STORAGE_DIR_MODE))
+}
+
+// Locks a database directory for writing, recording the process holding the lock. Fails with a
+// StorageLockedError if another process holds the lock. The lock is held until the returned file is
+// closed, including when the process exits.
+func lockDir(dir string) (*os.File, error) {
+	lockPath := filepath.Join(dir, STORAGE_LOCK_NAME)
+	lock, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, STORAGE_LOCK_MODE)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer lock.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			pidData, _ := os.ReadFile(lockPath)
+			pid, _ := strconv.Atoi(strings.TrimSpace(string(pidData)))
+			return nil, &StorageLockedError{Path: dir, Pid: pid}
+		}
+		return nil, err
+	}
+
+	if err = lock.Truncate(0); err == nil {
+		_, err = lock.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	}
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// Summarizes a database.
+func databaseInfo(name string, location Location) (info DatabaseInfo, err error) {
+	info.Name = name
+	if info.Path, err = location.Dir(); err != nil {
+		return
+	}
+
+	if info.Backend, _, err = detectBackend("", info.Path); err != nil {
+		return
+	}
+
+	entries, err := os.ReadDir(info.Path)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return info, err
+		}
+		info.Bytes += fileInfo.Size()
+	}
+
+	storage, err := ReadStorage(location)
+	if err != nil {
+		return
+	}
+	for _, query := range storage.Queries() {
+		results := storage.GetAll(query)
+		info.Series++
+		info.Results += len(results)
+		if len(results) == 0 {
+			continue
+		}
+		if info.First.IsZero() || results[0].Time.Before(info.First) {
+			info.First = results[0].Time
+		}
+		if results[len(results)-1].Time.After(info.Last) {
+			info.Last = results[len(results)-1].Time
+		}
+	}
+
+	return
+}
+
+// Lists databases in a storage directory, the default database first and then named databases in
+// order. An empty path is the default storage directory.
+func ListDatabases(path string) ([]DatabaseInfo, error) {
+	var (
+		databases []DatabaseInfo // Summaries of databases.
+		names     []string       // Names of named databases.
+	)
+
+	storageDir, err := Location{Path: path}.storageDir()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(storageDir); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(storageDir, STORAGE_DB_DIR))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && ValidateDatabaseName(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	info, err := databaseInfo(STORAGE_DB_DEFAULT, Location{Path: path})
+	if err != nil {
+		return nil, err
+	}
+	databases = append(databases, info)
+	for _, name := range names {
+		info, err := databaseInfo(name, Location{Name: name, Path: path})
+		if err != nil {
+			return nil, err
+		}
+		databases = append(databases, info)
+	}
+
+	return databases, nil
+}
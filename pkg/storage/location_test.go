@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocationDir(t *testing.T) {
+	path := t.TempDir()
+
+	for _, test := range []struct {
+		location Location
+		expected string
+	}{
+		{Location{Path: path}, path},
+		{Location{Name: STORAGE_DB_DEFAULT, Path: path}, path},
+		{Location{Name: "foo", Path: path}, filepath.Join(path, STORAGE_DB_DIR, "foo")},
+	} {
+		if got, err := test.location.Dir(); got != test.expected || err != nil {
+			t.Errorf("Got: %v, %v Expected: %v\n", got, err, test.expected)
+		}
+	}
+
+	// It rejects names that are paths.
+	for _, name := range []string{"..", "foo/bar"} {
+		if _, err := (Location{Name: name, Path: path}).Dir(); err == nil {
+			t.Errorf("Got: %v Expected: %v (%s)\n", err, "error", name)
+		}
+	}
+}
+
+func TestNewStorageLock(t *testing.T) {
+	location := Location{Path: t.TempDir()}
+
+	storage, err := NewStorage(true, location, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It refuses to write a database that is in use, but not another database.
+	var lockedErr *StorageLockedError
+	if _, err = NewStorage(true, location, Retention{}, nil); !errors.As(err, &lockedErr) ||
+		lockedErr.Pid != os.Getpid() {
+		t.Errorf("Got: %v Expected: %v\n", err, "locked by this process")
+	}
+	other, err := NewStorage(true, Location{Name: "foo", Path: location.Path}, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+
+	// It writes the database again once closed.
+	storage.Close()
+	storage, err = NewStorage(true, location, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+}
+
+func TestListDatabases(t *testing.T) {
+	path := t.TempDir()
+
+	for _, name := range []string{"", "foo", "bar"} {
+		storage, err := NewStorage(true, Location{Name: name, Path: path}, Retention{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "" {
+			storage.PutResult(name, Result{Time: testTime(), Value: "a"}, true)
+			storage.PutResult("fizz", Result{Time: testTime().AddDate(0, 0, 1), Value: "b"}, true)
+		}
+		storage.Close()
+	}
+
+	// It lists the default database first and then named databases, with what they hold.
+	databases, err := ListDatabases(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(databases) != 3 {
+		t.Fatalf("Got: %v Expected: %v\n", len(databases), 3)
+	}
+	for i, name := range []string{STORAGE_DB_DEFAULT, "bar", "foo"} {
+		if databases[i].Name != name {
+			t.Errorf("Got: %v Expected: %v\n", databases[i].Name, name)
+		}
+	}
+	if databases[0].Series != 0 || databases[0].Results != 0 {
+		t.Errorf("Got: %v Expected: %v\n", databases[0], "no results")
+	}
+	if got := databases[1]; got.Series != 2 || got.Results != 2 || got.Bytes == 0 ||
+		!got.First.Equal(testTime()) || !got.Last.Equal(testTime().AddDate(0, 0, 1)) {
+		t.Errorf("Got: %v Expected: %v\n", got, "two results a day apart")
+	}
+
+	// It lists nothing when nothing is stored.
+	if databases, err = ListDatabases(filepath.Join(path, "missing")); len(databases) != 0 || err != nil {
+		t.Errorf("Got: %v, %v Expected: %v\n", databases, err, "nothing")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	_ "log/slog"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
@@ -32,6 +33,15 @@ type Result struct {
 	Time   time.Time // Time the result was created.
 	Value  string    // Raw value of the result.
 	Values Values    // Tokenized value of the result.
+
+	// Key of the row the result is for, for queries producing tables of results.
+	Key string `json:",omitempty"`
+
+	// Metadata about how the result was produced.
+	Duration time.Duration `json:",omitempty"` // How long the query took to produce the result.
+	ExitCode int           `json:",omitempty"` // Exit code of the query, if it was a command.
+	Stderr   string        `json:",omitempty"` // Error output of the query, if it was a command.
+	TimedOut bool          `json:",omitempty"` // Whether the query timed out before producing the result.
 }
 
 // Determines whether this is an empty result.
@@ -44,49 +54,143 @@ func (r *Result) IsEmptyValues() bool {
 	return (*r).Value == "" && len((*r).Values) == 0
 }
 
-// Returns a map of values keyed to their labels.
+// Returns a map of values keyed to their labels. Values without a label are keyed by their index.
 func (r *Result) Map(labels []string) map[string]interface{} {
 	resultMap := make(map[string]interface{}, len(r.Values))
 	for i, value := range r.Values {
-		resultMap[labels[i]] = value
+		if i < len(labels) {
+			resultMap[labels[i]] = value
+		} else {
+			resultMap[strconv.Itoa(i)] = value
+		}
 	}
 
 	return resultMap
 }
 
-// Collection of results.
+// Collection of results. Queries producing tables have a result for each row, keyed by row, which
+// are also kept as a sub-series for each row.
 type Results struct {
 	// Meta field for result values acting as a name, corresponding by index. In the event that no
 	// explicit labels are defined, the indexes are the labels.
 	Labels []string
 	// Stored results.
 	Results []Result
+
+	// Generation of the series, which increases whenever labels change in a way that would mislabel
+	// previous results.
+	Generation int `json:",omitempty"`
+
+	pruned  int                 // Number of results removed by retention, for keeping indexes stable.
+	rollups []rollupSeries      // Summaries of results at coarser resolutions.
+	rows    map[string]*Results // Sub-series of results for each row, keyed by row.
+	seqs    []int               // Sequence of each result by when it was put, for readers.
+	size    int64               // Approximate size of results when persisted.
+}
+
+// Finds the index of the first result at or after a time, or the number of results if there is none.
+func (r *Results) searchAt(t time.Time) int {
+	return sort.Search(len((*r).Results), func(i int) bool {
+		return !(*r).Results[i].Time.Before(t)
+	})
+}
+
+// Finds the index of the first result after a time, or the number of results if there is none.
+func (r *Results) searchAfter(t time.Time) int {
+	return sort.Search(len((*r).Results), func(i int) bool {
+		return (*r).Results[i].Time.After(t)
+	})
+}
+
+// Retrieves the bounds of results between two times, inclusive, where zero times are unbounded.
+func (r *Results) rangeBounds(startTime, endTime time.Time) (start, end int) {
+	start, end = 0, len((*r).Results)
+
+	if !startTime.IsZero() {
+		start = r.searchAt(startTime)
+	}
+	if !endTime.IsZero() {
+		end = max(r.searchAfter(endTime), start)
+	}
+
+	return
 }
 
 // Get a result based on a timestamp.
 func (r *Results) get(time time.Time) Result {
-	for _, result := range (*r).Results {
-		if result.Time.Compare(time) == 0 {
-			// We found a result to return.
-			return result
-		}
+	if i := r.searchAt(time); i < len((*r).Results) && (*r).Results[i].Time.Equal(time) {
+		return (*r).Results[i]
 	}
 
 	// Return an empty result if nothing was discovered.
 	return Result{}
 }
 
-// Gets results based on a start and end timestamp.
-func (r *Results) getRange(startTime time.Time, endTime time.Time) (found []Result) {
-	for _, result := range (*r).Results {
-		if result.Time.Compare(startTime) >= 0 {
-			if result.Time.Compare(endTime) > 0 {
-				// Break out of the loop if we've exhausted the upper bounds of the range.
-				break
-			} else {
-				found = append(found, result)
-			}
+// Gets the nearest result after a timestamp.
+func (r *Results) getAfter(time time.Time) Result {
+	if i := r.searchAfter(time); i < len((*r).Results) {
+		return (*r).Results[i]
+	}
+
+	return Result{}
+}
+
+// Gets the result at or nearest before a timestamp, i.e. the result that was current at that time.
+// This is useful for aligning results from different queries.
+func (r *Results) getAtOrBefore(time time.Time) Result {
+	if i := r.searchAfter(time); i > 0 {
+		return (*r).Results[i-1]
+	}
+
+	return Result{}
+}
+
+// Gets the nearest result before a timestamp.
+func (r *Results) getBefore(time time.Time) Result {
+	if i := r.searchAt(time); i > 0 {
+		return (*r).Results[i-1]
+	}
+
+	return Result{}
+}
+
+// Gets results based on a start and end timestamp, inclusive. Zero times are unbounded. Results are
+// shared with storage, but appending to them will not modify it.
+func (r *Results) getRange(startTime time.Time, endTime time.Time) []Result {
+	start, end := r.rangeBounds(startTime, endTime)
+	return (*r).Results[start:end:end]
+}
+
+// Gets results at steps between a start and end timestamp, inclusive, e.g. one every minute. Each is
+// the result at or before its step, given the step's time, so results from different queries line
+// up. Steps before the first result are skipped. Zero times are the times of the first and last
+// results.
+func (r *Results) getStep(startTime, endTime time.Time, step time.Duration) (found []Result) {
+	var (
+		next int // Index of the first result after the previous step.
+	)
+
+	if len((*r).Results) == 0 || step <= 0 {
+		return
+	}
+	if startTime.IsZero() {
+		startTime = (*r).Results[0].Time
+	}
+	if endTime.IsZero() {
+		endTime = (*r).Results[len((*r).Results)-1].Time
+	}
+
+	for stepTime := startTime; !stepTime.After(endTime); stepTime = stepTime.Add(step) {
+		// Steps only move forward, so only search results after the previous step.
+		rest := (*r).Results[next:]
+		next += sort.Search(len(rest), func(i int) bool { return rest[i].Time.After(stepTime) })
+		if next == 0 {
+			continue
 		}
+
+		result := (*r).Results[next-1]
+		result.Time = stepTime
+		found = append(found, result)
 	}
 
 	return
@@ -97,19 +201,152 @@ func (r *Results) getValueIndex(filter string) int {
 	return slices.Index((*r).Labels, filter)
 }
 
+// Determines whether labels are the default ones, i.e. indexes, rather than explicit ones.
+func (r *Results) hasDefaultLabels() bool {
+	for i, label := range (*r).Labels {
+		if label != strconv.Itoa(i) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Put a new compound result.
 func (r *Results) put(value string, values ...interface{}) Result {
-	next := Result{
-		Time:   time.Now(),
+	return r.putResult(Result{
 		Value:  value,
 		Values: values,
+	})
+}
+
+// Put a pre-built result. If the result has no time, it is given the current time. Results are kept
+// in time order, so a result older than others is inserted before them, which moves the indexes of
+// later results. Results of rows are also put in the sub-series for their row, which is rolled up
+// instead.
+func (r *Results) putResult(next Result) Result {
+	if next.Time.IsZero() {
+		next.Time = time.Now()
 	}
 
-	(*r).Results = append((*r).Results, next)
+	r.insert(next)
+	(*r).size += resultSize(next)
+	if next.Key != "" {
+		r.putRow(next)
+	} else {
+		r.rollup(next)
+	}
 
 	return next
 }
 
+// Retrieves the sequence of the next result put. Sequences count every result put, including those
+// removed by retention, so unlike indexes they don't move when results are inserted before others.
+func (r *Results) nextSeq() int {
+	return (*r).pruned + len((*r).Results)
+}
+
+// Retrieves the sequence of a result by its index. Results that were never numbered, e.g. those
+// built outside of storage, are in the order they were put.
+func (r *Results) seq(i int) int {
+	if len((*r).seqs) != len((*r).Results) {
+		return (*r).pruned + i
+	}
+
+	return (*r).seqs[i]
+}
+
+// Inserts a result in time order, along with its sequence.
+func (r *Results) insert(next Result) {
+	r.number()
+	seq := r.nextSeq()
+	if last := len((*r).Results) - 1; last < 0 || !next.Time.Before((*r).Results[last].Time) {
+		(*r).Results = append((*r).Results, next)
+		(*r).seqs = append((*r).seqs, seq)
+	} else {
+		// Results with the same time keep the order they were put in.
+		i := r.searchAfter(next.Time)
+		(*r).Results = slices.Insert((*r).Results, i, next)
+		(*r).seqs = slices.Insert((*r).seqs, i, seq)
+	}
+}
+
+// Numbers results that were never numbered, e.g. those built outside of storage.
+func (r *Results) number() {
+	if len((*r).seqs) != len((*r).Results) {
+		r.renumber()
+	}
+}
+
+// Numbers results in order, for when results are replaced.
+func (r *Results) renumber() {
+	(*r).seqs = make([]int, len((*r).Results))
+	for i := range (*r).seqs {
+		(*r).seqs[i] = (*r).pruned + i
+	}
+}
+
+// Puts a result of a row in the sub-series for its row, creating it if needed.
+func (r *Results) putRow(next Result) {
+	// Rollups of rows are read along with those of the series.
+	r.initRollups()
+	if (*r).rows == nil {
+		(*r).rows = make(map[string]*Results)
+	}
+	row, ok := (*r).rows[next.Key]
+	if !ok {
+		row = &Results{}
+		(*r).rows[next.Key] = row
+	}
+
+	row.insert(next)
+	row.rollup(next)
+}
+
+// Rebuilds the sub-series of rows, for results that weren't put individually.
+func (r *Results) indexRows() {
+	(*r).rows = nil
+	for _, result := range (*r).Results {
+		if result.Key != "" {
+			r.putRow(result)
+		}
+	}
+}
+
+// Retrieves the keys of rows, in order.
+func (r *Results) getRows() (keys []string) {
+	for key := range (*r).rows {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return
+}
+
+// Removes the oldest results of rows, for results removed from the series. Rows with nothing left
+// are removed.
+func (r *Results) pruneRows(removed []Result) {
+	for _, result := range removed {
+		row, ok := (*r).rows[result.Key]
+		if !ok || len(row.Results) == 0 {
+			continue
+		}
+		row.number()
+		row.Results, row.seqs = row.Results[1:], row.seqs[1:]
+		if len(row.Results) == 0 && row.rollupCount() == 0 {
+			delete((*r).rows, result.Key)
+		}
+	}
+}
+
+// Sorts results by time, keeping the order of results with the same time.
+func (r *Results) sort() {
+	sortByTime := func(a, b Result) int { return a.Time.Compare(b.Time) }
+	if !slices.IsSortedFunc((*r).Results, sortByTime) {
+		slices.SortStableFunc((*r).Results, sortByTime)
+	}
+}
+
 // Show all currently stored results.
 func (r *Results) show() {
 	for _, result := range (*r).Results {
@@ -8,8 +8,7 @@ import (
 
 // Builds a test time stamp.
 func testTime() time.Time {
-	testTime, _ := time.Parse(time.ANSIC, time.Stamp)
-	return testTime
+	return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
 }
 
 // Build a test storage.
@@ -131,3 +130,175 @@ func TestResultsPut(t *testing.T) {
 		}
 	}
 }
+
+func TestResultsGetNearest(t *testing.T) {
+	results := testResults()
+	first, second := results.Results[0], results.Results[1]
+
+	for _, test := range []struct {
+		name     string
+		get      func(time.Time) Result
+		time     time.Time
+		expected Result
+	}{
+		// It gets the nearest result after a time, but not at it.
+		{"after", results.getAfter, testTime().Add(-time.Second), first},
+		{"after", results.getAfter, testTime(), second},
+		{"after", results.getAfter, testTime().Add(time.Second * 30), Result{}},
+		// It gets the nearest result before a time, but not at it.
+		{"before", results.getBefore, testTime(), Result{}},
+		{"before", results.getBefore, testTime().Add(time.Second * 15), first},
+		{"before", results.getBefore, testTime().Add(time.Second * 30), first},
+		// It gets the result current at a time.
+		{"at or before", results.getAtOrBefore, testTime().Add(-time.Second), Result{}},
+		{"at or before", results.getAtOrBefore, testTime(), first},
+		{"at or before", results.getAtOrBefore, testTime().Add(time.Second * 15), first},
+		{"at or before", results.getAtOrBefore, testTime().Add(time.Minute), second},
+	} {
+		if got := test.get(test.time); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Got: %v Expected: %v (%s %v)\n", got, test.expected, test.name, test.time)
+		}
+	}
+}
+
+func TestResultsGetStep(t *testing.T) {
+	results := testResults()
+
+	// It gets the result current at each step, timed at the step, skipping steps before any results.
+	got := results.getStep(testTime().Add(-time.Second*20), testTime().Add(time.Second*40), time.Second*20)
+	expected := []Result{
+		{Time: testTime(), Value: "foo"},
+		{Time: testTime().Add(time.Second * 20), Value: "foo"},
+		{Time: testTime().Add(time.Second * 40), Value: "bar"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It steps between the first and last results for zero times.
+	got = results.getStep(time.Time{}, time.Time{}, time.Second*10)
+	if len(got) != 4 || got[3].Value != "bar" {
+		t.Errorf("Got: %v Expected: %v\n", len(got), 4)
+	}
+}
+
+func TestResultsPutOutOfOrder(t *testing.T) {
+	results := testResults()
+
+	// It keeps results in time order, with results at the same time in the order they were put.
+	results.putResult(Result{Time: testTime().Add(time.Second * 15), Value: "fizz"})
+	results.putResult(Result{Time: testTime(), Value: "buzz"})
+	results.putResult(Result{Time: testTime().Add(-time.Second), Value: "bazz"})
+	expected := []string{"bazz", "foo", "buzz", "fizz", "bar"}
+	for i, result := range results.Results {
+		if result.Value != expected[i] {
+			t.Errorf("Got: %v Expected: %v\n", result.Value, expected[i])
+		}
+	}
+
+	// It sorts unordered results.
+	results.Results[0], results.Results[4] = results.Results[4], results.Results[0]
+	results.sort()
+	for i, result := range results.Results {
+		if result.Value != expected[i] {
+			t.Errorf("Got: %v Expected: %v\n", result.Value, expected[i])
+		}
+	}
+}
+
+func TestResultsRows(t *testing.T) {
+	results := newResults(1)
+	for i := 0; i < 6; i++ {
+		for _, key := range []string{"/home", "/"} {
+			results.putResult(Result{
+				Time:   testTime().Add(time.Duration(i) * 30 * time.Second),
+				Key:    key,
+				Values: Values{int64(i)},
+			})
+		}
+	}
+
+	// It keeps a sub-series for each row, along with every result in the series.
+	if expected := []string{"/", "/home"}; !reflect.DeepEqual(results.getRows(), expected) {
+		t.Errorf("Got: %v Expected: %v\n", results.getRows(), expected)
+	}
+	if got := len(results.Results); got != 12 {
+		t.Errorf("Got: %v Expected: %v\n", got, 12)
+	}
+	for _, key := range results.getRows() {
+		if got := len(results.rows[key].Results); got != 6 {
+			t.Errorf("Got: %v Expected: %v (%s)\n", got, 6, key)
+		}
+	}
+
+	// It rolls up rows separately.
+	got, resolution := results.getResolved(time.Time{}, time.Time{}, 6, ROLLUP_AVG)
+	if resolution != time.Minute || len(got) != 6 || got[0].Key != "/" || got[1].Key != "/home" {
+		t.Errorf("Got: %v, %v Expected: %v, %v\n", resolution, got, time.Minute, 6)
+	}
+
+	// It removes results of rows along with the series.
+	results.prune(Retention{MaxCount: 2}, testTime())
+	for _, key := range results.getRows() {
+		if got := len(results.rows[key].Results); got != 1 {
+			t.Errorf("Got: %v Expected: %v (%s)\n", got, 1, key)
+		}
+	}
+
+	// It rebuilds rows for results that weren't put individually.
+	results.rows = nil
+	results.indexRows()
+	if expected := []string{"/", "/home"}; !reflect.DeepEqual(results.getRows(), expected) {
+		t.Errorf("Got: %v Expected: %v\n", results.getRows(), expected)
+	}
+}
+
+// Builds results with a million results, a second apart.
+func benchmarkResults(b *testing.B) *Results {
+	results := newResults(1)
+	results.Results = make([]Result, 1000000)
+	for i := range results.Results {
+		results.Results[i] = Result{Time: testTime().Add(time.Duration(i) * time.Second), Value: "a"}
+	}
+	b.ResetTimer()
+
+	return &results
+}
+
+func BenchmarkResultsGet(b *testing.B) {
+	results := benchmarkResults(b)
+	for i := 0; i < b.N; i++ {
+		results.get(testTime().Add(time.Duration(i%1000000) * time.Second))
+	}
+}
+
+func BenchmarkResultsGetAtOrBefore(b *testing.B) {
+	results := benchmarkResults(b)
+	for i := 0; i < b.N; i++ {
+		results.getAtOrBefore(testTime().Add(time.Duration(i%1000000)*time.Second + time.Millisecond))
+	}
+}
+
+func BenchmarkResultsGetRange(b *testing.B) {
+	results := benchmarkResults(b)
+	for i := 0; i < b.N; i++ {
+		start := testTime().Add(time.Duration(i%999000) * time.Second)
+		results.getRange(start, start.Add(time.Hour))
+	}
+}
+
+func BenchmarkResultsGetStep(b *testing.B) {
+	results := benchmarkResults(b)
+	for i := 0; i < b.N; i++ {
+		// A day of results at one per minute.
+		start := testTime().Add(time.Duration(i%900000) * time.Second)
+		results.getStep(start, start.Add(24*time.Hour), time.Minute)
+	}
+}
+
+func BenchmarkResultsPutOutOfOrder(b *testing.B) {
+	results := benchmarkResults(b)
+	for i := 0; i < b.N; i++ {
+		results.putResult(Result{Time: testTime().Add(time.Duration(i%1000000) * time.Second), Value: "a"})
+	}
+}
@@ -0,0 +1,118 @@
+//
+// Retention of stored results.
+//
+// Results series may be limited by the number of results, the age of results, and the size of
+// results. Sizes are approximated from the JSON encoding of results, so they don't account for how
+// a backend frames, compacts, or pages results, nor for rollups. Limits apply to each series, and
+// results outside of any limit are removed oldest first. The most recent result of a series is
+// always kept. Rollups of results are limited separately, so they may be kept for longer than
+// results.
+
+package storage
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Retention policy for results series. Zero values are unlimited.
+type Retention struct {
+	MaxAge         time.Duration // Maximum age of results.
+	MaxResultBytes int64         // Maximum size of results, approximated from their JSON.
+	MaxCount       int           // Maximum number of results.
+	RollupMaxAge   time.Duration // Maximum age of rollups, in addition to their own policies.
+}
+
+// Determines whether or not a retention policy has any limits.
+func (r Retention) IsZero() bool {
+	return r == Retention{}
+}
+
+// Approximates the size of a result when persisted.
+func resultSize(result Result) int64 {
+	resultJson, _ := json.Marshal(result)
+
+	return int64(len(resultJson))
+}
+
+// Recomputes the size of results, for when results are replaced.
+func (r *Results) resize() {
+	(*r).size = 0
+	for _, result := range (*r).Results {
+		(*r).size += resultSize(result)
+	}
+}
+
+// Removes results outside of a retention policy, returning how many were removed.
+func (r *Results) prune(retention Retention, now time.Time) (pruned int) {
+	if retention.IsZero() {
+		return
+	}
+
+	// The most recent result is never removed, even when it is outside the policy, so that a series
+	// always has a latest result.
+	for ; pruned < len((*r).Results)-1; pruned++ {
+		result := (*r).Results[pruned]
+
+		if !(retention.MaxCount > 0 && len((*r).Results)-pruned > retention.MaxCount) &&
+			!(retention.MaxAge > 0 && now.Sub(result.Time) > retention.MaxAge) &&
+			!(retention.MaxResultBytes > 0 && (*r).size > retention.MaxResultBytes) {
+			// Everything left is within the policy.
+			break
+		}
+		(*r).size -= resultSize(result)
+	}
+	r.pruneRows((*r).Results[:pruned])
+	r.number()
+	(*r).Results, (*r).seqs = (*r).Results[pruned:], (*r).seqs[pruned:]
+	(*r).pruned += pruned
+
+	return
+}
+
+// Retrieves the retention policy for a query.
+func (s *Storage) retentionFor(query string) Retention {
+	if retention, ok := (*s).queryRetentions[query]; ok {
+		return retention
+	}
+
+	return (*s).retention
+}
+
+// Applies retention to a results series and its rollups, returning how many results were removed.
+func (s *Storage) prune(query string) int {
+	var (
+		now       = time.Now()            // Time to apply retention at.
+		retention = s.retentionFor(query) // Retention for the query.
+	)
+
+	results, ok := (*s).Results[query]
+	if !ok {
+		return 0
+	}
+	results.pruneRollups(retention.RollupMaxAge, now)
+
+	return results.prune(retention, now)
+}
+
+// Removes results from a series according to a retention policy, regardless of the retention that
+// storage is using. Persisted storage is pruned afterwards so removed results are no longer on
+// disk. Returns how many results were removed.
+func (s *Storage) Prune(query string, retention Retention) (pruned int, err error) {
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
+
+	results, ok := (*s).Results[query]
+	if !ok {
+		return 0, &QueryNotFoundError{Query: query}
+	}
+
+	rollups := results.rollupCount()
+	results.pruneRollups(retention.RollupMaxAge, time.Now())
+	pruned = results.prune(retention, time.Now())
+	if (pruned > 0 || results.rollupCount() < rollups) && (*s).backend != nil {
+		err = (*s).backend.Prune(query)
+	}
+
+	return
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResultsPrune(t *testing.T) {
+	now := time.Now()
+
+	// Builds results, each a minute apart, ending now.
+	testPruneResults := func() *Results {
+		results := newResults(1)
+		for i := 4; i >= 0; i-- {
+			results.putResult(Result{Time: now.Add(-time.Duration(i) * time.Minute), Value: "a"})
+		}
+		return &results
+	}
+
+	for _, test := range []struct {
+		retention Retention
+		expected  int
+	}{
+		{Retention{}, 5},
+		{Retention{MaxCount: 2}, 2},
+		{Retention{MaxAge: 90 * time.Second}, 2},
+		{Retention{MaxResultBytes: 2 * resultSize(Result{Time: now, Value: "a"})}, 2},
+		{Retention{MaxAge: time.Second, MaxCount: 3}, 1},
+	} {
+		results := testPruneResults()
+		pruned := results.prune(test.retention, now)
+
+		// It removes results outside the retention, oldest first.
+		if got := len(results.Results); got != test.expected || pruned != 5-test.expected {
+			t.Errorf("Got: %v Expected: %v (%+v)\n", got, test.expected, test.retention)
+		}
+		if got := results.Results[len(results.Results)-1].Time; !got.Equal(now) {
+			t.Errorf("Got: %v Expected: %v\n", got, now)
+		}
+	}
+
+	// It always keeps the most recent result, even when it is outside the retention.
+	for _, retention := range []Retention{
+		{MaxResultBytes: 1},
+		{MaxAge: time.Second, MaxResultBytes: 1},
+	} {
+		results := testPruneResults()
+		pruned := results.prune(retention, now.Add(time.Hour))
+		if got := len(results.Results); got != 1 || pruned != 4 {
+			t.Errorf("Got: %v Expected: %v (%+v)\n", got, 1, retention)
+		}
+		if got := results.Results[0].Time; !got.Equal(now) {
+			t.Errorf("Got: %v Expected: %v\n", got, now)
+		}
+	}
+}
+
+func TestStorageRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), WAL_FILE_NAME)
+
+	// It enforces retention when putting results, with queries having their own retention.
+	storage, w := testWal(t, path)
+	storage.retention = Retention{MaxCount: 2}
+	storage.queryRetentions = map[string]Retention{"bar": {MaxCount: 3}}
+	for i := 0; i < 5; i++ {
+		storage.Put("foo", "a", true, "a")
+		storage.Put("bar", "a", true, "a")
+	}
+	if got := len(storage.GetAll("foo")); got != 2 {
+		t.Errorf("Got: %v Expected: %v\n", got, 2)
+	}
+	if got := len(storage.GetAll("bar")); got != 3 {
+		t.Errorf("Got: %v Expected: %v\n", got, 3)
+	}
+	w.close()
+
+	// It enforces retention when loading results.
+	restored := testStorage()
+	restored.retention = Retention{MaxCount: 1}
+	w, err := openWal(path, &restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(restored.GetAll("bar")); got != 1 {
+		t.Errorf("Got: %v Expected: %v\n", got, 1)
+	}
+
+	// It prunes by hand, regardless of retention.
+	restored.backend = &walBackend{path: path, storage: &restored, wal: w}
+	if pruned, err := restored.Prune("foo", Retention{MaxAge: time.Nanosecond}); err != nil || pruned != 0 {
+		t.Errorf("Got: %v Expected: %v\n", pruned, 0)
+	}
+	if _, err := restored.Prune("fizz", Retention{MaxCount: 1}); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+	w.close()
+}
+
+func TestStorageRPCGetFromPruned(t *testing.T) {
+	storage := testStorage()
+	storage.retention = Retention{MaxCount: 2}
+	storageRPC := NewStorageRPC(&storage)
+	for _, value := range []string{"a", "b", "c"} {
+		storage.Put("foo", value, false)
+	}
+
+	// Indexes stay stable as results are removed.
+	reply := ResultsRPC{}
+	storageRPC.GetFrom(&ArgsRPC{Query: "foo", Index: 2}, &reply)
+	if len(reply.Results) != 1 || reply.Results[0].Value != "c" || reply.Index != 3 {
+		t.Errorf("Got: %v Expected: %v\n", reply, "c")
+	}
+}
+
+func TestStorageGetToIndexPruned(t *testing.T) {
+	storage := testStorage()
+	storage.retention = Retention{MaxCount: 2}
+	storageRPC := NewStorageRPC(&storage)
+	for _, value := range []string{"a", "b", "c"} {
+		storage.Put("foo", value, false)
+	}
+
+	// Reader indexes count results removed by retention, as for RPC.
+	reader := storage.NewReaderIndex("foo")
+	reply := ResultsRPC{}
+	storageRPC.GetFrom(&ArgsRPC{Query: "foo", Index: 0}, &reply)
+	if int(*reader) != reply.Index {
+		t.Errorf("Got: %v Expected: %v\n", *reader, reply.Index)
+	}
+
+	// It reads results up to an index after results are removed.
+	storage.Put("foo", "d", false)
+	reader.Set(3)
+	got := storage.GetToIndex("foo", []string{}, reader)
+	if len(got) != 2 || got[0].Value != "c" || got[1].Value != "d" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"c", "d"})
+	}
+	reader.Set(2)
+	if got := storage.GetToIndex("foo", []string{}, reader); len(got) != 1 || got[0].Value != "c" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"c"})
+	}
+}
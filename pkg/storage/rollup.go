@@ -0,0 +1,382 @@
+//
+// Rollups of stored results.
+//
+// Results series are summarized at coarser resolutions as results are stored, e.g. every minute and
+// every hour, so that long-lived series may still be read across long times once raw results have
+// been removed by retention. Each rollup summarizes numeric values by their minimum, maximum, and
+// average, and all values by their last. Reads pick the finest resolution that covers the time being
+// read without returning too many results.
+
+package storage
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	ROLLUP_AVG  = "avg"  // Rollups read as the average of numeric values.
+	ROLLUP_LAST = "last" // Rollups read as the last values.
+	ROLLUP_MAX  = "max"  // Rollups read as the maximum of numeric values.
+	ROLLUP_MIN  = "min"  // Rollups read as the minimum of numeric values.
+)
+
+var (
+	// Supported ways of reading rollups.
+	RollupAggregates = []string{ROLLUP_AVG, ROLLUP_LAST, ROLLUP_MAX, ROLLUP_MIN}
+	// Resolutions that rollups are kept at, from finest to coarsest. Finer rollups are kept for less
+	// time, so that long-lived series stay small.
+	RollupPolicies = []RollupPolicy{
+		{MaxAge: 7 * 24 * time.Hour, Resolution: time.Minute},
+		{Resolution: time.Hour},
+	}
+)
+
+// Policy for keeping rollups at a resolution.
+type RollupPolicy struct {
+	MaxAge     time.Duration // Maximum age of rollups. Zero is unlimited.
+	Resolution time.Duration // Span of time each rollup summarizes.
+}
+
+// Summary of results over a span of time. Values are summarized by index, like labels.
+type Rollup struct {
+	Count  int       // Number of results summarized.
+	Counts []int     // Number of numeric values summarized, for each value.
+	Last   Values    // Values of the last result summarized.
+	Max    []float64 // Maximum of numeric values, for each value.
+	Min    []float64 // Minimum of numeric values, for each value.
+	Sum    []float64 // Sum of numeric values, for each value.
+	Time   time.Time // Start of the span of time.
+}
+
+// Rollups for a results series at a resolution.
+type rollupSeries struct {
+	policy  RollupPolicy // Policy the rollups are kept by.
+	rollups []Rollup     // Rollups, in time order.
+}
+
+// Converts a numeric value to a float for summarizing. Values that aren't numeric, or aren't finite,
+// can't be.
+func rollupFloat(value interface{}) (float64, bool) {
+	floatValue, ok := ValueFloat(value)
+	if !ok || math.IsNaN(floatValue) || math.IsInf(floatValue, 0) {
+		return 0, false
+	}
+
+	return floatValue, true
+}
+
+// Adds a result to a rollup.
+func (r *Rollup) add(result Result) {
+	(*r).Count++
+	(*r).Last = result.Values
+
+	for len((*r).Counts) < len(result.Values) {
+		(*r).Counts = append((*r).Counts, 0)
+		(*r).Max = append((*r).Max, 0)
+		(*r).Min = append((*r).Min, 0)
+		(*r).Sum = append((*r).Sum, 0)
+	}
+	for i, value := range result.Values {
+		value, ok := rollupFloat(value)
+		if !ok {
+			continue
+		}
+
+		if (*r).Counts[i] == 0 || value > (*r).Max[i] {
+			(*r).Max[i] = value
+		}
+		if (*r).Counts[i] == 0 || value < (*r).Min[i] {
+			(*r).Min[i] = value
+		}
+		(*r).Counts[i]++
+		(*r).Sum[i] += value
+	}
+}
+
+// Reads a rollup as a result, timed at the start of its span of time. Numeric values are read by an
+// aggregate, e.g. ROLLUP_AVG, keeping the unit of quantities, and other values are read as the last
+// ones.
+func (r *Rollup) Result(aggregate string) Result {
+	var (
+		value  = make([]string, len((*r).Last)) // Raw value of the result.
+		values = make(Values, len((*r).Last))   // Tokenized value of the result.
+	)
+
+	for i := range values {
+		values[i] = (*r).Last[i]
+		if i < len((*r).Counts) && (*r).Counts[i] > 0 {
+			switch aggregate {
+			case ROLLUP_AVG:
+				values[i] = (*r).Sum[i] / float64((*r).Counts[i])
+			case ROLLUP_MAX:
+				values[i] = (*r).Max[i]
+			case ROLLUP_MIN:
+				values[i] = (*r).Min[i]
+			}
+			if quantity, ok := (*r).Last[i].(Quantity); ok {
+				if aggregated, ok := values[i].(float64); ok {
+					values[i] = Quantity{Value: aggregated, Unit: quantity.Unit}
+				}
+			}
+		}
+		value[i] = FormatValue(values[i])
+	}
+
+	return Result{Time: (*r).Time, Value: strings.Join(value, " "), Values: values}
+}
+
+// Retrieves the bounds of rollups between two times, inclusive, where zero times are unbounded.
+// Rollups starting before the start time are included if their span of time contains it.
+func (s *rollupSeries) rangeBounds(startTime, endTime time.Time) (start, end int) {
+	start, end = 0, len((*s).rollups)
+
+	if !startTime.IsZero() {
+		startTime = startTime.Truncate((*s).policy.Resolution)
+		start = sort.Search(end, func(i int) bool { return !(*s).rollups[i].Time.Before(startTime) })
+	}
+	if !endTime.IsZero() {
+		end = max(start, sort.Search(end, func(i int) bool {
+			return (*s).rollups[i].Time.After(endTime)
+		}))
+	}
+
+	return
+}
+
+// Adds a result to the rollup for its span of time.
+func (s *rollupSeries) add(result Result) {
+	var (
+		rollupTime = result.Time.Truncate((*s).policy.Resolution) // Start of the result's rollup.
+		last       = len((*s).rollups) - 1                        // Index of the latest rollup.
+	)
+
+	// Results almost always belong to the latest rollup or a new one.
+	if last >= 0 && (*s).rollups[last].Time.Equal(rollupTime) {
+		(*s).rollups[last].add(result)
+		return
+	}
+	if last < 0 || (*s).rollups[last].Time.Before(rollupTime) {
+		(*s).rollups = append((*s).rollups, Rollup{Time: rollupTime})
+		(*s).rollups[last+1].add(result)
+		return
+	}
+
+	i, _ := s.rangeBounds(rollupTime, time.Time{})
+	if i == len((*s).rollups) || !(*s).rollups[i].Time.Equal(rollupTime) {
+		(*s).rollups = slices.Insert((*s).rollups, i, Rollup{Time: rollupTime})
+	}
+	(*s).rollups[i].add(result)
+}
+
+// Puts a rollup, replacing any for the same span of time.
+func (s *rollupSeries) put(rollup Rollup) {
+	i, _ := s.rangeBounds(rollup.Time, time.Time{})
+	if i < len((*s).rollups) && (*s).rollups[i].Time.Equal(rollup.Time) {
+		(*s).rollups[i] = rollup
+	} else {
+		(*s).rollups = slices.Insert((*s).rollups, i, rollup)
+	}
+}
+
+// Creates rollup series for every policy, if they don't exist yet.
+func (r *Results) initRollups() {
+	if (*r).rollups != nil {
+		return
+	}
+
+	(*r).rollups = make([]rollupSeries, len(RollupPolicies))
+	for i, policy := range RollupPolicies {
+		(*r).rollups[i].policy = policy
+	}
+}
+
+// Adds a result to every rollup series.
+func (r *Results) rollup(result Result) {
+	r.initRollups()
+	for i := range (*r).rollups {
+		(*r).rollups[i].add(result)
+	}
+}
+
+// Adds every result to rollups, for results that weren't put individually. Results of rows are
+// added to the rollups of their row.
+func (r *Results) rollupAll() {
+	for _, result := range (*r).Results {
+		if result.Key == "" {
+			r.rollup(result)
+		}
+	}
+	r.indexRows()
+}
+
+// Counts rollups at every resolution.
+func (r *Results) rollupCount() (count int) {
+	for _, series := range (*r).rollups {
+		count += len(series.rollups)
+	}
+
+	return
+}
+
+// Retrieves the rollup series for a resolution, or nil if rollups aren't kept at it.
+func (r *Results) rollupsAt(resolution time.Duration) *rollupSeries {
+	r.initRollups()
+	for i := range (*r).rollups {
+		if (*r).rollups[i].policy.Resolution == resolution {
+			return &(*r).rollups[i]
+		}
+	}
+
+	return nil
+}
+
+// Removes rollups older than their policy or a maximum age, whichever is sooner. Zero maximum ages
+// are unlimited.
+func (r *Results) pruneRollups(maxAge time.Duration, now time.Time) {
+	for i := range (*r).rollups {
+		series := &(*r).rollups[i]
+
+		seriesMaxAge := series.policy.MaxAge
+		if maxAge > 0 && (seriesMaxAge == 0 || maxAge < seriesMaxAge) {
+			seriesMaxAge = maxAge
+		}
+		if seriesMaxAge == 0 {
+			continue
+		}
+
+		pruned := 0
+		for pruned < len(series.rollups) && now.Sub(series.rollups[pruned].Time) > seriesMaxAge {
+			pruned++
+		}
+		series.rollups = series.rollups[pruned:]
+	}
+	for _, row := range (*r).rows {
+		row.pruneRollups(maxAge, now)
+	}
+}
+
+// Gets rollups at a resolution between a start and end timestamp, inclusive. Zero times are
+// unbounded.
+func (r *Results) getRollups(resolution time.Duration, startTime, endTime time.Time) []Rollup {
+	series := r.rollupsAt(resolution)
+	if series == nil {
+		return nil
+	}
+
+	start, end := series.rangeBounds(startTime, endTime)
+	return series.rollups[start:end:end]
+}
+
+// Retrieves the rollup series at an index of the rollup policies, along with those of every row, in
+// order of rows.
+func (r *Results) rollupsWithRows(i int) (series []*rollupSeries) {
+	series = append(series, &(*r).rollups[i])
+	for _, key := range r.getRows() {
+		series = append(series, &(*r).rows[key].rollups[i])
+	}
+
+	return
+}
+
+// Gets rollups at a resolution between a start and end timestamp as results, read by an aggregate,
+// including those of rows, in time order. Zero times are unbounded.
+func (r *Results) getRollupResults(
+	resolution time.Duration,
+	startTime, endTime time.Time,
+	aggregate string,
+) (results []Result) {
+	for _, result := range r.getRollups(resolution, startTime, endTime) {
+		results = append(results, result.Result(aggregate))
+	}
+	for _, key := range r.getRows() {
+		for _, rollup := range (*r).rows[key].getRollups(resolution, startTime, endTime) {
+			result := rollup.Result(aggregate)
+			result.Key = key
+			results = append(results, result)
+		}
+	}
+	slices.SortStableFunc(results, func(a, b Result) int { return a.Time.Compare(b.Time) })
+
+	return
+}
+
+// Gets results between a start and end timestamp at the finest resolution that covers the whole
+// time without exceeding a maximum number of results, reading rollups by an aggregate, e.g.
+// ROLLUP_AVG. Raw results are finest, but may have been removed by retention sooner than rollups. If
+// no resolution fits, the coarsest is used. Zero times are unbounded and a zero maximum is unlimited.
+// Returns the resolution used, which is zero for raw results.
+func (r *Results) getResolved(
+	startTime, endTime time.Time,
+	maxResults int,
+	aggregate string,
+) ([]Result, time.Duration) {
+	type candidate struct {
+		count      int           // Number of results in range.
+		first      time.Time     // Time of the first result, regardless of range.
+		resolution time.Duration // Resolution of results.
+	}
+	var (
+		candidates []candidate // Resolutions that may be read, from finest to coarsest.
+		chosen     = -1        // Index of the chosen candidate.
+	)
+
+	if len((*r).Results) > 0 {
+		start, end := r.rangeBounds(startTime, endTime)
+		candidates = append(candidates, candidate{end - start, (*r).Results[0].Time, 0})
+	}
+	for i, series := range (*r).rollups {
+		c := candidate{resolution: series.policy.Resolution}
+		for _, rowSeries := range r.rollupsWithRows(i) {
+			if len(rowSeries.rollups) == 0 {
+				continue
+			}
+			start, end := rowSeries.rangeBounds(startTime, endTime)
+			c.count += end - start
+			if c.first.IsZero() || rowSeries.rollups[0].Time.Before(c.first) {
+				c.first = rowSeries.rollups[0].Time
+			}
+		}
+		if !c.first.IsZero() {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, 0
+	}
+
+	// Determines whether a candidate covers the start of the range, i.e. nothing has been removed
+	// from it that another candidate still has.
+	covers := func(c candidate) bool {
+		if !startTime.IsZero() && !c.first.After(startTime) {
+			return true
+		}
+		for _, other := range candidates {
+			resolution := max(c.resolution, other.resolution)
+			if other.first.Truncate(resolution).Before(c.first.Truncate(resolution)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i, c := range candidates {
+		if covers(c) && (maxResults <= 0 || c.count <= maxResults) {
+			chosen = i
+			break
+		}
+	}
+	if chosen < 0 {
+		chosen = len(candidates) - 1
+	}
+
+	if resolution := candidates[chosen].resolution; resolution > 0 {
+		return r.getRollupResults(resolution, startTime, endTime, aggregate), resolution
+	}
+
+	return r.getRange(startTime, endTime), 0
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRollupResult(t *testing.T) {
+	rollup := Rollup{Time: testTime()}
+	rollup.add(Result{Values: Values{int64(1), 4.0, "a"}})
+	rollup.add(Result{Values: Values{int64(3), 2.0, "b"}})
+
+	// It reads numeric values by an aggregate and other values as the last ones.
+	for aggregate, expected := range map[string]Values{
+		ROLLUP_AVG:  {2.0, 3.0, "b"},
+		ROLLUP_LAST: {int64(3), 2.0, "b"},
+		ROLLUP_MAX:  {3.0, 4.0, "b"},
+		ROLLUP_MIN:  {1.0, 2.0, "b"},
+	} {
+		got := rollup.Result(aggregate)
+		if !reflect.DeepEqual(got.Values, expected) || !got.Time.Equal(testTime()) {
+			t.Errorf("Got: %v Expected: %v (%s)\n", got, expected, aggregate)
+		}
+	}
+	if rollup.Count != 2 {
+		t.Errorf("Got: %v Expected: %v\n", rollup.Count, 2)
+	}
+}
+
+func TestResultsRollup(t *testing.T) {
+	start := testTime().Truncate(time.Hour)
+	results := newResults(1)
+
+	// It summarizes results at every resolution as they are put, including out of order.
+	for _, offset := range []time.Duration{0, 30 * time.Second, 90 * time.Second, 10 * time.Second} {
+		results.putResult(Result{Time: start.Add(offset), Values: Values{int64(1)}})
+	}
+	minutes := results.getRollups(time.Minute, time.Time{}, time.Time{})
+	if len(minutes) != 2 || minutes[0].Count != 3 || minutes[1].Count != 1 {
+		t.Errorf("Got: %v Expected: %v\n", minutes, "3 then 1 results")
+	}
+	hours := results.getRollups(time.Hour, time.Time{}, time.Time{})
+	if len(hours) != 1 || hours[0].Count != 4 || !hours[0].Time.Equal(start) {
+		t.Errorf("Got: %v Expected: %v\n", hours, "4 results")
+	}
+
+	// It gets rollups whose span of time contains the start time.
+	if got := results.getRollups(time.Minute, start.Add(75*time.Second), time.Time{}); len(got) != 1 {
+		t.Errorf("Got: %v Expected: %v\n", len(got), 1)
+	}
+
+	// It removes rollups by age, keeping coarser ones for longer.
+	results.pruneRollups(0, start.Add(8*24*time.Hour))
+	if got := results.rollupCount(); got != 1 {
+		t.Errorf("Got: %v Expected: %v\n", got, 1)
+	}
+	results.pruneRollups(24*time.Hour, start.Add(8*24*time.Hour))
+	if got := results.rollupCount(); got != 0 {
+		t.Errorf("Got: %v Expected: %v\n", got, 0)
+	}
+}
+
+func TestResultsGetResolved(t *testing.T) {
+	start := testTime().Truncate(time.Hour)
+	results := newResults(1)
+	for i := 0; i < 180; i++ {
+		results.putResult(Result{Time: start.Add(time.Duration(i) * 20 * time.Second), Values: Values{1.0}})
+	}
+
+	for _, test := range []struct {
+		name       string
+		startTime  time.Time
+		maxResults int
+		resolution time.Duration
+		count      int
+	}{
+		{"unlimited", time.Time{}, 0, 0, 180},
+		{"few enough raw results", time.Time{}, 180, 0, 180},
+		{"too many raw results", time.Time{}, 100, time.Minute, 60},
+		{"too many minute rollups", time.Time{}, 10, time.Hour, 1},
+		{"a narrower range", start.Add(55 * time.Minute), 20, 0, 15},
+	} {
+		got, resolution := results.getResolved(test.startTime, time.Time{}, test.maxResults, ROLLUP_AVG)
+		if resolution != test.resolution || len(got) != test.count {
+			t.Errorf("Got: %v, %v Expected: %v, %v (%s)\n",
+				resolution, len(got), test.resolution, test.count, test.name)
+		}
+	}
+
+	// It reads rollups when raw results have been removed.
+	results.prune(Retention{MaxCount: 30}, start)
+	got, resolution := results.getResolved(start, time.Time{}, 0, ROLLUP_AVG)
+	if resolution != time.Minute || len(got) != 60 {
+		t.Errorf("Got: %v, %v Expected: %v, %v\n", resolution, len(got), time.Minute, 60)
+	}
+	// It reads raw results when they cover the range.
+	if _, resolution = results.getResolved(start.Add(time.Hour), time.Time{}, 0, ROLLUP_AVG); resolution != 0 {
+		t.Errorf("Got: %v Expected: %v\n", resolution, 0)
+	}
+}
+
+func TestWalRollups(t *testing.T) {
+	var (
+		path  = filepath.Join(t.TempDir(), WAL_FILE_NAME)
+		start = time.Now().Truncate(time.Hour)
+	)
+
+	storage, w := testWal(t, path)
+	for i := 0; i < 10; i++ {
+		storage.PutResult("foo", Result{Time: start.Add(time.Duration(i) * time.Second), Value: "1"}, true)
+	}
+	storage.Results["foo"].prune(Retention{MaxCount: 2}, start)
+	if err := w.compact(storage); err != nil {
+		t.Fatal(err)
+	}
+	storage.PutResult("foo", Result{Time: start.Add(10 * time.Second), Value: "1"}, true)
+	w.close()
+
+	// It restores rollups for removed results, without summarizing results twice.
+	storage, w = testWal(t, path)
+	defer w.close()
+	if got := storage.GetAll("foo"); len(got) != 3 {
+		t.Errorf("Got: %v Expected: %v\n", len(got), 3)
+	}
+	for _, resolution := range []time.Duration{time.Minute, time.Hour} {
+		got := storage.GetRollups("foo", resolution, time.Time{}, time.Time{})
+		if len(got) != 1 || got[0].Count != 11 {
+			t.Errorf("Got: %v Expected: %v (%v)\n", got, 11, resolution)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+//
+// RPC access to storage. Allows other processes, like another Cryptarch in 'read' mode, to retrieve
+// stored results and follow new ones as they are stored.
+
+package storage
+
+import (
+	"encoding/gob"
+	"slices"
+	"time"
+)
+
+const (
+	RPC_NAME = "Storage" // Name storage is registered as for RPC.
+)
+
+func init() {
+	// Result values are sent as interfaces, so types gob doesn't know of must be registered.
+	gob.Register(Quantity{})
+	gob.Register(time.Duration(0))
+}
+
+// Arguments for RPC calls.
+type ArgsRPC struct {
+	Index int           // Index of the first result to retrieve.
+	Query string        // Query to retrieve results for.
+	Wait  time.Duration // How long to wait for new results if none exist yet.
+}
+
+// Reply containing known queries.
+type QueriesRPC struct {
+	Queries []string
+}
+
+// Reply containing results.
+type ResultsRPC struct {
+	Index   int      // Index to use for retrieving the next results.
+	Labels  []string // Labels for the results.
+	Results []Result // Retrieved results.
+}
+
+// Storage exposed for RPC.
+type StorageRPC struct {
+	storage *Storage // Storage to expose.
+}
+
+// Retrieves the queries with results in storage.
+func (s *StorageRPC) GetQueries(args *ArgsRPC, reply *QueriesRPC) error {
+	reply.Queries = (*s).storage.Queries()
+
+	return nil
+}
+
+// Retrieves results for a query starting at an index. If there are no results at the index, this
+// will wait up to the provided wait time for new results to be stored.
+func (s *StorageRPC) GetFrom(args *ArgsRPC, reply *ResultsRPC) error {
+	var (
+		deadline = time.Now().Add(args.Wait) // When to stop waiting for results.
+	)
+
+	// Subscribe before looking for results, so that none are stored unnoticed in between. Only the
+	// latest result is needed to know that there are new results.
+	subscription, err := (*s).storage.Subscribe(args.Query, SubscribeOptions{Size: 1})
+	if err != nil {
+		return err
+	}
+	defer subscription.Close()
+
+	for {
+		if s.getFrom(args, reply) {
+			return nil
+		}
+		if _, ok := subscription.NextWait(max(time.Until(deadline), 0)); !ok {
+			// There are no new results.
+			return nil
+		}
+	}
+}
+
+// Retrieves results for a query starting at an index, returning whether or not there were any.
+func (s *StorageRPC) getFrom(args *ArgsRPC, reply *ResultsRPC) bool {
+	(*s).storage.mutex.RLock()
+	defer (*s).storage.mutex.RUnlock()
+
+	results, ok := (*s).storage.Results[args.Query]
+	if !ok {
+		reply.Index = args.Index
+		return false
+	}
+
+	reply.Labels = slices.Clone(results.Labels)
+	if results.nextSeq() <= args.Index {
+		reply.Index = args.Index
+		return false
+	}
+
+	// We found results. Indexes are sequences of results by when they were put, so they stay stable
+	// as results are removed or put before others.
+	for i, result := range results.Results {
+		if results.seq(i) >= args.Index {
+			reply.Results = append(reply.Results, result)
+		}
+	}
+	reply.Index = results.nextSeq()
+
+	return true
+}
+
+// Creates storage to expose over RPC.
+func NewStorageRPC(storage *Storage) *StorageRPC {
+	return &StorageRPC{storage: storage}
+}
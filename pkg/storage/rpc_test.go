@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStorageRPCGetQueries(t *testing.T) {
+	storage := testStorage()
+	storage.Load("foo", nil, nil)
+	storage.Load("bar", nil, nil)
+
+	reply := QueriesRPC{}
+	NewStorageRPC(&storage).GetQueries(&ArgsRPC{}, &reply)
+	if expected := []string{"bar", "foo"}; !reflect.DeepEqual(reply.Queries, expected) {
+		t.Errorf("Got: %v Expected: %v\n", reply.Queries, expected)
+	}
+}
+
+func TestStorageRPCGetFrom(t *testing.T) {
+	storage, results := testStorage(), testResults()
+	storage.Load("foo", results.Labels, results.Results)
+	storageRPC := NewStorageRPC(&storage)
+
+	// It retrieves results from an index.
+	reply := ResultsRPC{}
+	storageRPC.GetFrom(&ArgsRPC{Index: 1, Query: "foo"}, &reply)
+	if !reflect.DeepEqual(reply.Results, results.Results[1:]) {
+		t.Errorf("Got: %v Expected: %v\n", reply.Results, results.Results[1:])
+	}
+	if reply.Index != 2 {
+		t.Errorf("Got: %v Expected: %v\n", reply.Index, 2)
+	}
+	if !reflect.DeepEqual(reply.Labels, results.Labels) {
+		t.Errorf("Got: %v Expected: %v\n", reply.Labels, results.Labels)
+	}
+
+	// It waits and retrieves nothing when there are no new results.
+	reply = ResultsRPC{}
+	start := time.Now()
+	storageRPC.GetFrom(&ArgsRPC{Index: 2, Query: "foo", Wait: 50 * time.Millisecond}, &reply)
+	if len(reply.Results) != 0 || reply.Index != 2 {
+		t.Errorf("Got: %v Expected: %v\n", reply, ResultsRPC{Index: 2, Labels: results.Labels})
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Got: %v Expected: %v\n", elapsed, 50*time.Millisecond)
+	}
+
+	// It retrieves nothing for unknown queries.
+	reply = ResultsRPC{}
+	storageRPC.GetFrom(&ArgsRPC{Query: "bar"}, &reply)
+	if len(reply.Results) != 0 || reply.Index != 0 {
+		t.Errorf("Got: %v Expected: %v\n", reply, ResultsRPC{})
+	}
+}
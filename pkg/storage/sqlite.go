@@ -0,0 +1,638 @@
+//
+// Embedded SQLite persistence for storage.
+//
+// Storage is persisted to a SQLite database with a table each for results series, results, and
+// rollups of results. Results are indexed by time, so ranges of time are read without reading
+// everything, and the database may be read by other processes while it is being written, including
+// with SQL, e.g.:
+//
+//	sqlite3 storage.db "SELECT query, datetime(time / 1e9, 'unixepoch'), value FROM results"
+//
+// Times are nanoseconds since the epoch. Labels, results, and rollups are otherwise kept as JSON,
+// along with the raw value of each result.
+//
+// Rollups are rebuilt from results as storage is restored. Since results may be removed before their
+// rollups, rollups summarizing removed results are also persisted, along with the last result they
+// summarize, so that results aren't summarized twice when rollups are rebuilt.
+
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	SQLITE_BUSY_TIMEOUT = 5 * time.Second // How long to wait for another process writing the database.
+	SQLITE_DRIVER       = "sqlite"        // Name of the SQLite driver.
+	SQLITE_FILE_NAME    = "storage.db"    // Filename to use for the database.
+)
+
+var (
+	// Schema of the database.
+	sqliteSchema = []string{
+		`CREATE TABLE IF NOT EXISTS series (
+			query      TEXT PRIMARY KEY,
+			labels     TEXT NOT NULL,
+			generation INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS results (
+			id     INTEGER PRIMARY KEY AUTOINCREMENT,
+			query  TEXT NOT NULL,
+			time   INTEGER NOT NULL,
+			value  TEXT NOT NULL,
+			result TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS results_query_time ON results (query, time)`,
+		`CREATE TABLE IF NOT EXISTS rollups (
+			query      TEXT NOT NULL,
+			resolution INTEGER NOT NULL,
+			time       INTEGER NOT NULL,
+			through    INTEGER NOT NULL,
+			rollup     TEXT NOT NULL,
+			PRIMARY KEY (query, resolution, time)
+		)`,
+	}
+)
+
+// Backend persisting storage to a SQLite database.
+type sqliteBackend struct {
+	db      *sql.DB         // Open database.
+	path    string          // Path to the database file.
+	series  map[string]bool // Queries with persisted series.
+	storage *Storage        // Storage being persisted, once opened.
+}
+
+// Converts a time to how it is kept in the database. Zero times are kept as zero.
+func sqliteTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+// Opens the database. Writable databases are created if necessary, along with their schema, and
+// read-only databases must already exist.
+func (b *sqliteBackend) open(readOnly bool) (err error) {
+	var (
+		dataSource = (*b).path // Data source for the driver.
+		version    int         // Version of the storage schema.
+	)
+
+	if readOnly {
+		dataSource = (&url.URL{Scheme: "file", Path: (*b).path, RawQuery: "mode=ro"}).String()
+	}
+	(*b).db, err = sql.Open(SQLITE_DRIVER, dataSource)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			(*b).db.Close()
+			(*b).db = nil
+		}
+	}()
+
+	// A single connection keeps settings, which are for each connection, and writes in order.
+	(*b).db.SetMaxOpenConns(1)
+	_, err = (*b).db.Exec(fmt.Sprintf("PRAGMA busy_timeout = %d", SQLITE_BUSY_TIMEOUT.Milliseconds()))
+	if err != nil {
+		return
+	}
+
+	if err = (*b).db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return
+	}
+	if version > STORAGE_VERSION {
+		return fmt.Errorf(
+			"Storage version %d is newer than supported version %d", version, STORAGE_VERSION)
+	}
+	if readOnly {
+		return
+	}
+
+	// Other processes may read the database while it is being written.
+	if _, err = (*b).db.Exec("PRAGMA journal_mode = WAL"); err != nil {
+		return
+	}
+	for _, statement := range sqliteSchema {
+		if _, err = (*b).db.Exec(statement); err != nil {
+			return
+		}
+	}
+	_, err = (*b).db.Exec(fmt.Sprintf("PRAGMA user_version = %d", STORAGE_VERSION))
+
+	return
+}
+
+// Opens the database for reading directly, if it isn't already open. Returns whether or not the
+// database exists.
+func (b *sqliteBackend) openRead() (bool, error) {
+	if (*b).db != nil {
+		return true, nil
+	}
+	if _, err := os.Stat((*b).path); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, b.open(true)
+}
+
+// Restores storage from the database. Rollups are rebuilt from results, except for those that
+// were persisted, which already summarize results up to the last one they were persisted with.
+func (b *sqliteBackend) load(storage *Storage) error {
+	type persistedRollup struct {
+		resolution time.Duration // Resolution of the rollup.
+		time       int64         // Start of the rollup.
+	}
+	var (
+		ids      = make(map[string][]int64)                   // Identifiers of results, by query.
+		throughs = make(map[string]map[persistedRollup]int64) // Last results summarized, by query.
+	)
+
+	rows, err := (*b).db.Query("SELECT query, labels, generation FROM series")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			query, labelsJson string
+			generation        int
+			labels            []string
+		)
+		if err = rows.Scan(&query, &labelsJson, &generation); err != nil {
+			return err
+		}
+		if err = json.Unmarshal([]byte(labelsJson), &labels); err != nil {
+			return fmt.Errorf("Bad labels for %s: %v", query, err)
+		}
+		storage.newResults(query, len(labels))
+		(*storage).Results[query].Labels = labels
+		(*storage).Results[query].Generation = generation
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = (*b).db.Query("SELECT id, query, result FROM results ORDER BY query, time, id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id         int64
+			query      string
+			resultJson []byte
+			result     Result
+		)
+		if err = rows.Scan(&id, &query, &resultJson); err != nil {
+			return err
+		}
+		if err = json.Unmarshal(resultJson, &result); err != nil {
+			return fmt.Errorf("Bad result %d for %s: %v", id, query, err)
+		}
+		storage.newResults(query, len(result.Values))
+		(*storage).Results[query].Results = append((*storage).Results[query].Results, result)
+		ids[query] = append(ids[query], id)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = (*b).db.Query("SELECT query, resolution, time, through, rollup FROM rollups")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			query      string
+			rollup     Rollup
+			rollupJson []byte
+			key        persistedRollup
+			through    int64
+		)
+		if err = rows.Scan(&query, &key.resolution, &key.time, &through, &rollupJson); err != nil {
+			return err
+		}
+		if err = json.Unmarshal(rollupJson, &rollup); err != nil {
+			return fmt.Errorf("Bad rollup for %s: %v", query, err)
+		}
+		storage.newResults(query, len(rollup.Last))
+		series := (*storage).Results[query].rollupsAt(key.resolution)
+		if series == nil {
+			// Rollups at resolutions no longer kept are dropped.
+			continue
+		}
+		series.put(rollup)
+		if throughs[query] == nil {
+			throughs[query] = make(map[persistedRollup]int64)
+		}
+		throughs[query][key] = through
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for query, results := range (*storage).Results {
+		results.renumber()
+		results.resize()
+		results.initRollups()
+		results.indexRows()
+		for i, result := range results.Results {
+			if result.Key != "" {
+				// Rows are rolled up in their own sub-series.
+				continue
+			}
+			for j := range results.rollups {
+				series := &results.rollups[j]
+				key := persistedRollup{
+					series.policy.Resolution, result.Time.Truncate(series.policy.Resolution).UnixNano()}
+				if through, ok := throughs[query][key]; ok && ids[query][i] <= through {
+					// The persisted rollup already summarizes this result.
+					continue
+				}
+				series.add(result)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Restores storage from the database, after which changes to storage are written to it. Results
+// outside of retention are removed from the database.
+func (b *sqliteBackend) Open(storage *Storage) (err error) {
+	if err = b.open(false); err != nil {
+		return
+	}
+	if err = b.load(storage); err != nil {
+		return
+	}
+	(*b).series = make(map[string]bool, len((*storage).Results))
+	(*b).storage = storage
+
+	for query, results := range (*storage).Results {
+		(*b).series[query] = true
+
+		rollups := results.rollupCount()
+		if storage.prune(query) > 0 || results.rollupCount() < rollups {
+			if err = b.Prune(query); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// Restores storage from the database without modifying it.
+func (b *sqliteBackend) Read(storage *Storage) error {
+	if exists, err := b.openRead(); err != nil || !exists {
+		return err
+	}
+
+	return b.load(storage)
+}
+
+// Writes a series to the database, replacing any for the same query.
+func (b *sqliteBackend) putSeries(tx *sql.Tx, query string) error {
+	results := (*b).storage.Results[query]
+
+	labelsJson, err := json.Marshal(results.Labels)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO series (query, labels, generation) VALUES (?, ?, ?)",
+		query, string(labelsJson), results.Generation)
+	if err != nil {
+		return err
+	}
+	(*b).series[query] = true
+
+	return nil
+}
+
+// Writes a result to the database.
+func (b *sqliteBackend) putResult(tx *sql.Tx, query string, result Result) error {
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO results (query, time, value, result) VALUES (?, ?, ?, ?)",
+		query, sqliteTime(result.Time), result.Value, string(resultJson))
+
+	return err
+}
+
+// Writes rollups of a series between two times to the database, as summarizing every result
+// written so far.
+func (b *sqliteBackend) putRollups(tx *sql.Tx, query string, startTime, endTime time.Time) error {
+	var (
+		through int64 // Last result written.
+	)
+
+	if err := tx.QueryRow("SELECT COALESCE(MAX(id), 0) FROM results").Scan(&through); err != nil {
+		return err
+	}
+
+	for _, series := range (*b).storage.Results[query].rollups {
+		start, end := series.rangeBounds(startTime, endTime)
+		for _, rollup := range series.rollups[start:end] {
+			rollupJson, err := json.Marshal(rollup)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT OR REPLACE INTO rollups (query, resolution, time, through, rollup)
+				VALUES (?, ?, ?, ?, ?)`,
+				query, series.policy.Resolution, sqliteTime(rollup.Time), through, string(rollupJson))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Removes results and rollups of a series from the database that are no longer in storage. Rollups
+// of removed results are written, so they outlive the results they summarize.
+func (b *sqliteBackend) prune(tx *sql.Tx, query string) error {
+	var (
+		first      = int64(math.MaxInt64)        // Time of the first result in storage.
+		kept       = 0                           // Number of results in storage at the first time.
+		results    = (*b).storage.Results[query] // Results in storage.
+		start, end sql.NullInt64                 // Times of the removed results.
+
+		// Results before the first in storage, including those with the same time that were put
+		// before the ones kept, e.g. rows of a table.
+		removed = `query = ? AND (time < ? OR time = ? AND id NOT IN (
+			SELECT id FROM results WHERE query = ? AND time = ? ORDER BY id DESC LIMIT ?))`
+	)
+
+	if len(results.Results) > 0 {
+		first = sqliteTime(results.Results[0].Time)
+		kept = results.searchAfter(results.Results[0].Time)
+	}
+	args := []interface{}{query, first, first, query, first, kept}
+	err := tx.QueryRow("SELECT MIN(time), MAX(time) FROM results WHERE "+removed, args...).
+		Scan(&start, &end)
+	if err != nil {
+		return err
+	}
+	if start.Valid {
+		if _, err = tx.Exec("DELETE FROM results WHERE "+removed, args...); err != nil {
+			return err
+		}
+		err = b.putRollups(tx, query, time.Unix(0, start.Int64), time.Unix(0, end.Int64))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, series := range results.rollups {
+		first = int64(math.MaxInt64)
+		if len(series.rollups) > 0 {
+			first = sqliteTime(series.rollups[0].Time)
+		}
+		_, err = tx.Exec("DELETE FROM rollups WHERE query = ? AND resolution = ? AND time < ?",
+			query, series.policy.Resolution, first)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Runs a function in a transaction, committing it if the function succeeds.
+func (b *sqliteBackend) transact(f func(tx *sql.Tx) error) error {
+	tx, err := (*b).db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Writes a result to the database, along with its series if the database doesn't have it yet.
+func (b *sqliteBackend) PutResult(query string, result Result, pruned int) error {
+	return b.transact(func(tx *sql.Tx) error {
+		if !(*b).series[query] {
+			if err := b.putSeries(tx, query); err != nil {
+				return err
+			}
+		}
+		if err := b.putResult(tx, query, result); err != nil {
+			return err
+		}
+		if pruned > 0 {
+			return b.prune(tx, query)
+		}
+		return nil
+	})
+}
+
+// Writes a series to the database.
+func (b *sqliteBackend) PutSeries(query string) error {
+	return b.transact(func(tx *sql.Tx) error {
+		return b.putSeries(tx, query)
+	})
+}
+
+// Moves a series and everything belonging to it to its archive in the database.
+func (b *sqliteBackend) PutArchive(query, archive string) error {
+	err := b.transact(func(tx *sql.Tx) error {
+		for _, table := range []string{"series", "results", "rollups"} {
+			_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET query = ? WHERE query = ?", table), archive, query)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		(*b).series[archive], (*b).series[query] = (*b).series[query], false
+	}
+
+	return err
+}
+
+// Removes results and rollups of a series from the database that are no longer in storage.
+func (b *sqliteBackend) Prune(query string) error {
+	return b.transact(func(tx *sql.Tx) error {
+		return b.prune(tx, query)
+	})
+}
+
+// Rewrites the database with everything in storage.
+func (b *sqliteBackend) Save() error {
+	return b.transact(func(tx *sql.Tx) error {
+		for _, table := range []string{"series", "results", "rollups"} {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+				return err
+			}
+		}
+		clear((*b).series)
+
+		for _, query := range (*b).storage.queries() {
+			if err := b.putSeries(tx, query); err != nil {
+				return err
+			}
+			for _, result := range (*b).storage.Results[query].Results {
+				if err := b.putResult(tx, query, result); err != nil {
+					return err
+				}
+			}
+		}
+		for _, query := range (*b).storage.queries() {
+			if err := b.putRollups(tx, query, time.Time{}, time.Time{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Retrieves the number of results of a series in the database, and the times of its first and last,
+// using the index on times.
+func (b *sqliteBackend) Bounds(query string) (count int, first, last time.Time, err error) {
+	var (
+		start, end sql.NullInt64 // Times of the first and last results.
+	)
+
+	// Series that don't exist are an error, rather than empty.
+	if _, err = b.Labels(query); err != nil {
+		return
+	}
+
+	err = (*b).db.QueryRow(
+		"SELECT COUNT(*), MIN(time), MAX(time) FROM results WHERE query = ?", query).
+		Scan(&count, &start, &end)
+	if err == nil && start.Valid {
+		first, last = time.Unix(0, start.Int64), time.Unix(0, end.Int64)
+	}
+
+	return
+}
+
+// Retrieves the labels of a series in the database.
+func (b *sqliteBackend) Labels(query string) ([]string, error) {
+	var (
+		labels     []string // Labels of the series.
+		labelsJson string   // Labels of the series, as persisted.
+	)
+
+	if exists, err := b.openRead(); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, &QueryNotFoundError{Query: query}
+	}
+
+	err := (*b).db.QueryRow("SELECT labels FROM series WHERE query = ?", query).Scan(&labelsJson)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &QueryNotFoundError{Query: query}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return labels, json.Unmarshal([]byte(labelsJson), &labels)
+}
+
+// Retrieves results of a series in the database between two times, using the index on times.
+func (b *sqliteBackend) Range(query string, startTime, endTime time.Time) ([]Result, error) {
+	var (
+		results []Result               // Results in range.
+		start   = int64(math.MinInt64) // Start of the range.
+		end     = int64(math.MaxInt64) // End of the range.
+	)
+
+	// Series that don't exist are an error, rather than an empty range.
+	if _, err := b.Labels(query); err != nil {
+		return nil, err
+	}
+	if !startTime.IsZero() {
+		start = sqliteTime(startTime)
+	}
+	if !endTime.IsZero() {
+		end = sqliteTime(endTime)
+	}
+
+	rows, err := (*b).db.Query(
+		"SELECT result FROM results WHERE query = ? AND time >= ? AND time <= ? ORDER BY time, id",
+		query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			result     Result
+			resultJson []byte
+		)
+		if err = rows.Scan(&resultJson); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(resultJson, &result); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// Retrieves the queries of series in the database.
+func (b *sqliteBackend) Series() ([]string, error) {
+	var (
+		queries []string // Queries of series.
+	)
+
+	if exists, err := b.openRead(); err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := (*b).db.Query("SELECT query FROM series ORDER BY query")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var query string
+		if err = rows.Scan(&query); err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+
+	return queries, rows.Err()
+}
+
+// Closes the database, if it was opened.
+func (b *sqliteBackend) Close() error {
+	if (*b).db == nil {
+		return nil
+	}
+	err := (*b).db.Close()
+	(*b).db = nil
+
+	return err
+}
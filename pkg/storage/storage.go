@@ -5,17 +5,21 @@
 // library in a few ways, namely:
 //
 // - It can be used as a library.
-// - It can broadcast events into public Go channels.
+// - It can send results to subscribers as they are stored, see subscribe.go.
 // - It can broadcast events via RPC.
 //
-// Results are stored simply in an ordered sequence, and querying time is linear.
+// Storage is safe for concurrent use. Results are read as copies, so they stay consistent while more
+// results are put.
+//
+// Results are stored simply in a sequence ordered by time, so querying by time is a binary search.
+// Persisted results are written to a backend as they are stored, see backend.go.
 
 package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -27,13 +31,23 @@ import (
 )
 
 const (
-	MAX_EXTERNAL_STORAGES  = 128            // Maximum external storage integrations.
-	MAX_RESULTS            = 128            // Maximum number of result series that may be maintained.
-	PUT_EVENT_CHANNEL_SIZE = 128            // Size of put channels, controlling the amount of waiting results.
-	STORAGE_FILE_DIR       = "cryptarch"    // Directory in user cache to use for storage.
-	STORAGE_FILE_NAME      = "storage.json" // Filename to use for actual storage.
+	MAX_EXTERNAL_STORAGES = 128            // Maximum external storage integrations.
+	MAX_RESULTS           = 128            // Maximum number of result series that may be maintained.
+	STORAGE_ARCHIVE_SEP   = "#"            // Separator for queries and generations of archived results.
+	STORAGE_FILE_DIR      = "cryptarch"    // Directory in user cache to use for storage.
+	STORAGE_FILE_NAME     = "storage.json" // Filename used for storage before the log.
+	STORAGE_MIGRATED_EXT  = ".migrated"    // Extension for storage files that have been migrated.
+	STORAGE_VERSION       = 2              // Version of the storage schema.
 )
 
+// Storage persisted as a single JSON file, from before the log. Storage is versioned so that changes
+// to its schema may be migrated. Storage without a version is from before versioning, and is simply
+// a mapping of queries to results.
+type storageData struct {
+	Version int                 // Version of the storage schema.
+	Results map[string]*Results // Map of queries to results.
+}
+
 // Returns a results series that has been filtered to a specific set of labels.
 func filterResult(query string, filters, labels []string, result Result) (filteredResult Result) {
 	var (
@@ -51,11 +65,8 @@ func filterResult(query string, filters, labels []string, result Result) (filter
 		filteredValues = filterSlice(result.Values, filteredIndexes)
 
 		// Reconstruct the result with filtered values.
-		filteredResult = Result{
-			Time:   result.Time,
-			Value:  result.Value,
-			Values: filteredValues,
-		}
+		filteredResult = result
+		filteredResult.Values = filteredValues
 	} else {
 		// If not filters were provided, just return the result itself.
 		filteredResult = result
@@ -83,74 +94,185 @@ func filterSlice[T interface{}](in []T, indexes []int) (out []T) {
 
 // Collection of results mapped to their queries.
 type Storage struct {
-	externalStorages []externalStorage        // Integrated external storages.
-	putEventChans    map[string](chan Result) // Map of queries to put even channels.
-	storageFile      *os.File                 // File for persisting results.
-	storageMutex     *sync.Mutex              // Mutex for managing persistence writes.
+	backend          Backend              // Backend for persisting results.
+	externalStorages []externalStorage    // Integrated external storages.
+	lock             *os.File             // Lock held on the database while writing.
+	mutex            *sync.RWMutex        // Mutex for managing concurrent access to results.
+	puts             int                  // Number of results put, for ordering them for subscribers.
+	queryRetentions  map[string]Retention // Retention for specific queries.
+	retention        Retention            // Retention for queries without their own.
+	subscribers      *subscribers         // Subscriptions to results as they are put.
 
 	Results map[string]*Results // Map of queries to results.
 }
 
 // Initializes a new results series in storage. Must be called when a new results series is created.
+// Functions that aren't exported, like this one, expect storage to already be locked.
 // This function is idempotent in that it will check if results for a query have already been
-// initialized and pass silently if so.
+// initialized and pass silently if so, other than adding default labels for results with more
+// values than before, e.g. when the first result timed out.
 func (s *Storage) newResults(query string, size int) {
 	var (
 		results Results // Results to initialize.
 	)
 
-	if _, ok := (*s).Results[query]; !ok {
+	if existing, ok := (*s).Results[query]; !ok {
 		// Initialize results.
 		results = newResults(size)
 		(*s).Results[query] = &results
-
-		// Initialize the query's put event channel.
-		(*s).putEventChans[query] = make(chan Result, PUT_EVENT_CHANNEL_SIZE)
+	} else if existing.hasDefaultLabels() && len(existing.Labels) < size {
+		existing.Labels = append(existing.Labels, newResults(size).Labels[len(existing.Labels):]...)
 	}
 }
 
-// Saves current storage to disk. Currently this replaces the entire storage file with all the data
-// in storage (a full write-over).
-func (s *Storage) save() error {
+// Restores storage data persisted as JSON, migrating it from older versions if necessary. Results
+// series are restored exactly, including their labels.
+func (s *Storage) restore(storageJson []byte) error {
 	var (
-		err         error  // General error holder.
-		resultsJson []byte // Bytes as json.
+		data storageData // Persisted storage.
 	)
 
-	// Lock storage to prevent dirty writes.
-	(*s).storageMutex.Lock()
-	defer (*s).storageMutex.Unlock()
+	if err := json.Unmarshal(storageJson, &data); err != nil || data.Version == 0 {
+		// This is storage from before versioning, which only has results.
+		slog.Debug("Migrating unversioned storage", "version", STORAGE_VERSION)
+		data = storageData{}
+		if err := json.Unmarshal(storageJson, &data.Results); err != nil {
+			return fmt.Errorf("Failed to read storage: %v", err)
+		}
+	} else if data.Version > STORAGE_VERSION {
+		return fmt.Errorf(
+			"Storage version %d is newer than supported version %d", data.Version, STORAGE_VERSION)
+	}
 
-	// Translate current storage results into binary json and save it.
-	resultsJson, err = json.MarshalIndent(&s.Results, "", "\t")
-	_, err = (*s).storageFile.WriteAt(resultsJson, 0)
+	for query, results := range data.Results {
+		if results == nil {
+			continue
+		}
+		if len(results.Labels) == 0 && len(results.Results) > 0 {
+			// Unlabelled results use their indexes as labels.
+			results.Labels = newResults(len(results.Results[0].Values)).Labels
+		}
+		s.newResults(query, len(results.Labels))
+		(*s).Results[query] = results
+		results.sort()
+		results.renumber()
+		results.resize()
+		results.rollupAll()
+		s.prune(query)
+	}
 
-	return err
+	return nil
 }
 
 // Adds an external storage.
 func (s *Storage) AddExternalStorage(e externalStorage) {
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
+
 	slog.Debug(fmt.Sprintf("Enabled external storage %v", reflect.TypeOf(e)))
 	(*s).externalStorages = append((*s).externalStorages, e)
 }
 
-// Closes a storage. Should be called after all storage operations cease.
+// Closes a storage, including any subscriptions to it. Should be called after all storage
+// operations cease.
 func (s *Storage) Close() {
-	(*s).storageFile.Close()
+	if (*s).subscribers != nil {
+		(*s).subscribers.closeAll()
+	}
+
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
+	if (*s).backend != nil {
+		(*s).backend.Close()
+		(*s).backend = nil
+	}
+	if (*s).lock != nil {
+		(*s).lock.Close()
+		(*s).lock = nil
+	}
+}
+
+// Retrieves the queries with results in storage, in order.
+func (s *Storage) queries() (queries []string) {
+	for query := range (*s).Results {
+		queries = append(queries, query)
+	}
+	slices.Sort(queries)
+
+	return
+}
+
+// Retrieves the queries with results in storage, in order.
+func (s *Storage) Queries() []string {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return s.queries()
 }
 
 // Get a result based on a timestamp.
 func (s *Storage) Get(query string, time time.Time) Result {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
 	return (*s).Results[query].get(time)
 }
 
+// Get the nearest result after a timestamp.
+func (s *Storage) GetAfter(query string, time time.Time) Result {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return (*s).Results[query].getAfter(time)
+}
+
 // Get all results.
 func (s *Storage) GetAll(query string) []Result {
-	return (*s).Results[query].Results
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return slices.Clone((*s).Results[query].Results)
+}
+
+// Get the result at or nearest before a timestamp, e.g. for aligning results from different queries.
+func (s *Storage) GetAtOrBefore(query string, time time.Time) Result {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return (*s).Results[query].getAtOrBefore(time)
+}
+
+// Get the nearest result before a timestamp.
+func (s *Storage) GetBefore(query string, time time.Time) Result {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return (*s).Results[query].getBefore(time)
+}
+
+// Gets the number of results, and the times of the first and last results, without copying them.
+func (s *Storage) GetBounds(query string) (count int, first, last time.Time) {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	if results, ok := (*s).Results[query]; ok && len(results.Results) > 0 {
+		count = len(results.Results)
+		first, last = results.Results[0].Time, results.Results[count-1].Time
+	}
+
+	return
 }
 
 // Get a result's labels.
 func (s *Storage) GetLabels(query string, filters []string) []string {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return s.getLabels(query, filters)
+}
+
+// Get a result's labels.
+func (s *Storage) getLabels(query string, filters []string) []string {
 	var (
 		filteredIndexes = make([]int, len(filters))  // Indexes for filtering.
 		labels          = (*s).Results[query].Labels // Labels associated with this query.
@@ -165,24 +287,83 @@ func (s *Storage) GetLabels(query string, filters []string) []string {
 		labels = filterSlice(labels, filteredIndexes)
 	}
 
-	return labels
+	return slices.Clone(labels)
+}
+
+// Get the keys of rows, for queries producing tables, in order.
+func (s *Storage) GetRows(query string) []string {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	if results, ok := (*s).Results[query]; ok {
+		return results.getRows()
+	}
+
+	return nil
 }
 
 // Gets results based on a start and end timestamp.
 func (s *Storage) GetRange(query string, startTime, endTime time.Time) []Result {
-	return (*s).Results[query].getRange(startTime, endTime)
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return slices.Clone((*s).Results[query].getRange(startTime, endTime))
 }
 
-// Given results up to a reader index (a.k.a. "playback").
+// Gets results between a start and end timestamp at the finest resolution that covers the whole time
+// without exceeding a maximum number of results, reading rollups by an aggregate, e.g. ROLLUP_AVG.
+// Returns the resolution used, which is zero for raw results.
+func (s *Storage) GetResolved(
+	query string,
+	startTime, endTime time.Time,
+	maxResults int,
+	aggregate string,
+) ([]Result, time.Duration) {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	results, resolution := (*s).Results[query].getResolved(startTime, endTime, maxResults, aggregate)
+	return slices.Clone(results), resolution
+}
+
+// Gets rollups at a resolution between a start and end timestamp.
+func (s *Storage) GetRollups(
+	query string,
+	resolution time.Duration,
+	startTime, endTime time.Time,
+) []Rollup {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return slices.Clone((*s).Results[query].getRollups(resolution, startTime, endTime))
+}
+
+// Gets results at steps between a start and end timestamp, e.g. one every minute, each being the
+// result at or before its step.
+func (s *Storage) GetStep(query string, startTime, endTime time.Time, step time.Duration) []Result {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return (*s).Results[query].getStep(startTime, endTime, step)
+}
+
+// Given results up to a reader index (a.k.a. "playback"). Indexes are sequences of results by when
+// they were put, as for RPC, so they stay stable as results are removed or put before others.
 func (s *Storage) GetToIndex(query string, filters []string, index *ReaderIndex) []Result {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
 	var (
-		results         = (*s).Results[query].Results[:(*index)+1] // Queried results.
-		filteredResults = make([]Result, len(results))             // Results after filtering.
-		labels          = (*s).Results[query].Labels               // Labels associated with this query.
+		results         = (*s).Results[query]        // Queried results.
+		filteredResults []Result                     // Results after filtering.
+		labels          = (*s).Results[query].Labels // Labels associated with this query.
 	)
 
-	for i, result := range results {
-		filteredResults[i] = filterResult(query, filters, labels, result)
+	filteredResults = make([]Result, 0, len(results.Results))
+	for i, result := range results.Results {
+		if results.seq(i) <= int(*index) {
+			filteredResults = append(filteredResults, filterResult(query, filters, labels, result))
+		}
 	}
 
 	return filteredResults
@@ -190,12 +371,23 @@ func (s *Storage) GetToIndex(query string, filters []string, index *ReaderIndex)
 
 // Given a filter, return the corresponding value index.
 func (s *Storage) GetValueIndex(query, filter string) int {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
 	return (*s).Results[query].getValueIndex(filter)
 }
 
 // Initialize a new reader index. Will attempt to set the initial value to the end of existing
 // results, if results already exist.
 func (s *Storage) NewReaderIndex(query string) *ReaderIndex {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	return s.newReaderIndex(query)
+}
+
+// Initialize a new reader index at the end of existing results.
+func (s *Storage) newReaderIndex(query string) *ReaderIndex {
 	var (
 		reader ReaderIndex // Reader index to initialize.
 	)
@@ -205,36 +397,26 @@ func (s *Storage) NewReaderIndex(query string) *ReaderIndex {
 		reader = ReaderIndex(0)
 	} else {
 		// There is existing data to account for.
-		reader = ReaderIndex(len((*s).Results[query].Results))
+		reader = ReaderIndex((*s).Results[query].nextSeq())
 	}
 
 	return &reader
 }
 
-// Retrieve the next result from a put event channel, blocking if none exists.
-func (s *Storage) Next(query string, filters []string, reader *ReaderIndex) (next Result) {
-	// Read from the event channel.
-	next = <-(*s).putEventChans[query]
-	reader.Inc()
+// Loads a series of existing results, such as those retrieved from elsewhere, replacing any that
+// exist for the query. Loading does not send put events, persist, or send results to external
+// storages.
+func (s *Storage) Load(query string, labels []string, results []Result) {
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
 
-	slog.Debug("Received next from channel", "result", next)
-
-	// Apply filters.
-	next = filterResult(query, filters, (*s).Results[query].Labels, next)
-
-	return
-}
-
-// Retrieve the next result from a put event channel, returning an empty result if nothing exists.
-func (s *Storage) NextOrEmpty(query string, reader *ReaderIndex) (next Result) {
-	select {
-	case next = <-(*s).putEventChans[query]:
-		// Only increment the read counter if something consumed the event.
-		reader.Inc()
-	default:
-	}
-
-	return
+	s.newResults(query, len(labels))
+	(*s).Results[query].Labels = labels
+	(*s).Results[query].Results = results
+	(*s).Results[query].sort()
+	(*s).Results[query].renumber()
+	(*s).Results[query].resize()
+	(*s).Results[query].indexRows()
 }
 
 // Put a new result.
@@ -243,9 +425,26 @@ func (s *Storage) Put(
 	persistence bool,
 	values ...interface{},
 ) (result Result, err error) {
+	return s.PutResult(query, Result{Value: value, Values: values}, persistence)
+}
+
+// Put a pre-built result. If the result has no time, it is given the current time. Putting waits for
+// subscribers with the SUBSCRIBE_BLOCK policy to have room for the result.
+func (s *Storage) PutResult(query string, result Result, persistence bool) (Result, error) {
+	var (
+		err error // General error holder.
+	)
+
 	// Initialize the result.
-	s.newResults(query, len(values))
-	result = (*s).Results[query].put(value, values...)
+	(*s).mutex.Lock()
+	s.newResults(query, len(result.Values))
+	seq := (*s).Results[query].nextSeq()
+	result = (*s).Results[query].putResult(result)
+	pruned := s.prune(query)
+	labels := (*s).Results[query].Labels
+	externalStorages := (*s).externalStorages
+	(*s).puts++
+	put := (*s).puts
 
 	slog.Debug(
 		"Storing results",
@@ -254,66 +453,122 @@ func (s *Storage) Put(
 		"result",
 		result,
 		"labels",
-		(*s).Results[query].Labels,
+		labels,
 	)
 
-	if result.IsEmptyValues() {
+	if result.IsEmptyValues() && !result.TimedOut {
 		slog.Warn("Storing empty result", "query", query)
 	}
 
-	// Send a non-blocking put event. Put events are lossy and clients may lose information if not
-	// actively listening.
-	select {
-	case (*s).putEventChans[query] <- result:
-	default:
-	}
-
-	// Persist data to disk.
-	if persistence {
-		err = s.save()
+	// Persist data to disk, in the same order results are stored.
+	if persistence && (*s).backend != nil {
+		err = (*s).backend.PutResult(query, result, pruned)
 	}
+	(*s).mutex.Unlock()
 	if err != nil {
-		return
+		return result, err
 	}
 
+	// Send the result to subscribers, which may wait for subscribers that block.
+	(*s).subscribers.publish(query, result, put, seq)
+
 	// Persist data to external sources.
-	for _, externalStore := range (*s).externalStorages {
-		err = externalStore.Put(query, (*s).Results[query].Labels, result)
+	for _, externalStore := range externalStorages {
+		err = externalStore.Put(query, labels, result)
 		if err != nil {
-			return
+			return result, err
 		}
 	}
 
-	return
+	return result, err
 }
 
-// Assigns explicit labels to a results series.
-func (s *Storage) PutLabels(query string, labels []string) {
+// Assigns explicit labels to a results series. Existing results are never silently mislabelled: if
+// they have default labels, or their labels are a prefix of the new ones (i.e. values were added),
+// they take on the new labels. Otherwise, they are archived as a previous generation of the series
+// under '<query>#<generation>' and a new generation is started.
+func (s *Storage) PutLabels(query string, labels []string) error {
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
+
 	s.newResults(query, len(labels))
-	(*s).Results[query].Labels = labels
+	results := (*s).Results[query]
+
+	switch {
+	case slices.Equal(results.Labels, labels):
+		// Nothing has changed.
+		return nil
+	case len(results.Results) == 0:
+		results.Labels = labels
+	case results.hasDefaultLabels():
+		slog.Info("Labelling existing results", "query", query, "labels", labels)
+		results.Labels = labels
+	case len(results.Labels) < len(labels) &&
+		slices.Equal(results.Labels, labels[:len(results.Labels)]):
+		slog.Info(
+			"Adding labels to existing results", "query", query, "from", results.Labels, "to", labels)
+		results.Labels = labels
+	default:
+		// Archive previous results and start a new generation.
+		archive := fmt.Sprintf("%s%s%d", query, STORAGE_ARCHIVE_SEP, results.Generation)
+		slog.Warn(
+			"Labels changed, archiving previous results",
+			"query", query, "from", results.Labels, "to", labels, "archive", archive)
+
+		(*s).Results[archive] = results
+		(*s).Results[query] = &Results{Generation: results.Generation + 1, Labels: labels}
+
+		if (*s).backend != nil {
+			if err := (*s).backend.PutArchive(query, archive); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Persist the new labels.
+	if (*s).backend != nil {
+		return (*s).backend.PutSeries(query)
+	}
+
+	return nil
 }
 
 // Show all currently stored results.
 func (s *Storage) Show(query string) {
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
 	(*s).Results[query].show()
 }
 
-// Initializes a new storage, loading in any saved storage data.
-func NewStorage(persistence bool) (storage Storage, err error) {
+// Initializes a new storage, loading in any saved storage data from a database. The database is
+// locked while storage is open, failing with a StorageLockedError if another process is writing to
+// it. Storage persisted as JSON by older versions is migrated to the database's backend, and the JSON
+// file is kept with a '.migrated' extension. Retention applies to queries without their own in
+// queryRetentions, and is enforced as storage is loaded and as results are put.
+func NewStorage(
+	persistence bool,
+	location Location,
+	retention Retention,
+	queryRetentions map[string]Retention,
+) (storage Storage, err error) {
 	var (
-		cryptarchUserCacheDir string              // Cryptarch specific user cache data.
-		results               map[string]*Results // Pre-built storage with existing data.
-		storageData           []byte              // Raw read storage data.
-		storageFilepath       string              // Filepath for storage.
-		storageStat           fs.FileInfo         // Stat for the storage file.
-		userCacheDir          string              // User cache directory, contextual to OS.
+		backend         Backend // Backend of the database.
+		backendName     string  // Name of the backend of the database.
+		dir             string  // Directory of the database.
+		exists          bool    // Whether or not the backend has persisted to the database.
+		migrate         bool    // Whether or not JSON storage is being migrated.
+		storageFilepath string  // Filepath for JSON storage, from older versions.
+		storageJson     []byte  // Raw read JSON storage data.
 	)
 
 	// Initialize storage.
 	storage = Storage{
-		Results:       make(map[string]*Results, MAX_RESULTS),
-		putEventChans: make(map[string](chan Result), MAX_RESULTS),
-		storageMutex:  &sync.Mutex{},
+		Results:         make(map[string]*Results, MAX_RESULTS),
+		mutex:           &sync.RWMutex{},
+		queryRetentions: queryRetentions,
+		retention:       retention,
+		subscribers:     newSubscribers(),
 	}
 
 	// If we have disabled persistence, simply return the new storage instance.
@@ -321,67 +576,99 @@ func NewStorage(persistence bool) (storage Storage, err error) {
 		return
 	}
 
-	// Retrieve the user cache directory.
-	userCacheDir, err = os.UserCacheDir()
+	// Create the database directory and lock it for writing.
+	dir, err = location.makeDir()
 	if err != nil {
 		return
 	}
-
-	// Create the user cache directory for data.
-	cryptarchUserCacheDir = filepath.Join(userCacheDir, STORAGE_FILE_DIR)
-	err = os.MkdirAll(cryptarchUserCacheDir, fs.FileMode(0770))
+	storage.lock, err = lockDir(dir)
 	if err != nil {
 		return
 	}
+	defer func() {
+		if err != nil {
+			storage.Close()
+		}
+	}()
 
-	// Instantiate the storage file.
-	storageFilepath = filepath.Join(cryptarchUserCacheDir, STORAGE_FILE_NAME)
-	storage.storageFile, err = os.OpenFile(
-		storageFilepath,
-		os.O_CREATE|os.O_RDWR,
-		fs.FileMode(0770),
-	)
+	backendName, exists, err = detectBackend(location.Backend, dir)
+	if err != nil {
+		return
+	}
+	backend, err = newBackend(backendName, dir)
 	if err != nil {
 		return
 	}
 
-	if storageStat, err = os.Stat(storageFilepath); storageStat.Size() > 0 {
-		// There is pre-existing storage data.
-		if err != nil {
+	// Read in any storage data from before backends.
+	storageFilepath = filepath.Join(dir, STORAGE_FILE_NAME)
+	if !exists {
+		storageJson, err = os.ReadFile(storageFilepath)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		} else if err != nil {
 			return
+		} else if len(storageJson) > 0 {
+			slog.Info("Migrating storage", "from", storageFilepath, "backend", backendName)
+			if err = storage.restore(storageJson); err != nil {
+				return
+			}
+			migrate = true
 		}
+	}
 
-		// Read in storage data and supply it to storage. We must initialize any results series before
-		// populating data.
-		storageData, err = io.ReadAll(storage.storageFile)
-		if err != nil {
-			return
-		}
-		json.Unmarshal(storageData, &results)
+	// Read in storage data from the backend.
+	if err = backend.Open(&storage); err != nil {
+		backend.Close()
+		return
+	}
+	storage.backend = backend
 
-		for query := range results {
-			// TODO Results loading should also preserve and restore actual labels.
-			storage.newResults(query, len(results[query].Results[0].Values))
-			storage.Results[query] = results[query]
+	if migrate {
+		// Write migrated storage to the backend and keep the old storage file out of the way.
+		if err = backend.Save(); err != nil {
+			return
 		}
+		err = os.Rename(storageFilepath, storageFilepath+STORAGE_MIGRATED_EXT)
 	}
 
 	return
 }
 
-///////////////////////////////////////////////////////////////////////////////////////////////////
-//
-// RPC
-//
-///////////////////////////////////////////////////////////////////////////////////////////////////
+// Reads persisted storage from a database without modifying it, e.g. for presenting history while
+// another Cryptarch may be storing results. Results put into the returned storage are not persisted.
+func ReadStorage(location Location) (storage Storage, err error) {
+	var (
+		dir         string // Directory of the database.
+		storageJson []byte // Raw read JSON storage data.
+	)
 
-type ArgsRPC struct{}
+	storage, _ = NewStorage(false, location, Retention{}, nil)
+	dir, err = location.Dir()
+	if err != nil {
+		return
+	}
 
-type ResultsRPC struct {
-	Results *Results
-}
+	backendName, exists, err := detectBackend(location.Backend, dir)
+	if err != nil {
+		return
+	}
+	if exists {
+		backend, err := newBackend(backendName, dir)
+		if err != nil {
+			return storage, err
+		}
+		defer backend.Close()
+		return storage, backend.Read(&storage)
+	}
 
-func (r *Results) GetAllRPC(args *ArgsRPC, results *ResultsRPC) error {
-	results.Results = r
-	return nil
+	// There may be storage from before backends.
+	storageJson, err = os.ReadFile(filepath.Join(dir, STORAGE_FILE_NAME))
+	if errors.Is(err, fs.ErrNotExist) {
+		return storage, nil
+	} else if err == nil && len(storageJson) > 0 {
+		err = storage.restore(storageJson)
+	}
+
+	return
 }
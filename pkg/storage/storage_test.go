@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Builds a test storage without persistence.
+func testStorage() Storage {
+	return Storage{
+		Results:     map[string]*Results{},
+		mutex:       &sync.RWMutex{},
+		subscribers: newSubscribers(),
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	storage, err := NewStorage(true, Location{}, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.PutLabels("foo", []string{"fizz", "buzz"})
+	storage.Put("foo", "a b", true, "a", "b")
+	storage.Close()
+
+	// It restores persisted results and labels.
+	storage, err = NewStorage(true, Location{}, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if got := storage.Results["foo"].Labels; !reflect.DeepEqual(got, []string{"fizz", "buzz"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"fizz", "buzz"})
+	}
+	if got := storage.GetAll("foo"); len(got) != 1 || got[0].Value != "a b" {
+		t.Errorf("Got: %v Expected: %v\n", got, "a b")
+	}
+}
+
+func TestStorageRestore(t *testing.T) {
+	results := testResults()
+
+	// It restores versioned storage exactly, including series without results.
+	storage := testStorage()
+	err := storage.restore([]byte(`{
+		"Version": 1,
+		"Results": {
+			"foo": {"Labels": ["foo", "bar"], "Results": [{"Time": "2024-01-01T00:00:00Z", "Value": "a b"}]},
+			"bar": {"Labels": ["fizz"], "Results": []}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.Results["foo"].Labels; !reflect.DeepEqual(got, results.Labels) {
+		t.Errorf("Got: %v Expected: %v\n", got, results.Labels)
+	}
+	if got := storage.Results["bar"].Labels; !reflect.DeepEqual(got, []string{"fizz"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"fizz"})
+	}
+
+	// It migrates unversioned storage.
+	storage = testStorage()
+	err = storage.restore([]byte(`{
+		"foo": {"Labels": ["foo", "bar"], "Results": [{"Time": "2024-01-01T00:00:00Z", "Value": "a b"}]},
+		"bar": {"Results": [{"Time": "2024-01-01T00:00:00Z", "Value": "a b", "Values": ["a", "b"]}]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.Results["foo"].Labels; !reflect.DeepEqual(got, results.Labels) {
+		t.Errorf("Got: %v Expected: %v\n", got, results.Labels)
+	}
+	if got := storage.Results["bar"].Labels; !reflect.DeepEqual(got, []string{"0", "1"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"0", "1"})
+	}
+
+	// It refuses storage from newer versions.
+	storage = testStorage()
+	if err := storage.restore([]byte(`{"Version": 1000, "Results": {}}`)); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+}
+
+func TestStoragePutLabels(t *testing.T) {
+	results := testResults()
+
+	// It labels results with default labels.
+	storage := testStorage()
+	storage.Load("foo", []string{"0", "1"}, results.Results)
+	storage.PutLabels("foo", []string{"fizz", "buzz"})
+	if got := storage.Results["foo"].Labels; !reflect.DeepEqual(got, []string{"fizz", "buzz"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"fizz", "buzz"})
+	}
+
+	// It adds labels to results.
+	storage.PutLabels("foo", []string{"fizz", "buzz", "bar"})
+	if got := storage.Results["foo"].Labels; !reflect.DeepEqual(got, []string{"fizz", "buzz", "bar"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"fizz", "buzz", "bar"})
+	}
+
+	// It archives results when labels change, starting a new generation.
+	storage.PutLabels("foo", []string{"buzz", "fizz"})
+	if got := storage.Results["foo"]; len(got.Results) != 0 || got.Generation != 1 {
+		t.Errorf("Got: %v Expected: %v\n", got, Results{Labels: []string{"buzz", "fizz"}, Generation: 1})
+	}
+	archived, ok := storage.Results["foo#0"]
+	if !ok || !reflect.DeepEqual(archived.Results, results.Results) {
+		t.Errorf("Got: %v Expected: %v\n", archived, results.Results)
+	}
+	if !reflect.DeepEqual(archived.Labels, []string{"fizz", "buzz", "bar"}) {
+		t.Errorf("Got: %v Expected: %v\n", archived.Labels, []string{"fizz", "buzz", "bar"})
+	}
+}
+
+func TestStoragePutResult(t *testing.T) {
+	// It labels values of results after a first result without values, such as one that timed out.
+	storage := testStorage()
+	storage.PutResult("foo", Result{TimedOut: true}, false)
+	result, _ := storage.PutResult("foo", Result{Value: "a b", Values: Values{"a", "b"}}, false)
+	labels := storage.GetLabels("foo", []string{})
+	if !reflect.DeepEqual(labels, []string{"0", "1"}) {
+		t.Errorf("Got: %v Expected: %v\n", labels, []string{"0", "1"})
+	}
+	if got := result.Map(labels); !reflect.DeepEqual(got, map[string]interface{}{"0": "a", "1": "b"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, map[string]interface{}{"0": "a", "1": "b"})
+	}
+
+	// It keeps labels that were given.
+	storage.PutLabels("bar", []string{"fizz"})
+	storage.PutResult("bar", Result{Value: "a b", Values: Values{"a", "b"}}, false)
+	if got := storage.GetLabels("bar", []string{}); !reflect.DeepEqual(got, []string{"fizz"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"fizz"})
+	}
+}
+
+func TestStorageGetToIndexOutOfOrder(t *testing.T) {
+	var (
+		start = time.Now()
+	)
+
+	storage := testStorage()
+	storageRPC := NewStorageRPC(&storage)
+	storage.PutResult("foo", Result{Time: start, Value: "a"}, false)
+	storage.PutResult("foo", Result{Time: start.Add(2 * time.Second), Value: "b"}, false)
+	reader := storage.NewReaderIndex("foo")
+	subscription, _ := storage.Subscribe("foo", SubscribeOptions{})
+	defer subscription.Close()
+
+	// Readers neither skip nor repeat results put before others.
+	storage.PutResult("foo", Result{Time: start.Add(time.Second), Value: "c"}, false)
+	reader.Dec()
+	if got := storage.GetToIndex("foo", []string{}, reader); len(got) != 2 ||
+		got[0].Value != "a" || got[1].Value != "b" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"a", "b"})
+	}
+	reply := ResultsRPC{}
+	storageRPC.GetFrom(&ArgsRPC{Query: "foo", Index: 2}, &reply)
+	if len(reply.Results) != 1 || reply.Results[0].Value != "c" || reply.Index != 3 {
+		t.Errorf("Got: %v Expected: %v\n", reply, "c")
+	}
+
+	// Received results are read in time order.
+	if got, _ := subscription.Next(); got.Value != "c" {
+		t.Errorf("Got: %v Expected: %v\n", got.Value, "c")
+	}
+	got := storage.GetToIndex("foo", []string{}, subscription.Index())
+	if len(got) != 3 || got[0].Value != "a" || got[1].Value != "c" || got[2].Value != "b" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"a", "c", "b"})
+	}
+}
+
+func TestReadStorage(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	// It reads nothing when nothing is stored.
+	storage, err := ReadStorage(Location{})
+	if err != nil || len(storage.Queries()) != 0 {
+		t.Errorf("Got: %v Expected: %v (%v)\n", storage.Queries(), []string{}, err)
+	}
+
+	// It reads storage while it is in use, without modifying it.
+	persisted, err := NewStorage(true, Location{}, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persisted.Close()
+	persisted.Put("foo", "a", true, "a")
+	persisted.Put("bar", "b", true, "b")
+	storage, err = ReadStorage(Location{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.Queries(); !reflect.DeepEqual(got, []string{"bar", "foo"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"bar", "foo"})
+	}
+	storage.Put("foo", "c", true, "c")
+	persisted.Put("foo", "d", true, "d")
+	storage, _ = ReadStorage(Location{})
+	if got := storage.GetAll("foo"); len(got) != 2 || got[1].Value != "d" {
+		t.Errorf("Got: %v Expected: %v\n", got, "a, d")
+	}
+}
+
+// Storage is expected to be used by many queries and readers at once, e.g. under -race.
+func TestStorageConcurrency(t *testing.T) {
+	var (
+		queries = []string{"foo", "bar", "baz"}
+		puts    = 200
+		wg      sync.WaitGroup
+	)
+
+	storage, err := NewStorage(true, Location{Path: t.TempDir()}, Retention{MaxCount: 50}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	rpc := NewStorageRPC(&storage)
+
+	// Readers follow every query while results are put.
+	received := make([]int, len(queries))
+	for i, query := range queries {
+		storage.PutLabels(query, []string{"a"})
+		subscription, err := storage.Subscribe(query, SubscribeOptions{Policy: SUBSCRIBE_BLOCK})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for received[i] < puts {
+				if _, ok := subscription.Next(); !ok {
+					return
+				}
+				received[i]++
+			}
+			subscription.Close()
+		}(i)
+		go func(query string) {
+			defer wg.Done()
+			reader := storage.NewReaderIndex(query)
+			for j := 0; j < puts; j++ {
+				storage.GetAll(query)
+				storage.GetRange(query, time.Time{}, time.Time{})
+				storage.GetRollups(query, time.Minute, time.Time{}, time.Time{})
+				storage.GetToIndex(query, nil, reader)
+				storage.GetLabels(query, nil)
+				storage.Queries()
+				rpc.GetFrom(&ArgsRPC{Query: query}, &ResultsRPC{})
+			}
+		}(query)
+	}
+
+	// Writers put results, persisting them and pruning old ones.
+	for _, query := range queries {
+		wg.Add(1)
+		go func(query string) {
+			defer wg.Done()
+			for j := 0; j < puts; j++ {
+				storage.Put(query, "a", true, j)
+			}
+		}(query)
+	}
+	wg.Wait()
+
+	for i, query := range queries {
+		if got := received[i]; got != puts {
+			t.Errorf("Got: %v Expected: %v (%s)\n", got, puts, query)
+		}
+		if got := storage.GetAll(query); len(got) != 50 || got[49].Values[0] != puts-1 {
+			t.Errorf("Got: %v Expected: %v (%s)\n", len(got), 50, query)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+//
+// Subscriptions to stored results.
+//
+// Results are sent to subscribers of their query as they are put into storage. Each subscription
+// receives every result independently of others, so many consumers (e.g. displays, RPC clients) may
+// follow the same query. Subscriptions wait for results to be received up to a size, beyond which
+// what happens is up to the subscription's policy:
+//
+// - Block: putting results waits until the subscriber has room for them.
+// - Drop oldest: the oldest waiting results are dropped in favor of new ones.
+// - Catch up: new results aren't kept, and the subscriber reads what it missed from storage once it
+//   has received everything waiting. Catching up follows results in time order, so results put
+//   with times before what the subscriber has already received are skipped. Results with the same
+//   time, like rows of a table, are all received.
+
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SUBSCRIBE_BLOCK       = "block"       // Policy for waiting for subscribers to receive results.
+	SUBSCRIBE_CATCH_UP    = "catch-up"    // Policy for catching up from storage on missed results.
+	SUBSCRIBE_DROP_OLDEST = "drop-oldest" // Policy for dropping the oldest waiting results.
+	SUBSCRIBE_SIZE        = 128           // Default number of results that may wait to be received.
+)
+
+var (
+	// Supported subscription policies, with the default first.
+	SubscribePolicies = []string{SUBSCRIBE_DROP_OLDEST, SUBSCRIBE_BLOCK, SUBSCRIBE_CATCH_UP}
+)
+
+// Options for subscribing to a query.
+type SubscribeOptions struct {
+	Policy string // What happens when results aren't received fast enough, e.g. SUBSCRIBE_BLOCK.
+	Size   int    // Number of results that may wait to be received. Zero is SUBSCRIBE_SIZE.
+}
+
+// Subscription to the results of a query.
+type Subscription struct {
+	behind    bool             // Whether or not results were missed and must be caught up on.
+	done      chan struct{}    // Closed when the subscription is closed.
+	dropped   int              // Number of results dropped.
+	lastCount int              // Number of results received with the latest time, for catching up.
+	lastTime  time.Time        // Time of the latest result received, for catching up.
+	mutex     sync.Mutex       // Mutex for managing waiting results.
+	options   SubscribeOptions // Options of the subscription.
+	puts      int              // Number of results put into storage before subscribing or catch up.
+	query     string           // Query subscribed to.
+	reader    *ReaderIndex     // Sequence after the latest result received or existing.
+	ready     chan struct{}    // Signalled when results are waiting or the subscription is behind.
+	results   []Result         // Results waiting to be received, oldest first.
+	room      chan struct{}    // Signalled when results have been received, for blocked puts.
+	seqs      []int            // Sequences of results waiting to be received, for the reader index.
+	storage   *Storage         // Storage subscribed to.
+}
+
+// Subscriptions to the results of each query in storage.
+type subscribers struct {
+	mutex         sync.Mutex                 // Mutex for managing subscriptions.
+	subscriptions map[string][]*Subscription // Map of queries to subscriptions.
+}
+
+// Creates a new set of subscriptions.
+func newSubscribers() *subscribers {
+	return &subscribers{subscriptions: make(map[string][]*Subscription)}
+}
+
+// Validates subscription options.
+func (o SubscribeOptions) Validate() error {
+	if o.Policy != "" && !slices.Contains(SubscribePolicies, o.Policy) {
+		return fmt.Errorf("Bad subscription policy %q, expected one of: %s",
+			o.Policy, strings.Join(SubscribePolicies, ", "))
+	}
+	if o.Size < 0 {
+		return fmt.Errorf("Bad subscription size %d, expected zero or more", o.Size)
+	}
+
+	return nil
+}
+
+// Sends a result to a subscription, following its policy if it has no room. Results are numbered by
+// how many results were put into storage, including them, and by their sequence in their series.
+func (s *Subscription) send(result Result, put, seq int) {
+	for {
+		(*s).mutex.Lock()
+		select {
+		case <-(*s).done:
+			(*s).mutex.Unlock()
+			return
+		default:
+		}
+
+		switch {
+		case put <= (*s).puts:
+			// This was put before subscribing, or was already caught up on.
+		case (*s).options.Policy == SUBSCRIBE_CATCH_UP && result.Time.Before((*s).lastTime):
+			// This is from before what was received.
+		case (*s).behind:
+			// This will be caught up on.
+		case len((*s).results) < (*s).options.Size:
+			(*s).results, (*s).seqs = append((*s).results, result), append((*s).seqs, seq)
+			s.track(result.Time, 1)
+		case (*s).options.Policy == SUBSCRIBE_DROP_OLDEST:
+			(*s).results, (*s).seqs = append((*s).results[1:], result), append((*s).seqs[1:], seq)
+			s.track(result.Time, 1)
+			(*s).dropped++
+		case (*s).options.Policy == SUBSCRIBE_CATCH_UP:
+			(*s).behind = true
+		default:
+			// Wait for the subscriber to make room.
+			(*s).mutex.Unlock()
+			select {
+			case <-(*s).room:
+			case <-(*s).done:
+			}
+			continue
+		}
+		(*s).mutex.Unlock()
+
+		// Let the subscriber know something is waiting.
+		select {
+		case (*s).ready <- struct{}{}:
+		default:
+		}
+		return
+	}
+}
+
+// Tracks the latest time of results received, counting results received with that time.
+func (s *Subscription) track(t time.Time, count int) {
+	if t.Equal((*s).lastTime) {
+		(*s).lastCount += count
+	} else {
+		(*s).lastTime, (*s).lastCount = t, count
+	}
+}
+
+// Reads results that were missed from storage, after the latest results received. Results put up to
+// now are read here, so they aren't received again when they are sent.
+func (s *Subscription) catchUp() {
+	(*s).storage.mutex.RLock()
+	defer (*s).storage.mutex.RUnlock()
+
+	if results, ok := (*s).storage.Results[(*s).query]; ok {
+		// Skip results with the latest time that were already received.
+		start := min(
+			results.searchAt((*s).lastTime)+(*s).lastCount,
+			results.searchAfter((*s).lastTime),
+		)
+		missed := results.Results[start:]
+		(*s).results = append((*s).results, missed...)
+		for i, result := range missed {
+			(*s).seqs = append((*s).seqs, results.seq(start+i))
+			s.track(result.Time, 1)
+		}
+	}
+	(*s).puts = (*s).storage.puts
+	(*s).behind = false
+}
+
+// Receives the oldest waiting result, if there is one, catching up from storage if necessary.
+func (s *Subscription) receive() (result Result, ok bool) {
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
+
+	if len((*s).results) == 0 && (*s).behind {
+		s.catchUp()
+	}
+	if len((*s).results) == 0 {
+		return
+	}
+
+	result, (*s).results = (*s).results[0], (*s).results[1:]
+	seq := (*s).seqs[0]
+	(*s).seqs = (*s).seqs[1:]
+	(*s).reader.Set(max(int(*(*s).reader), seq+1))
+
+	// Let a blocked put know there is room.
+	select {
+	case (*s).room <- struct{}{}:
+	default:
+	}
+
+	return result, true
+}
+
+// Closes a subscription, after which it receives no more results. Waiting results may still be
+// received.
+func (s *Subscription) Close() {
+	(*s).storage.subscribers.remove(s)
+
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
+	select {
+	case <-(*s).done:
+	default:
+		close((*s).done)
+	}
+}
+
+// Retrieves the number of results dropped because they weren't received fast enough.
+func (s *Subscription) Dropped() int {
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
+
+	return (*s).dropped
+}
+
+// Retrieves the reader index of a subscription, which counts received results along with those that
+// existed before subscribing.
+func (s *Subscription) Index() *ReaderIndex {
+	return (*s).reader
+}
+
+// Receives the next result, blocking until there is one. Returns false once the subscription is
+// closed and nothing is waiting.
+func (s *Subscription) Next() (Result, bool) {
+	return s.NextWait(-1)
+}
+
+// Receives the next result, returning an empty result if nothing is waiting.
+func (s *Subscription) NextOrEmpty() Result {
+	result, _ := s.NextWait(0)
+
+	return result
+}
+
+// Receives the next result, waiting for one up to a duration. A negative duration waits until there
+// is one. Returns false if nothing was received.
+func (s *Subscription) NextWait(wait time.Duration) (Result, bool) {
+	var (
+		timeout <-chan time.Time // Signals the end of waiting.
+	)
+
+	if wait >= 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		if result, ok := s.receive(); ok {
+			return result, true
+		}
+
+		select {
+		case <-(*s).ready:
+		case <-(*s).done:
+			// Anything that arrived before closing may still be received.
+			return s.receive()
+		case <-timeout:
+			return Result{}, false
+		}
+	}
+}
+
+// Adds a subscription.
+func (s *subscribers) add(subscription *Subscription) {
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
+
+	(*s).subscriptions[subscription.query] = append((*s).subscriptions[subscription.query], subscription)
+}
+
+// Closes all subscriptions.
+func (s *subscribers) closeAll() {
+	(*s).mutex.Lock()
+	var all []*Subscription // Subscriptions to close.
+	for _, subscriptions := range (*s).subscriptions {
+		all = append(all, subscriptions...)
+	}
+	(*s).mutex.Unlock()
+
+	for _, subscription := range all {
+		subscription.Close()
+	}
+}
+
+// Sends a result to every subscription of a query, numbered by how many results were put into
+// storage, including it, along with its sequence in its series.
+func (s *subscribers) publish(query string, result Result, put, seq int) {
+	(*s).mutex.Lock()
+	subscriptions := slices.Clone((*s).subscriptions[query])
+	(*s).mutex.Unlock()
+
+	for _, subscription := range subscriptions {
+		subscription.send(result, put, seq)
+	}
+}
+
+// Removes a subscription.
+func (s *subscribers) remove(subscription *Subscription) {
+	(*s).mutex.Lock()
+	defer (*s).mutex.Unlock()
+
+	(*s).subscriptions[subscription.query] = slices.DeleteFunc(
+		(*s).subscriptions[subscription.query],
+		func(other *Subscription) bool { return other == subscription })
+	if len((*s).subscriptions[subscription.query]) == 0 {
+		delete((*s).subscriptions, subscription.query)
+	}
+}
+
+// Subscribes to the results of a query as they are put into storage. Results that already exist
+// aren't received, but are counted by the subscription's reader index. Subscriptions must be closed
+// once they are no longer needed, so that they don't hold up putting results.
+func (s *Storage) Subscribe(query string, options SubscribeOptions) (*Subscription, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.Policy == "" {
+		options.Policy = SubscribePolicies[0]
+	}
+	if options.Size == 0 {
+		options.Size = SUBSCRIBE_SIZE
+	}
+
+	// Results are sent to subscribers after being stored, so results stored before subscribing may
+	// still be sent. Only those put afterwards are received.
+	(*s).mutex.RLock()
+	defer (*s).mutex.RUnlock()
+
+	subscription := &Subscription{
+		done:    make(chan struct{}),
+		options: options,
+		puts:    (*s).puts,
+		query:   query,
+		reader:  s.newReaderIndex(query),
+		ready:   make(chan struct{}, 1),
+		room:    make(chan struct{}, 1),
+		storage: s,
+	}
+	if results, ok := (*s).Results[query]; ok && len(results.Results) > 0 {
+		lastTime := results.Results[len(results.Results)-1].Time
+		subscription.track(lastTime, len(results.Results)-results.searchAt(lastTime))
+	}
+	(*s).subscribers.add(subscription)
+
+	return subscription, nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// Receives the values of every waiting result.
+func receiveAll(subscription *Subscription) (values []string) {
+	for {
+		result := subscription.NextOrEmpty()
+		if result.IsEmpty() {
+			return
+		}
+		values = append(values, result.Value)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	storage := testStorage()
+	storage.Put("foo", "a", false)
+
+	// Every subscription receives every result.
+	first, _ := storage.Subscribe("foo", SubscribeOptions{})
+	second, _ := storage.Subscribe("foo", SubscribeOptions{})
+	storage.Put("foo", "b", false)
+	storage.Put("foo", "c", false)
+	for _, subscription := range []*Subscription{first, second} {
+		if got := receiveAll(subscription); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+			t.Errorf("Got: %v Expected: %v\n", got, []string{"b", "c"})
+		}
+		if got := *subscription.Index(); got != 3 {
+			t.Errorf("Got: %v Expected: %v\n", got, 3)
+		}
+	}
+
+	// It receives nothing once closed.
+	first.Close()
+	storage.Put("foo", "d", false)
+	if got, ok := first.Next(); ok {
+		t.Errorf("Got: %v Expected: %v\n", got, "nothing")
+	}
+	if got, ok := second.Next(); !ok || got.Value != "d" {
+		t.Errorf("Got: %v Expected: %v\n", got, "d")
+	}
+
+	// It rejects bad options.
+	if _, err := storage.Subscribe("foo", SubscribeOptions{Policy: "foo"}); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+}
+
+func TestSubscribeDropOldest(t *testing.T) {
+	storage := testStorage()
+	subscription, _ := storage.Subscribe("foo", SubscribeOptions{Policy: SUBSCRIBE_DROP_OLDEST, Size: 2})
+	for _, value := range []string{"a", "b", "c"} {
+		storage.Put("foo", value, false)
+	}
+
+	if got := receiveAll(subscription); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"b", "c"})
+	}
+	if got := subscription.Dropped(); got != 1 {
+		t.Errorf("Got: %v Expected: %v\n", got, 1)
+	}
+}
+
+func TestSubscribeBlock(t *testing.T) {
+	storage := testStorage()
+	subscription, _ := storage.Subscribe("foo", SubscribeOptions{Policy: SUBSCRIBE_BLOCK, Size: 1})
+	storage.Put("foo", "a", false)
+
+	// It waits for the subscriber to make room.
+	put := make(chan bool)
+	go func() {
+		storage.Put("foo", "b", false)
+		put <- true
+	}()
+	select {
+	case <-put:
+		t.Errorf("Got: %v Expected: %v\n", "put", "blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got, _ := subscription.Next(); got.Value != "a" {
+		t.Errorf("Got: %v Expected: %v\n", got.Value, "a")
+	}
+	<-put
+	if got, _ := subscription.Next(); got.Value != "b" {
+		t.Errorf("Got: %v Expected: %v\n", got.Value, "b")
+	}
+
+	// It stops waiting once closed.
+	storage.Put("foo", "c", false)
+	go func() {
+		storage.Put("foo", "d", false)
+		put <- true
+	}()
+	subscription.Close()
+	<-put
+}
+
+func TestSubscribeCatchUp(t *testing.T) {
+	var (
+		start = time.Now()
+	)
+
+	storage := testStorage()
+	subscription, _ := storage.Subscribe("foo", SubscribeOptions{Policy: SUBSCRIBE_CATCH_UP, Size: 1})
+	for i, value := range []string{"a", "b", "c"} {
+		storage.PutResult("foo", Result{Time: start.Add(time.Duration(i) * time.Second), Value: value}, false)
+	}
+
+	// It catches up on missed results from storage, without receiving any twice.
+	if got, _ := subscription.Next(); got.Value != "a" {
+		t.Errorf("Got: %v Expected: %v\n", got.Value, "a")
+	}
+	storage.PutResult("foo", Result{Time: start.Add(3 * time.Second), Value: "d"}, false)
+	if got := receiveAll(subscription); len(got) != 3 || got[0] != "b" || got[2] != "d" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"b", "c", "d"})
+	}
+	storage.PutResult("foo", Result{Time: start.Add(4 * time.Second), Value: "e"}, false)
+	if got := receiveAll(subscription); len(got) != 1 || got[0] != "e" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"e"})
+	}
+	if got := subscription.Dropped(); got != 0 {
+		t.Errorf("Got: %v Expected: %v\n", got, 0)
+	}
+}
+
+func TestSubscribeCatchUpRows(t *testing.T) {
+	var (
+		start = time.Now()
+	)
+
+	storage := testStorage()
+	live, _ := storage.Subscribe("foo", SubscribeOptions{Policy: SUBSCRIBE_CATCH_UP})
+	behind, _ := storage.Subscribe("foo", SubscribeOptions{Policy: SUBSCRIBE_CATCH_UP, Size: 1})
+	for _, key := range []string{"a", "b", "c"} {
+		storage.PutResult("foo", Result{Key: key, Time: start, Value: key}, false)
+	}
+
+	// It receives every row of a table put with the same time.
+	if got := receiveAll(live); len(got) != 3 || got[0] != "a" || got[2] != "c" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"a", "b", "c"})
+	}
+
+	// It catches up on rows with the same time as what was received, without receiving any twice.
+	if got, _ := behind.Next(); got.Value != "a" {
+		t.Errorf("Got: %v Expected: %v\n", got.Value, "a")
+	}
+	storage.PutResult("foo", Result{Key: "a", Time: start.Add(time.Second), Value: "d"}, false)
+	if got := receiveAll(behind); len(got) != 3 || got[0] != "b" || got[2] != "d" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"b", "c", "d"})
+	}
+}
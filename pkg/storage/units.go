@@ -0,0 +1,196 @@
+//
+// Values with human units.
+//
+// Values like '512Mi', '1.5ms', '3d4h', or '87%' may be parsed into quantities, which are numeric
+// values in a base unit that remember the unit they were given in for presenting. Units are:
+//
+// - Sizes, in bytes. Binary units are 'Ki', 'Mi', 'Gi', etc., optionally followed by 'B', as are
+//   single letters like 'K', 'M', and 'G', as output by 'df -h' or 'free -h'. Decimal units are
+//   'kB', 'MB', 'GB', etc. 'B' is bytes.
+// - Durations, in seconds. Units are 'ns', 'us' (or 'µs'), 'ms', 's', 'm', 'h', 'd', and 'w', and
+//   durations may be compound, e.g. '3d4h' or '1h30m'.
+// - Percentages, as ratios, e.g. '87%' is 0.87.
+
+package storage
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	UNIT_BYTES   = "bytes"   // Base unit of sizes.
+	UNIT_RATIO   = "ratio"   // Base unit of percentages.
+	UNIT_SECONDS = "seconds" // Base unit of durations.
+)
+
+// Unit of a quantity.
+type unit struct {
+	base  string  // Base unit, e.g. UNIT_BYTES.
+	scale float64 // Number of base units in the unit.
+}
+
+var (
+	// Supported units.
+	units = map[string]unit{
+		"%":   {UNIT_RATIO, 0.01},
+		"B":   {UNIT_BYTES, 1},
+		"K":   {UNIT_BYTES, 1 << 10},
+		"k":   {UNIT_BYTES, 1 << 10},
+		"Ki":  {UNIT_BYTES, 1 << 10},
+		"KiB": {UNIT_BYTES, 1 << 10},
+		"kB":  {UNIT_BYTES, 1e3},
+		"KB":  {UNIT_BYTES, 1e3},
+		"M":   {UNIT_BYTES, 1 << 20},
+		"Mi":  {UNIT_BYTES, 1 << 20},
+		"MiB": {UNIT_BYTES, 1 << 20},
+		"MB":  {UNIT_BYTES, 1e6},
+		"G":   {UNIT_BYTES, 1 << 30},
+		"Gi":  {UNIT_BYTES, 1 << 30},
+		"GiB": {UNIT_BYTES, 1 << 30},
+		"GB":  {UNIT_BYTES, 1e9},
+		"T":   {UNIT_BYTES, 1 << 40},
+		"Ti":  {UNIT_BYTES, 1 << 40},
+		"TiB": {UNIT_BYTES, 1 << 40},
+		"TB":  {UNIT_BYTES, 1e12},
+		"P":   {UNIT_BYTES, 1 << 50},
+		"Pi":  {UNIT_BYTES, 1 << 50},
+		"PiB": {UNIT_BYTES, 1 << 50},
+		"PB":  {UNIT_BYTES, 1e15},
+		"E":   {UNIT_BYTES, 1 << 60},
+		"Ei":  {UNIT_BYTES, 1 << 60},
+		"EiB": {UNIT_BYTES, 1 << 60},
+		"EB":  {UNIT_BYTES, 1e18},
+		"ns":  {UNIT_SECONDS, 1e-9},
+		"us":  {UNIT_SECONDS, 1e-6},
+		"µs":  {UNIT_SECONDS, 1e-6},
+		"ms":  {UNIT_SECONDS, 1e-3},
+		"s":   {UNIT_SECONDS, 1},
+		"m":   {UNIT_SECONDS, 60},
+		"h":   {UNIT_SECONDS, 60 * 60},
+		"d":   {UNIT_SECONDS, 24 * 60 * 60},
+		"w":   {UNIT_SECONDS, 7 * 24 * 60 * 60},
+	}
+	// Units compound durations are made of, largest first.
+	compoundUnits = []string{"w", "d", "h", "m", "s"}
+)
+
+// Numeric value with a unit. Values are kept in their base unit, e.g. bytes, so they may be graphed
+// and compared, along with the unit they were given in for presenting.
+type Quantity struct {
+	Value float64 // Value, in its base unit.
+	Unit  string  // Unit the value was given in, e.g. 'Mi'.
+}
+
+// Retrieves the base unit of the quantity, e.g. UNIT_BYTES.
+func (q Quantity) Base() string {
+	return units[q.Unit].base
+}
+
+// Presents the quantity in the unit it was given in, e.g. '512Mi'. Durations given in minutes or
+// longer are presented as compound durations, e.g. '3d4h'.
+func (q Quantity) String() string {
+	u, ok := units[q.Unit]
+	if !ok {
+		return formatQuantityNumber(q.Value) + q.Unit
+	}
+	if u.base == UNIT_SECONDS && u.scale >= 60 {
+		return formatCompoundDuration(q.Value, q.Unit)
+	}
+
+	return formatQuantityNumber(q.Value/u.scale) + q.Unit
+}
+
+// Formats a number of a quantity, rounded to two decimal places.
+func formatQuantityNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
+
+// Formats seconds as a compound duration, starting from a unit, e.g. '3d4h'.
+func formatCompoundDuration(seconds float64, largest string) (duration string) {
+	if seconds < 0 {
+		return "-" + formatCompoundDuration(-seconds, largest)
+	}
+
+	// Avoid presenting less than whole seconds as nearly a whole unit, e.g. '59.99999s'.
+	seconds = math.Round(seconds*100) / 100
+	for _, compoundUnit := range compoundUnits[max(slices.Index(compoundUnits, largest), 0):] {
+		scale := units[compoundUnit].scale
+		if compoundUnit == "s" {
+			if seconds > 0 {
+				duration += formatQuantityNumber(seconds) + compoundUnit
+			}
+			break
+		}
+		if n := math.Floor(seconds / scale); n > 0 {
+			duration += strconv.FormatFloat(n, 'f', -1, 64) + compoundUnit
+			seconds -= n * scale
+		}
+	}
+	if duration == "" {
+		duration = "0" + largest
+	}
+
+	return
+}
+
+// Splits the leading number from a string, returning the number and the rest of the string.
+func cutQuantityNumber(s string) (number, rest string) {
+	i := 0
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		i++
+	}
+	for i < len(s) && (s[i] == '.' || (s[i] >= '0' && s[i] <= '9')) {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+// Parses a value with a unit, e.g. '512Mi', '3d4h', or '87%'. Values without a known unit, including
+// plain numbers, aren't quantities.
+func ParseQuantity(s string) (quantity Quantity, ok bool) {
+	var (
+		previous = -1 // Index of the previous unit of a compound duration, if it may be compound.
+	)
+
+	for rest := s; rest != ""; {
+		// Parse each number and its unit.
+		number, afterNumber := cutQuantityNumber(rest)
+		unitEnd := strings.IndexFunc(afterNumber, func(r rune) bool {
+			return r != '%' && !unicode.IsLetter(r)
+		})
+		if unitEnd < 0 {
+			unitEnd = len(afterNumber)
+		}
+		unitName := afterNumber[:unitEnd]
+		rest = afterNumber[unitEnd:]
+
+		value, err := strconv.ParseFloat(number, 64)
+		u, known := units[unitName]
+		if err != nil || !known {
+			return Quantity{}, false
+		}
+
+		next := slices.Index(compoundUnits, unitName)
+		if quantity.Unit == "" {
+			quantity, previous = Quantity{Value: value * u.scale, Unit: unitName}, next
+			continue
+		}
+
+		// Only durations may be compound, with units getting smaller and without their own signs.
+		if previous < 0 || next <= previous || number[0] == '-' || number[0] == '+' {
+			return Quantity{}, false
+		}
+		if quantity.Value < 0 {
+			value = -value
+		}
+		quantity.Value += value * u.scale
+		previous = next
+	}
+
+	return quantity, quantity.Unit != ""
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		expected Quantity
+	}{
+		{"512Mi", Quantity{Value: 512 << 20, Unit: "Mi"}},
+		{"12G", Quantity{Value: 12 << 30, Unit: "G"}},
+		{"1.5GB", Quantity{Value: 1.5e9, Unit: "GB"}},
+		{"100B", Quantity{Value: 100, Unit: "B"}},
+		{"1.5ms", Quantity{Value: 0.0015, Unit: "ms"}},
+		{"3d4h", Quantity{Value: 3*24*60*60 + 4*60*60, Unit: "d"}},
+		{"-1h30m", Quantity{Value: -90 * 60, Unit: "h"}},
+		{"87%", Quantity{Value: 0.87, Unit: "%"}},
+	} {
+		// It parses values into their base units.
+		got, ok := ParseQuantity(test.value)
+		if !ok || got != test.expected {
+			t.Errorf("Got: %v Expected: %v (%s)\n", got, test.expected, test.value)
+		}
+	}
+
+	// It rejects values without a known unit, and compounds that aren't durations.
+	for _, value := range []string{"", "1", "foo", "G", "1.2.3G", "5x", "1G2M", "4h3d", "1h-30m", "1h1h"} {
+		if got, ok := ParseQuantity(value); ok {
+			t.Errorf("Got: %v Expected: %v (%s)\n", got, "not a quantity", value)
+		}
+	}
+}
+
+func TestQuantityString(t *testing.T) {
+	for _, test := range []struct {
+		quantity Quantity
+		expected string
+	}{
+		{Quantity{Value: 512 << 20, Unit: "Mi"}, "512Mi"},
+		{Quantity{Value: 1 << 30, Unit: "Mi"}, "1024Mi"},
+		{Quantity{Value: 0.0015, Unit: "ms"}, "1.5ms"},
+		{Quantity{Value: 3*24*60*60 + 4*60*60, Unit: "d"}, "3d4h"},
+		{Quantity{Value: 90.5, Unit: "m"}, "1m30.5s"},
+		{Quantity{Value: 0, Unit: "h"}, "0h"},
+		{Quantity{Value: -90 * 60, Unit: "h"}, "-1h30m"},
+		{Quantity{Value: 0.8712, Unit: "%"}, "87.12%"},
+	} {
+		// It presents quantities in their units.
+		if got := test.quantity.String(); got != test.expected {
+			t.Errorf("Got: %v Expected: %v\n", got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+//
+// Typed result values.
+//
+// Result values are integers (int64), floats (float64), strings, booleans, durations
+// (time.Duration), or quantities (Quantity). Values are persisted as JSON along with their types,
+// so they are restored exactly:
+//
+// - Integers are written as JSON integers, and floats always with a decimal point or exponent.
+// - Strings and booleans are written as their JSON equivalents.
+// - Values JSON has no equivalent for are written as an object naming their type, e.g.
+//   '{"duration":"1m30s"}', '{"float":"NaN"}', or '{"quantity":"1024","unit":"Ki"}'.
+//
+// Values persisted before types were kept are restored as integers if they are whole numbers.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	VALUE_TYPE_DURATION = "duration" // Type name for persisted durations.
+	VALUE_TYPE_FLOAT    = "float"    // Type name for persisted floats that aren't numbers in JSON.
+	VALUE_TYPE_QUANTITY = "quantity" // Type name for persisted quantities, in their base unit.
+	VALUE_TYPE_UNIT     = "unit"     // Name of the unit of persisted quantities.
+)
+
+// Converts a numeric value to a float, e.g. for graphing or metrics. Booleans are one or zero and
+// durations are seconds. Quantities are in their base unit. Values that aren't numeric can't be
+// converted.
+func ValueFloat(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	case float64:
+		return value, true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case Quantity:
+		return value.Value, true
+	case time.Duration:
+		return value.Seconds(), true
+	}
+
+	return 0, false
+}
+
+// Formats a value for presenting, e.g. in a table. Quantities are presented in their units.
+func FormatValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case Quantity:
+		return value.String()
+	case string:
+		return value
+	case time.Duration:
+		return value.String()
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Encodes a value as JSON, along with its type.
+func marshalValue(value interface{}) ([]byte, error) {
+	switch value := value.(type) {
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return json.Marshal(map[string]string{VALUE_TYPE_FLOAT: strconv.FormatFloat(value, 'g', -1, 64)})
+		}
+		valueJson := strconv.AppendFloat(nil, value, 'g', -1, 64)
+		if !bytes.ContainsAny(valueJson, ".eE") {
+			// Keep whole floats from being restored as integers.
+			valueJson = append(valueJson, ".0"...)
+		}
+		return valueJson, nil
+	case int64:
+		return strconv.AppendInt(nil, value, 10), nil
+	case Quantity:
+		return json.Marshal(map[string]string{
+			VALUE_TYPE_QUANTITY: strconv.FormatFloat(value.Value, 'g', -1, 64),
+			VALUE_TYPE_UNIT:     value.Unit,
+		})
+	case time.Duration:
+		return json.Marshal(map[string]string{VALUE_TYPE_DURATION: value.String()})
+	}
+
+	return json.Marshal(value)
+}
+
+// Decodes a value encoded with its type.
+func unmarshalValue(valueJson []byte) (value interface{}, err error) {
+	if len(valueJson) == 0 {
+		return nil, fmt.Errorf("Empty value")
+	}
+
+	switch valueJson[0] {
+	case '{':
+		var typed map[string]string // Value with its type.
+
+		if err = json.Unmarshal(valueJson, &typed); err != nil {
+			return
+		}
+		if duration, ok := typed[VALUE_TYPE_DURATION]; ok {
+			return time.ParseDuration(duration)
+		}
+		if float, ok := typed[VALUE_TYPE_FLOAT]; ok {
+			return strconv.ParseFloat(float, 64)
+		}
+		if quantity, ok := typed[VALUE_TYPE_QUANTITY]; ok {
+			quantityValue, err := strconv.ParseFloat(quantity, 64)
+			return Quantity{Value: quantityValue, Unit: typed[VALUE_TYPE_UNIT]}, err
+		}
+		return nil, fmt.Errorf("Unknown value type: %s", valueJson)
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		if !bytes.ContainsAny(valueJson, ".eE") {
+			if value, err = strconv.ParseInt(string(valueJson), 10, 64); err == nil {
+				return
+			}
+			// Integers too large for int64 are kept as floats.
+		}
+		return strconv.ParseFloat(string(valueJson), 64)
+	}
+
+	err = json.Unmarshal(valueJson, &value)
+
+	return
+}
+
+// Encodes values as JSON, along with their types.
+func (v Values) MarshalJSON() ([]byte, error) {
+	if v == nil {
+		return []byte("null"), nil
+	}
+
+	valuesJson := []byte{'['}
+	for i, value := range v {
+		if i > 0 {
+			valuesJson = append(valuesJson, ',')
+		}
+		valueJson, err := marshalValue(value)
+		if err != nil {
+			return nil, err
+		}
+		valuesJson = append(valuesJson, valueJson...)
+	}
+
+	return append(valuesJson, ']'), nil
+}
+
+// Decodes values encoded with their types.
+func (v *Values) UnmarshalJSON(valuesJson []byte) error {
+	var (
+		rawValues []json.RawMessage // Encoded values.
+	)
+
+	if err := json.Unmarshal(valuesJson, &rawValues); err != nil {
+		return err
+	}
+	if rawValues == nil {
+		*v = nil
+		return nil
+	}
+
+	*v = make(Values, len(rawValues))
+	for i, rawValue := range rawValues {
+		value, err := unmarshalValue(rawValue)
+		if err != nil {
+			return err
+		}
+		(*v)[i] = value
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValuesJSON(t *testing.T) {
+	values := Values{
+		int64(math.MaxInt64), int64(-3), 2.0, 0.5, 1e21, "1", true, nil, 90 * time.Second,
+		Quantity{Value: 1536, Unit: "Ki"},
+	}
+
+	// It writes values with their types.
+	valuesJson, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[9223372036854775807,-3,2.0,0.5,1e+21,"1",true,null,{"duration":"1m30s"},{"quantity":"1536","unit":"Ki"}]`
+	if string(valuesJson) != expected {
+		t.Errorf("Got: %v Expected: %v\n", string(valuesJson), expected)
+	}
+
+	// It restores values exactly.
+	var got Values
+	if err := json.Unmarshal(valuesJson, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("Got: %#v Expected: %#v\n", got, values)
+	}
+
+	// It keeps floats that JSON can't represent.
+	valuesJson, err = json.Marshal(Values{math.NaN(), math.Inf(-1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(valuesJson, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !math.IsNaN(got[0].(float64)) || !math.IsInf(got[1].(float64), -1) {
+		t.Errorf("Got: %v Expected: %v\n", got, "NaN and -Inf")
+	}
+
+	// It restores whole numbers persisted without types as integers.
+	if err := json.Unmarshal([]byte(`[1, 1.5, 1e30]`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Values{int64(1), 1.5, 1e30}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %#v Expected: %#v\n", got, expected)
+	}
+
+	// It rejects unknown types.
+	if err := json.Unmarshal([]byte(`[{"foo":"1"}]`), &got); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	for _, test := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{int64(3), "3"},
+		{0.25, "0.25"},
+		{"foo", "foo"},
+		{false, "false"},
+		{time.Minute, "1m0s"},
+		{Quantity{Value: 1536, Unit: "Ki"}, "1.5Ki"},
+		{nil, ""},
+	} {
+		if got := FormatValue(test.value); got != test.expected {
+			t.Errorf("Got: %v Expected: %v\n", got, test.expected)
+		}
+	}
+}
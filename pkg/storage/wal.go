@@ -0,0 +1,554 @@
+//
+// Append-only persistence for storage.
+//
+// Storage is persisted as a log of records, each on its own line and prefixed with a checksum of the
+// record:
+//
+//	<crc32 as hex> <record as json>
+//
+// Records are only ever appended, so storing a result costs the same regardless of how many results
+// exist. Records that are superseded (e.g. labels that have changed) are left in the log until it is
+// compacted, which rewrites the log with only what is needed to restore storage. If Cryptarch stops
+// in the middle of writing a record, the partial record is discarded the next time the log is read.
+//
+// Rollups are rebuilt from results as the log is read. Since results may be removed before their
+// rollups, compacting the log also writes rollups, after the results they summarize, which replace
+// whatever was rebuilt for the same span of time.
+
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	WAL_COMPACT_MIN_DEAD = 1024              // Minimum superseded records before compacting.
+	WAL_FILE_MODE        = fs.FileMode(0660) // Mode for log files.
+	WAL_FILE_NAME        = "storage.wal"     // Filename to use for the log.
+	WAL_RECORD_ARCHIVE   = "archive"         // Record for archiving a results series.
+	WAL_RECORD_HEADER    = "header"          // Record describing the log itself.
+	WAL_RECORD_RESULT    = "result"          // Record for a result.
+	WAL_RECORD_ROLLUP    = "rollup"          // Record for a rollup of results.
+	WAL_RECORD_SERIES    = "series"          // Record for a results series, without results.
+	WAL_TEMP_SUFFIX      = ".tmp"            // Suffix for logs being compacted.
+)
+
+// Record in the log.
+type walRecord struct {
+	Type string // Type of record.
+
+	Archive    string        `json:",omitempty"` // What a series was archived as, for archive records.
+	Generation int           `json:",omitempty"` // Generation of a series, for series records.
+	Labels     []string      `json:",omitempty"` // Labels of a series, for series records.
+	Query      string        `json:",omitempty"` // Query the record is for.
+	Resolution time.Duration `json:",omitempty"` // Resolution of a rollup, for rollup records.
+	Result     *Result       `json:",omitempty"` // Result, for result records.
+	Rollup     *Rollup       `json:",omitempty"` // Rollup, for rollup records.
+	Version    int           `json:",omitempty"` // Storage version, for header records.
+}
+
+// Log for persisting storage.
+type wal struct {
+	deadRecords int             // Records in the log that have been superseded.
+	file        *os.File        // Open log file, for appending.
+	liveRecords int             // Records in the log that are needed to restore storage.
+	mutex       sync.Mutex      // Mutex for managing log writes.
+	path        string          // Path to the log file.
+	series      map[string]bool // Queries with series records in the log.
+}
+
+// Encodes a record as a line in the log.
+func encodeWalRecord(record walRecord) ([]byte, error) {
+	recordJson, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(recordJson), recordJson)), nil
+}
+
+// Decodes a line in the log, without its trailing newline, as a record.
+func decodeWalRecord(line []byte) (record walRecord, err error) {
+	if len(line) < 10 || line[8] != ' ' {
+		return record, errors.New("Malformed record")
+	}
+
+	checksum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil {
+		return record, errors.New("Malformed record checksum")
+	}
+	if uint32(checksum) != crc32.ChecksumIEEE(line[9:]) {
+		return record, errors.New("Record checksum mismatch")
+	}
+	err = json.Unmarshal(line[9:], &record)
+
+	return
+}
+
+// Builds the records needed to restore a results series, with rollups after results.
+func seriesWalRecords(query string, results *Results) []walRecord {
+	records := make([]walRecord, 0, len((*results).Results)+results.rollupCount()+1)
+	records = append(records, walRecord{
+		Type:       WAL_RECORD_SERIES,
+		Generation: (*results).Generation,
+		Labels:     (*results).Labels,
+		Query:      query,
+	})
+	for i := range (*results).Results {
+		records = append(records, walRecord{
+			Type:   WAL_RECORD_RESULT,
+			Query:  query,
+			Result: &(*results).Results[i],
+		})
+	}
+	for _, series := range (*results).rollups {
+		for i := range series.rollups {
+			records = append(records, walRecord{
+				Type:       WAL_RECORD_ROLLUP,
+				Query:      query,
+				Resolution: series.policy.Resolution,
+				Rollup:     &series.rollups[i],
+			})
+		}
+	}
+
+	return records
+}
+
+// Applies a record to storage, while reading the log.
+func (w *wal) apply(storage *Storage, record walRecord) error {
+	switch record.Type {
+	case WAL_RECORD_HEADER:
+		if record.Version > STORAGE_VERSION {
+			return fmt.Errorf(
+				"Storage version %d is newer than supported version %d", record.Version, STORAGE_VERSION)
+		}
+		(*w).liveRecords++
+	case WAL_RECORD_SERIES:
+		storage.newResults(record.Query, len(record.Labels))
+		(*storage).Results[record.Query].Labels = record.Labels
+		(*storage).Results[record.Query].Generation = record.Generation
+		w.countSeries(record.Query)
+	case WAL_RECORD_RESULT:
+		if record.Result == nil {
+			return errors.New("Result record without a result")
+		}
+		storage.newResults(record.Query, len(record.Result.Values))
+		(*storage).Results[record.Query].putResult(*record.Result)
+		(*w).liveRecords++
+
+		// Results removed by retention are superseded.
+		pruned := storage.prune(record.Query)
+		(*w).deadRecords += pruned
+		(*w).liveRecords -= pruned
+	case WAL_RECORD_ROLLUP:
+		if record.Rollup == nil {
+			return errors.New("Rollup record without a rollup")
+		}
+		storage.newResults(record.Query, len(record.Rollup.Last))
+		if series := (*storage).Results[record.Query].rollupsAt(record.Resolution); series != nil {
+			// Rollups at resolutions no longer kept are dropped.
+			series.put(*record.Rollup)
+		}
+		(*w).liveRecords++
+	case WAL_RECORD_ARCHIVE:
+		if results, ok := (*storage).Results[record.Query]; ok {
+			(*storage).Results[record.Archive] = results
+			delete((*storage).Results, record.Query)
+		}
+		(*w).series[record.Archive], (*w).series[record.Query] = (*w).series[record.Query], false
+		(*w).deadRecords++
+	default:
+		return fmt.Errorf("Unknown record type %q", record.Type)
+	}
+
+	return nil
+}
+
+// Accounts for a series record, which supersedes any previous series record for the query.
+func (w *wal) countSeries(query string) {
+	if (*w).series[query] {
+		(*w).deadRecords++
+	} else {
+		(*w).series[query] = true
+		(*w).liveRecords++
+	}
+}
+
+// Determines whether or not enough records are superseded for the log to be compacted.
+func (w *wal) needsCompact() bool {
+	return (*w).deadRecords >= WAL_COMPACT_MIN_DEAD && (*w).deadRecords > (*w).liveRecords
+}
+
+// Appends records to the log, compacting the log afterwards if enough records are superseded.
+// Records must already be accounted for.
+func (w *wal) append(storage *Storage, records ...walRecord) error {
+	var (
+		lines []byte // Encoded records.
+	)
+
+	for _, record := range records {
+		line, err := encodeWalRecord(record)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line...)
+	}
+
+	(*w).mutex.Lock()
+	_, err := (*w).file.Write(lines)
+	compact := w.needsCompact()
+	(*w).mutex.Unlock()
+	if err != nil || !compact {
+		return err
+	}
+
+	return w.compact(storage)
+}
+
+// Appends a result to the log, along with its series if the log doesn't have it yet. Results
+// removed by retention when putting the result are accounted for as superseded.
+func (w *wal) appendResult(storage *Storage, query string, result Result, pruned int) error {
+	var (
+		records []walRecord // Records to append.
+	)
+
+	(*w).mutex.Lock()
+	if !(*w).series[query] {
+		records = seriesWalRecords(query, (*storage).Results[query])[:1]
+		w.countSeries(query)
+	}
+	records = append(records, walRecord{Type: WAL_RECORD_RESULT, Query: query, Result: &result})
+	(*w).liveRecords++
+	(*w).deadRecords += pruned
+	(*w).liveRecords -= pruned
+	(*w).mutex.Unlock()
+
+	return w.append(storage, records...)
+}
+
+// Appends a series, without its results, to the log.
+func (w *wal) appendSeries(storage *Storage, query string) error {
+	(*w).mutex.Lock()
+	records := seriesWalRecords(query, (*storage).Results[query])[:1]
+	w.countSeries(query)
+	(*w).mutex.Unlock()
+
+	return w.append(storage, records...)
+}
+
+// Appends the archiving of a series to the log. The series for the query is expected to be
+// appended afterwards.
+func (w *wal) appendArchive(storage *Storage, query, archive string) error {
+	(*w).mutex.Lock()
+	(*w).series[archive], (*w).series[query] = (*w).series[query], false
+	(*w).deadRecords++
+	(*w).mutex.Unlock()
+
+	return w.append(
+		storage, walRecord{Type: WAL_RECORD_ARCHIVE, Archive: archive, Query: query})
+}
+
+// Rewrites the log with only the records needed to restore storage. The new log is written
+// separately and then moved into place, so the log is never left partially written.
+func (w *wal) compact(storage *Storage) (err error) {
+	var (
+		queries []string // Queries in storage, ordered for writing.
+	)
+
+	(*w).mutex.Lock()
+	defer (*w).mutex.Unlock()
+
+	tempPath := (*w).path + WAL_TEMP_SUFFIX
+	tempFile, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, WAL_FILE_MODE)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	for query := range (*storage).Results {
+		queries = append(queries, query)
+	}
+	sort.Strings(queries)
+
+	writer := bufio.NewWriter(tempFile)
+	records := []walRecord{{Type: WAL_RECORD_HEADER, Version: STORAGE_VERSION}}
+	series := make(map[string]bool, len(queries))
+	for _, query := range queries {
+		records = append(records, seriesWalRecords(query, (*storage).Results[query])...)
+		series[query] = true
+	}
+	for _, record := range records {
+		line, err := encodeWalRecord(record)
+		if err != nil {
+			return err
+		}
+		if _, err = writer.Write(line); err != nil {
+			return err
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		return
+	}
+	if err = tempFile.Sync(); err != nil {
+		return
+	}
+	if err = os.Rename(tempPath, (*w).path); err != nil {
+		return
+	}
+
+	// Continue appending to the new log.
+	(*w).file.Close()
+	(*w).file, (*w).series = tempFile, series
+	(*w).deadRecords, (*w).liveRecords = 0, len(records)
+	slog.Debug("Compacted storage", "path", (*w).path, "records", len(records))
+
+	return
+}
+
+// Closes the log.
+func (w *wal) close() error {
+	(*w).mutex.Lock()
+	defer (*w).mutex.Unlock()
+
+	return (*w).file.Close()
+}
+
+// Replays a log into storage. Returns the offset after the last complete record, and whether or
+// not the last record was only partially written.
+func (w *wal) replay(
+	path string,
+	file io.Reader,
+	storage *Storage,
+) (offset int64, torn bool, err error) {
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return offset, false, readErr
+		}
+		if len(line) == 0 {
+			// We've read everything.
+			return offset, false, nil
+		}
+
+		record, decodeErr := decodeWalRecord(line[:len(line)-1])
+		if readErr == io.EOF || decodeErr != nil {
+			if _, peekErr := reader.Peek(1); readErr != io.EOF && peekErr != io.EOF {
+				// This isn't the last record, so this isn't a partial write.
+				return offset, false, fmt.Errorf(
+					"Corrupt storage record at %s:%d: %v", path, offset, decodeErr)
+			}
+
+			return offset, true, nil
+		}
+		if err = w.apply(storage, record); err != nil {
+			return offset, false, fmt.Errorf("Bad storage record at %s:%d: %v", path, offset, err)
+		}
+
+		offset += int64(len(line))
+	}
+}
+
+// Opens a log, restoring storage from it. If the last record was only partially written, it is
+// discarded.
+func openWal(path string, storage *Storage) (w *wal, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, WAL_FILE_MODE)
+	if err != nil {
+		return
+	}
+	w = &wal{file: file, path: filepath.Clean(path), series: make(map[string]bool)}
+
+	offset, torn, err := w.replay(path, file, storage)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if torn {
+		slog.Warn("Discarding partially written storage record", "path", path, "offset", offset)
+		if err = file.Truncate(offset); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	// Append from the end of the log, where any new log starts with a header.
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if offset == 0 {
+		(*w).liveRecords++
+		err = w.append(storage, walRecord{Type: WAL_RECORD_HEADER, Version: STORAGE_VERSION})
+	}
+
+	return
+}
+
+// Restores storage from a log without modifying it, e.g. while something else may be appending to
+// it. A partially written last record is ignored.
+func readWal(path string, storage *Storage) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := &wal{path: filepath.Clean(path), series: make(map[string]bool)}
+	_, _, err = w.replay(path, file, storage)
+
+	return err
+}
+
+// Backend persisting storage to a log.
+type walBackend struct {
+	path     string   // Path to the log file.
+	snapshot *Storage // Storage read from the log, when reading it directly without opening it.
+	storage  *Storage // Storage being persisted, once opened.
+	wal      *wal     // Open log, once opened.
+}
+
+// Restores storage from the log, after which changes to storage are appended to it. The log is
+// compacted if enough of it was removed by retention.
+func (b *walBackend) Open(storage *Storage) (err error) {
+	(*b).wal, err = openWal((*b).path, storage)
+	if err != nil {
+		return
+	}
+	(*b).storage = storage
+
+	if (*b).wal.needsCompact() {
+		err = (*b).wal.compact(storage)
+	}
+
+	return
+}
+
+// Restores storage from the log without modifying it.
+func (b *walBackend) Read(storage *Storage) error {
+	return readWal((*b).path, storage)
+}
+
+// Appends a result to the log.
+func (b *walBackend) PutResult(query string, result Result, pruned int) error {
+	return (*b).wal.appendResult((*b).storage, query, result, pruned)
+}
+
+// Appends a series to the log.
+func (b *walBackend) PutSeries(query string) error {
+	return (*b).wal.appendSeries((*b).storage, query)
+}
+
+// Appends the archiving of a series to the log.
+func (b *walBackend) PutArchive(query, archive string) error {
+	return (*b).wal.appendArchive((*b).storage, query, archive)
+}
+
+// Compacts the log, so that removed results and rollups are no longer in it.
+func (b *walBackend) Prune(query string) error {
+	return (*b).wal.compact((*b).storage)
+}
+
+// Compacts the log, which rewrites it with everything in storage.
+func (b *walBackend) Save() error {
+	return (*b).wal.compact((*b).storage)
+}
+
+// Retrieves storage for reading the log directly. Once the log is opened this is the storage being
+// persisted, and otherwise the log is read once.
+func (b *walBackend) read() (*Storage, error) {
+	if (*b).storage != nil {
+		return (*b).storage, nil
+	}
+	if (*b).snapshot != nil {
+		return (*b).snapshot, nil
+	}
+
+	snapshot, _ := NewStorage(false, Location{}, Retention{}, nil)
+	if err := readWal((*b).path, &snapshot); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	(*b).snapshot = &snapshot
+
+	return (*b).snapshot, nil
+}
+
+// Retrieves the number of results of a series in the log, and the times of its first and last, as
+// counted when the log was replayed.
+func (b *walBackend) Bounds(query string) (count int, first, last time.Time, err error) {
+	snapshot, err := b.read()
+	if err != nil {
+		return
+	}
+	if _, ok := (*snapshot).Results[query]; !ok {
+		return 0, first, last, &QueryNotFoundError{Query: query}
+	}
+	count, first, last = snapshot.GetBounds(query)
+
+	return
+}
+
+// Retrieves the labels of a series in the log.
+func (b *walBackend) Labels(query string) ([]string, error) {
+	snapshot, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := (*snapshot).Results[query]; !ok {
+		return nil, &QueryNotFoundError{Query: query}
+	}
+
+	return snapshot.GetLabels(query, []string{}), nil
+}
+
+// Retrieves results of a series in the log between two times.
+func (b *walBackend) Range(query string, startTime, endTime time.Time) ([]Result, error) {
+	snapshot, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := (*snapshot).Results[query]; !ok {
+		return nil, &QueryNotFoundError{Query: query}
+	}
+
+	return snapshot.GetRange(query, startTime, endTime), nil
+}
+
+// Retrieves the queries of series in the log.
+func (b *walBackend) Series() ([]string, error) {
+	snapshot, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot.Queries(), nil
+}
+
+// Closes the log, if it was opened.
+func (b *walBackend) Close() error {
+	if (*b).wal == nil {
+		return nil
+	}
+	err := (*b).wal.close()
+	(*b).wal = nil
+
+	return err
+}
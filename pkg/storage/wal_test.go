@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Opens a test log, restoring storage from it.
+func testWal(t testing.TB, path string) (*Storage, *wal) {
+	storage := testStorage()
+	w, err := openWal(path, &storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.backend = &walBackend{path: path, storage: &storage, wal: w}
+
+	return &storage, w
+}
+
+// Compares storage results as they would be persisted, since restored times lose their monotonic
+// clock readings.
+func equalStoredResults(a, b map[string]*Results) bool {
+	aJson, _ := json.Marshal(a)
+	bJson, _ := json.Marshal(b)
+
+	return string(aJson) == string(bJson)
+}
+
+func TestWalRecord(t *testing.T) {
+	record := walRecord{Type: WAL_RECORD_SERIES, Labels: []string{"foo", "bar"}, Query: "foo"}
+
+	// It decodes what it encodes.
+	line, err := encodeWalRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeWalRecord(line[:len(line)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, record) {
+		t.Errorf("Got: %v Expected: %v\n", got, record)
+	}
+
+	// It detects damaged records.
+	line[len(line)-3] = 'x'
+	if _, err := decodeWalRecord(line[:len(line)-1]); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+}
+
+func TestWal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), WAL_FILE_NAME)
+
+	storage, w := testWal(t, path)
+	storage.Put("foo", "a b", true, "a", "b")
+	storage.PutLabels("foo", []string{"fizz", "buzz"})
+	storage.Put("foo", "c d", true, "c", "d")
+	storage.PutLabels("foo", []string{"bar"})
+	storage.Put("foo", "e", true, "e")
+	storage.Put("bar", "f", true, "f")
+	w.close()
+
+	// It restores storage, including labels and archived series.
+	restored, w := testWal(t, path)
+	defer w.close()
+	if !equalStoredResults(restored.Results, storage.Results) {
+		t.Errorf("Got: %v Expected: %v\n", restored.Results, storage.Results)
+	}
+	if got := restored.Results["foo#0"].Labels; !reflect.DeepEqual(got, []string{"fizz", "buzz"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"fizz", "buzz"})
+	}
+
+	// It restores storage the same way after compacting.
+	if err := w.compact(restored); err != nil {
+		t.Fatal(err)
+	}
+	if (*w).deadRecords != 0 {
+		t.Errorf("Got: %v Expected: %v\n", (*w).deadRecords, 0)
+	}
+	compacted, compactedW := testWal(t, path)
+	compactedW.close()
+	if !equalStoredResults(compacted.Results, storage.Results) {
+		t.Errorf("Got: %v Expected: %v\n", compacted.Results, storage.Results)
+	}
+}
+
+func TestWalCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), WAL_FILE_NAME)
+
+	// It compacts once enough records are superseded.
+	storage, w := testWal(t, path)
+	defer w.close()
+	for i := 0; i <= WAL_COMPACT_MIN_DEAD; i++ {
+		storage.PutLabels("foo", []string{fmt.Sprint(i)})
+	}
+	if (*w).deadRecords >= WAL_COMPACT_MIN_DEAD {
+		t.Errorf("Got: %v Expected: %v\n", (*w).deadRecords, "compaction")
+	}
+	restored, restoredW := testWal(t, path)
+	restoredW.close()
+	expected := []string{fmt.Sprint(WAL_COMPACT_MIN_DEAD)}
+	if got := restored.Results["foo"].Labels; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+}
+
+func TestWalRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), WAL_FILE_NAME)
+
+	storage, w := testWal(t, path)
+	storage.Put("foo", "a", true, "a")
+	storage.Put("foo", "b", true, "b")
+	w.close()
+	walData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It discards a partially written last record and continues appending after it.
+	os.WriteFile(path, walData[:len(walData)-5], 0660)
+	restored, w := testWal(t, path)
+	if got := len(restored.GetAll("foo")); got != 1 {
+		t.Errorf("Got: %v Expected: %v\n", got, 1)
+	}
+	restored.Put("foo", "c", true, "c")
+	w.close()
+	restored, w = testWal(t, path)
+	w.close()
+	if got := restored.GetAll("foo"); len(got) != 2 || got[1].Value != "c" {
+		t.Errorf("Got: %v Expected: %v\n", got, "a, c")
+	}
+
+	// It refuses damaged records that aren't the last.
+	damaged := append([]byte{}, walData...)
+	damaged[20] = '!'
+	os.WriteFile(path, damaged, 0660)
+	if _, err := openWal(path, &Storage{}); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "error")
+	}
+}
+
+func TestNewStorageMigration(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	// Write storage from before the log.
+	userCacheDir, _ := os.UserCacheDir()
+	storageDir := filepath.Join(userCacheDir, STORAGE_FILE_DIR)
+	os.MkdirAll(storageDir, 0770)
+	os.WriteFile(filepath.Join(storageDir, STORAGE_FILE_NAME), []byte(`{
+		"Version": 1,
+		"Results": {
+			"foo": {"Labels": ["foo", "bar"], "Results": [{"Time": "2024-01-01T00:00:00Z", "Value": "a b"}]}
+		}
+	}`), 0660)
+
+	// It migrates storage to the log.
+	storage, err := NewStorage(true, Location{}, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+	if _, err := os.Stat(filepath.Join(storageDir, STORAGE_FILE_NAME+STORAGE_MIGRATED_EXT)); err != nil {
+		t.Errorf("Got: %v Expected: %v\n", err, nil)
+	}
+	storage, err = NewStorage(true, Location{}, Retention{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if got := storage.GetAll("foo"); len(got) != 1 || got[0].Value != "a b" {
+		t.Errorf("Got: %v Expected: %v\n", got, "a b")
+	}
+}
+
+// Put cost should stay flat regardless of how many results are already stored.
+func BenchmarkStoragePut(b *testing.B) {
+	for _, history := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("history=%d", history), func(b *testing.B) {
+			storage, w := testWal(b, filepath.Join(b.TempDir(), WAL_FILE_NAME))
+			defer w.close()
+			for i := 0; i < history; i++ {
+				storage.Put("foo", "a b c", true, 1, 2, 3)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				storage.Put("foo", "a b c", true, 1, 2, 3)
+			}
+		})
+	}
+}